@@ -4,9 +4,13 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/darren-west/app/auth-service/models"
 	"github.com/darren-west/app/utils/httputil"
 	"github.com/darren-west/app/utils/jwt"
+	"github.com/google/uuid"
 	"github.com/hashicorp/errwrap"
 	"gopkg.in/resty.v1"
 )
@@ -27,16 +31,26 @@ func WithBaseAddress(base string) Option {
 func WithRoundTripper(roundTripper http.RoundTripper) Option {
 	return func(s *Service) {
 		s.httpClient.SetTransport(roundTripper)
+		s.onceClient.SetTransport(roundTripper)
 	}
 }
 
-// WithRetryCount sets the number of times to retry.
+// WithRetryCount sets the number of times to retry. Requests that can only succeed once, refreshing or revoking a
+// token and authenticating with a client assertion, are never retried.
 func WithRetryCount(retries int) Option {
 	return func(s *Service) {
 		s.httpClient.SetRetryCount(retries)
 	}
 }
 
+// WithTokenSource sets the source of access tokens to authorize requests with. A bearer token on the request
+// context is used instead when there is one.
+func WithTokenSource(tokens httputil.TokenSource) Option {
+	return func(s *Service) {
+		s.tokens = tokens
+	}
+}
+
 // Option is a function for setting options on the Service.
 type Option func(*Service)
 
@@ -44,6 +58,7 @@ type Option func(*Service)
 func New(opts ...Option) Service {
 	s := Service{
 		httpClient: resty.New(),
+		onceClient: resty.New(),
 		base:       "http://localhost/api/auth",
 	}
 	for _, opt := range opts {
@@ -58,31 +73,238 @@ func New(opts ...Option) Service {
 // Service is a client to the auth service.
 type Service struct {
 	httpClient *resty.Client
+	// onceClient sends requests without retries, for requests that use up a token or assertion. A retry after a
+	// response is lost would be rejected, or for a refresh token would revoke the whole family as reused.
+	onceClient *resty.Client
 	base       string
+	tokens     httputil.TokenSource
+}
+
+// request returns a request with the context, authorized with the bearer token on the context or from the token
+// source if there is one.
+func (s Service) request(ctx context.Context) (*resty.Request, error) {
+	req := s.httpClient.R().SetContext(ctx)
+	if token, ok := httputil.BearerTokenFromContext(ctx); ok {
+		return req.SetAuthToken(token), nil
+	}
+	if s.tokens != nil {
+		token, err := s.tokens.Token(ctx)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to get access token: {{err}}", err)
+		}
+		req.SetAuthToken(token.Value)
+	}
+	return req, nil
 }
 
-// ExchangeToken returns a signed jwt token for the user given. It is signed in the auth service.
-func (s Service) ExchangeToken(ctx context.Context, user jwt.User) (token string, err error) {
-	if token, err = s.exchangeToken(ctx, user); err != nil {
+// ExchangeToken returns a signed jwt access token and a refresh token for the user given. They are issued by
+// the auth service.
+func (s Service) ExchangeToken(ctx context.Context, user jwt.User) (tokens models.Tokens, err error) {
+	if tokens, err = s.postTokens(ctx, "/token", &user); err != nil {
 		err = errwrap.Wrapf("exchange token failed: {{err}}", err)
 		return
 	}
 	return
 }
 
-func (s Service) exchangeToken(ctx context.Context, user jwt.User) (string, error) {
-	resp, err := s.httpClient.R().
+// RefreshToken exchanges the refresh token for a new access token and refresh token, authenticated as the
+// service client the refresh token was issued to. The refresh token given can't be used again.
+func (s Service) RefreshToken(ctx context.Context, credentials Credentials, refreshToken string) (tokens models.Tokens, err error) {
+	tokens, err = func() (tokens models.Tokens, err error) {
+		req, form, err := s.authenticatedRequest(ctx, credentials, true)
+		if err != nil {
+			return
+		}
+		form["refresh_token"] = refreshToken
+		resp, err := req.
+			SetFormData(form).
+			SetResult(&tokens).
+			Post(s.pathf("/%s", "token/refresh"))
+		if err != nil {
+			return
+		}
+		if resp.StatusCode() != http.StatusOK {
+			err = httputil.NewError(resp.StatusCode()).WithMessage(string(resp.Body()))
+		}
+		return
+	}()
+	if err != nil {
+		err = errwrap.Wrapf("refresh token failed: {{err}}", err)
+	}
+	return
+}
+
+// Introspect returns the description of the access or refresh token given, authenticated as the service client.
+// Tokens that are invalid, expired or revoked are returned as not active.
+func (s Service) Introspect(ctx context.Context, credentials Credentials, token string) (introspection models.Introspection, err error) {
+	introspection, err = func() (introspection models.Introspection, err error) {
+		req, form, err := s.authenticatedRequest(ctx, credentials, false)
+		if err != nil {
+			return
+		}
+		form["token"] = token
+		resp, err := req.
+			SetFormData(form).
+			SetResult(&introspection).
+			Post(s.pathf("/%s", "introspect"))
+		if err != nil {
+			return
+		}
+		if resp.StatusCode() != http.StatusOK {
+			err = httputil.NewError(resp.StatusCode()).WithMessage(string(resp.Body()))
+		}
+		return
+	}()
+	if err != nil {
+		err = errwrap.Wrapf("introspect token failed: {{err}}", err)
+	}
+	return
+}
+
+// Revoke revokes the access or refresh token given, authenticated as the service client. Only the client the
+// token was issued to can revoke it.
+func (s Service) Revoke(ctx context.Context, credentials Credentials, token string) (err error) {
+	err = func() (err error) {
+		req, form, err := s.authenticatedRequest(ctx, credentials, true)
+		if err != nil {
+			return
+		}
+		form["token"] = token
+		resp, err := req.
+			SetFormData(form).
+			Post(s.pathf("/%s", "revoke"))
+		if err != nil {
+			return
+		}
+		if resp.StatusCode() != http.StatusOK {
+			err = httputil.NewError(resp.StatusCode()).WithMessage(string(resp.Body()))
+		}
+		return
+	}()
+	if err != nil {
+		err = errwrap.Wrapf("revoke token failed: {{err}}", err)
+	}
+	return
+}
+
+// StatusCode returns the status code of the auth service response that caused an error returned by the client, or
+// 0 when the error was not caused by a response.
+func StatusCode(err error) (statusCode int) {
+	errwrap.Walk(err, func(err error) {
+		if e, ok := err.(httputil.Error); ok {
+			statusCode = e.StatusCode()
+		}
+	})
+	return
+}
+
+func (s Service) postTokens(ctx context.Context, path string, body interface{}) (tokens models.Tokens, err error) {
+	req, err := s.request(ctx)
+	if err != nil {
+		return
+	}
+	resp, err := req.
 		SetHeader("Content-Type", "application/json").
-		SetBody(&user).
-		SetContext(ctx).
-		Post(s.pathf("/%s", "token"))
+		SetBody(body).
+		SetResult(&tokens).
+		Post(s.pathf("%s", path))
 	if err != nil {
-		return "", err
+		return
 	}
 	if resp.StatusCode() != http.StatusOK {
-		return "", httputil.NewError(resp.StatusCode()).WithMessage(string(resp.Body()))
+		err = httputil.NewError(resp.StatusCode()).WithMessage(string(resp.Body()))
+		return
+	}
+	return
+}
+
+// Credentials identify a service client to get tokens with the client credentials grant. Clients registered
+// with a secret set the Secret, clients registered with a public key set the Keys, assertions are signed with the
+// active key in the set.
+type Credentials struct {
+	ClientID string
+	Secret   string
+	Keys     *jwt.KeySet
+	// Scopes requested, every scope the client is allowed is given when there are none.
+	Scopes []string
+}
+
+// ClientCredentials returns an access token for the service client. There is no refresh token, a new access token
+// is requested with the credentials when it expires.
+func (s Service) ClientCredentials(ctx context.Context, credentials Credentials) (tokens models.Tokens, err error) {
+	tokens, err = func() (tokens models.Tokens, err error) {
+		req, form, err := s.authenticatedRequest(ctx, credentials, false)
+		if err != nil {
+			return
+		}
+		form["grant_type"] = "client_credentials"
+		if len(credentials.Scopes) != 0 {
+			form["scope"] = strings.Join(credentials.Scopes, " ")
+		}
+		resp, err := req.SetFormData(form).SetResult(&tokens).Post(s.pathf("/%s", "token/client"))
+		if err != nil {
+			return
+		}
+		if resp.StatusCode() != http.StatusOK {
+			err = httputil.NewError(resp.StatusCode()).WithMessage(string(resp.Body()))
+		}
+		return
+	}()
+	if err != nil {
+		err = errwrap.Wrapf("client credentials failed: {{err}}", err)
 	}
-	return string(resp.Body()), nil
+	return
+}
+
+// authenticatedRequest returns a request with the context authenticated as the service client, and the form to
+// send with it. Clients with a secret use basic auth, clients with keys send an assertion in the form. Assertions
+// are for the token endpoint whichever endpoint they are sent to, as the auth service only accepts assertions for it.
+// The request is not retried when once is set or it carries an assertion, as either can only be accepted once.
+func (s Service) authenticatedRequest(ctx context.Context, credentials Credentials, once bool) (*resty.Request, map[string]string, error) {
+	httpClient := s.httpClient
+	if once || credentials.Keys != nil {
+		httpClient = s.onceClient
+	}
+	req, form := httpClient.R().SetContext(ctx), map[string]string{}
+	if credentials.Keys == nil {
+		req.SetBasicAuth(credentials.ClientID, credentials.Secret)
+		return req, form, nil
+	}
+	assertion, err := clientAssertion(credentials, s.pathf("/%s", "token/client"))
+	if err != nil {
+		return nil, nil, err
+	}
+	form["client_assertion_type"] = models.ClientAssertionType
+	form["client_assertion"] = assertion.String()
+	return req, form, nil
+}
+
+// clientAssertion signs a single use assertion identifying the client, for the token endpoint at the url.
+func clientAssertion(credentials Credentials, url string) (jwt.Token, error) {
+	now := time.Now()
+	return jwt.NewWriter(jwt.WriterBuilder.WithKeySet(credentials.Keys)).Write(&jwt.Claims{
+		Issuer:    credentials.ClientID,
+		Subject:   credentials.ClientID,
+		Audience:  jwt.Audience{url},
+		ID:        uuid.New().String(),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		IssuedAt:  now.Unix(),
+	})
+}
+
+// TokenSource returns a token source of access tokens for the service client, for clients of other services to
+// authorize their requests with. The token is reused until a minute before it expires.
+func (s Service) TokenSource(credentials Credentials) httputil.TokenSource {
+	return httputil.ReuseTokenSource(httputil.TokenSourceFunc(func(ctx context.Context) (httputil.AccessToken, error) {
+		tokens, err := s.ClientCredentials(ctx, credentials)
+		if err != nil {
+			return httputil.AccessToken{}, err
+		}
+		return httputil.AccessToken{
+			Value:     tokens.AccessToken,
+			ExpiresAt: time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second),
+		}, nil
+	}), time.Minute)
 }
 
 func (s Service) pathf(format string, args ...interface{}) string {
@@ -9,6 +9,8 @@ import (
 	"testing"
 
 	"github.com/darren-west/app/auth-service/client"
+	"github.com/darren-west/app/auth-service/models"
+	"github.com/darren-west/app/utils/fileutil"
 	"github.com/darren-west/app/utils/jwt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -21,7 +23,8 @@ func TestClientExchangeTokenSuccess(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, "{\"id\":\"1234\",\"first_name\":\"foo\",\"last_name\":\"bar\",\"email\":\"foo@email.com\"}", string(data))
 		resp.StatusCode = http.StatusOK
-		resp.Body = ioutil.NopCloser(bytes.NewBufferString("foo"))
+		resp.Header = http.Header{"Content-Type": []string{"application/json"}}
+		resp.Body = ioutil.NopCloser(bytes.NewBufferString(`{"access_token":"foo","token_type":"Bearer","expires_in":900,"refresh_token":"bar"}`))
 		return
 	})
 
@@ -37,7 +40,52 @@ func TestClientExchangeTokenSuccess(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	assert.Equal(t, "foo", tok)
+	assert.Equal(t, models.Tokens{AccessToken: "foo", TokenType: "Bearer", ExpiresIn: 900, RefreshToken: "bar"}, tok)
+}
+
+func TestClientRefreshTokenSuccess(t *testing.T) {
+	fn := RoundTripperFunc(func(req *http.Request) (resp *http.Response, err error) {
+		resp = new(http.Response)
+		assert.Equal(t, "/token/refresh", req.URL.Path)
+		id, secret, ok := req.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "oauth-service", id)
+		assert.Equal(t, "secret", secret)
+		require.NoError(t, req.ParseForm())
+		assert.Equal(t, "bar", req.PostForm.Get("refresh_token"))
+		resp.StatusCode = http.StatusOK
+		resp.Header = http.Header{"Content-Type": []string{"application/json"}}
+		resp.Body = ioutil.NopCloser(bytes.NewBufferString(`{"access_token":"foo2","token_type":"Bearer","expires_in":900,"refresh_token":"bar2"}`))
+		return
+	})
+
+	service := client.New(
+		client.WithBaseAddress("http://localhost/"),
+		client.WithRoundTripper(fn),
+	)
+	tok, err := service.RefreshToken(context.Background(), client.Credentials{ClientID: "oauth-service", Secret: "secret"}, "bar")
+	require.NoError(t, err)
+
+	assert.Equal(t, "foo2", tok.AccessToken)
+	assert.Equal(t, "bar2", tok.RefreshToken)
+}
+
+func TestClientRefreshTokenUnauthorized(t *testing.T) {
+	fn := RoundTripperFunc(func(req *http.Request) (resp *http.Response, err error) {
+		resp = new(http.Response)
+		resp.StatusCode = http.StatusUnauthorized
+		resp.Body = ioutil.NopCloser(bytes.NewBufferString("refresh token reused"))
+		return
+	})
+
+	service := client.New(
+		client.WithBaseAddress("http://localhost/"),
+		client.WithRoundTripper(fn),
+	)
+	_, err := service.RefreshToken(context.Background(), client.Credentials{ClientID: "oauth-service", Secret: "secret"}, "bar")
+	require.EqualError(t, err, "refresh token failed: refresh token reused")
+	assert.Equal(t, http.StatusUnauthorized, client.StatusCode(err))
+	assert.Equal(t, 0, client.StatusCode(errors.New("boom")))
 }
 
 func TestClientExchangeTokenRetry(t *testing.T) {
@@ -69,6 +117,51 @@ func TestClientExchangeTokenRetry(t *testing.T) {
 	assert.Equal(t, 2, count)
 }
 
+func TestClientSingleUseRequestsNotRetried(t *testing.T) {
+	active, signingKeys, err := jwt.ReadKeySet(fileutil.FileReader{}, "../../utils/jwt/testdata/keys.json")
+	require.NoError(t, err)
+	keys, err := jwt.NewKeySet(active, signingKeys...)
+	require.NoError(t, err)
+	secret := client.Credentials{ClientID: "oauth-service", Secret: "secret"}
+	assertion := client.Credentials{ClientID: "billing", Keys: keys}
+
+	tests := []struct {
+		name string
+		call func(service client.Service) error
+	}{
+		{"refresh", func(service client.Service) error {
+			_, err := service.RefreshToken(context.Background(), secret, "bar")
+			return err
+		}},
+		{"revoke", func(service client.Service) error {
+			return service.Revoke(context.Background(), secret, "bar")
+		}},
+		{"client assertion", func(service client.Service) error {
+			_, err := service.ClientCredentials(context.Background(), assertion)
+			return err
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count := 0
+			fn := RoundTripperFunc(func(req *http.Request) (resp *http.Response, err error) {
+				count++
+				resp = new(http.Response)
+				resp.StatusCode = http.StatusInternalServerError
+				resp.Body = ioutil.NopCloser(bytes.NewBufferString("foo"))
+				return
+			})
+			service := client.New(
+				client.WithBaseAddress("http://localhost/"),
+				client.WithRoundTripper(fn),
+				client.WithRetryCount(3),
+			)
+			assert.Error(t, test.call(service))
+			assert.Equal(t, 1, count)
+		})
+	}
+}
+
 func TestClientExchangeTokenError(t *testing.T) {
 	fn := RoundTripperFunc(func(req *http.Request) (resp *http.Response, err error) {
 		err = errors.New("boom")
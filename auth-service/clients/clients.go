@@ -0,0 +1,259 @@
+// Package clients registers the services that get their own tokens with the client credentials grant, and
+// authenticates them. Clients authenticate with a secret, or with a jwt assertion signed by their private key.
+package clients
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/darren-west/app/auth-service/models"
+	"github.com/darren-west/app/auth-service/revocation"
+	"github.com/darren-west/app/utils/jwt"
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+// MaxAssertionLifetime is the longest an assertion can be valid for. Assertions are single use, their ids are
+// remembered until they expire.
+const MaxAssertionLifetime = time.Minute * 10
+
+var (
+	// ErrInvalidClient is returned when the client is unknown or its credentials are wrong.
+	ErrInvalidClient = errors.New("client authentication failed")
+	// ErrInvalidScope is returned when a client requests a scope it isn't allowed.
+	ErrInvalidScope = errors.New("requested scope is not allowed")
+)
+
+// Client is a service that can get tokens with the client credentials grant.
+type Client struct {
+	ID string
+	// SecretHash is the hex encoded sha256 hash of the clients secret.
+	SecretHash string
+	// PublicKey verifies the assertions of clients that authenticate with a private key.
+	PublicKey *rsa.PublicKey
+	// Scopes are the scopes the client is allowed to request.
+	Scopes []string
+}
+
+// HashSecret returns the hash of the secret to register a client with.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// HasScope returns true if the client is allowed the scope.
+func (c Client) HasScope(scope string) bool {
+	return contains(c.Scopes, scope)
+}
+
+// AllowedScopes returns the scopes requested if the client is allowed all of them, and every scope the client
+// is allowed when none are requested.
+func (c Client) AllowedScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return c.Scopes, nil
+	}
+	for _, scope := range requested {
+		if !contains(c.Scopes, scope) {
+			return nil, ErrInvalidScope
+		}
+	}
+	return requested, nil
+}
+
+// publicKey returns the clients public key whatever the key id, it is used as the key source for assertions.
+func (c Client) publicKey(string) (crypto.PublicKey, error) {
+	return c.PublicKey, nil
+}
+
+type keySource func(kid string) (crypto.PublicKey, error)
+
+func (f keySource) PublicKey(kid string) (crypto.PublicKey, error) {
+	return f(kid)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRegistry returns a registry of the clients given.
+func NewRegistry(clients ...Client) (*Registry, error) {
+	r := &Registry{}
+	if err := r.Set(clients...); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Registry holds the registered clients. The clients can be replaced while in use.
+type Registry struct {
+	mu      sync.RWMutex
+	clients map[string]Client
+}
+
+// Set replaces the registered clients. Every client needs an id, and a secret hash or public key.
+func (r *Registry) Set(clients ...Client) error {
+	byID := make(map[string]Client, len(clients))
+	for _, c := range clients {
+		if c.ID == "" {
+			return errors.New("invalid clients: client id is empty")
+		}
+		if c.SecretHash == "" && c.PublicKey == nil {
+			return fmt.Errorf("invalid clients: client %s has no secret or public key", c.ID)
+		}
+		if _, ok := byID[c.ID]; ok {
+			return fmt.Errorf("invalid clients: duplicate client id %s", c.ID)
+		}
+		byID[c.ID] = c
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients = byID
+	return nil
+}
+
+// Client returns the client with the id.
+func (r *Registry) Client(id string) (Client, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.clients[id]
+	return c, ok
+}
+
+// Authenticator authenticates clients calling the token endpoint.
+type Authenticator struct {
+	Clients *Registry
+	// Audience is the audience assertions must be for, the url of the token endpoint.
+	Audience string
+	// Assertions remembers the ids of used assertions so they can't be replayed.
+	Assertions revocation.Denylist
+	now        func() time.Time
+}
+
+// NewAuthenticator returns an authenticator for the registered clients.
+func NewAuthenticator(clients *Registry, audience string, assertions revocation.Denylist) Authenticator {
+	return Authenticator{Clients: clients, Audience: audience, Assertions: assertions, now: time.Now}
+}
+
+// Authenticate returns the client that made the request. Clients send their id and secret with basic auth or as
+// the client_id and client_secret form parameters, or a jwt assertion as the client_assertion form parameter.
+// ErrInvalidClient is returned when the client can't be authenticated.
+func (a Authenticator) Authenticate(r *http.Request) (Client, error) {
+	if err := r.ParseForm(); err != nil {
+		return Client{}, err
+	}
+	if assertionType := r.PostForm.Get("client_assertion_type"); assertionType != "" {
+		if assertionType != models.ClientAssertionType {
+			return Client{}, ErrInvalidClient
+		}
+		c, _, err := a.VerifyAssertion(r.PostForm.Get("client_assertion"))
+		return c, err
+	}
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	c, ok := a.Clients.Client(id)
+	if !ok || c.SecretHash == "" || secret == "" {
+		return Client{}, ErrInvalidClient
+	}
+	if subtle.ConstantTimeCompare([]byte(HashSecret(secret)), []byte(c.SecretHash)) != 1 {
+		return Client{}, ErrInvalidClient
+	}
+	return c, nil
+}
+
+// VerifyAssertion verifies a jwt assertion signed with the private key of a client, and returns the client and
+// the claims of the assertion. The client is the issuer and subject of the assertion, and the assertion can only
+// be used once. ErrInvalidClient is returned when the assertion is not valid.
+func (a Authenticator) VerifyAssertion(assertion string) (Client, *jwt.Claims, error) {
+	if assertion == "" {
+		return Client{}, nil, ErrInvalidClient
+	}
+	unverified := jwt.Claims{}
+	if _, _, err := new(jwtgo.Parser).ParseUnverified(assertion, &unverified); err != nil {
+		return Client{}, nil, ErrInvalidClient
+	}
+	c, ok := a.Clients.Client(unverified.Subject)
+	if !ok || c.PublicKey == nil {
+		return Client{}, nil, ErrInvalidClient
+	}
+	claims, err := jwt.NewReader(
+		jwt.ReaderBuilder.WithKeySource(keySource(c.publicKey)),
+		jwt.ReaderBuilder.WithIssuer(c.ID),
+		jwt.ReaderBuilder.WithAudience(a.Audience),
+	).Read(jwt.NewToken(assertion))
+	if err != nil {
+		return Client{}, nil, ErrInvalidClient
+	}
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if claims.ID == "" || claims.ExpiresAt == 0 || expiresAt.Sub(a.now()) > MaxAssertionLifetime {
+		return Client{}, nil, ErrInvalidClient
+	}
+	if err := a.Assertions.RevokeOnce(claims.ID, expiresAt); err == revocation.ErrAlreadyRevoked {
+		return Client{}, nil, ErrInvalidClient
+	} else if err != nil {
+		return Client{}, nil, err
+	}
+	return c, claims, nil
+}
+
+// File is the format of the file clients are registered in. Relative public key paths are relative to the
+// directory of the file.
+//
+//	{
+//		"Clients": [
+//			{"ID": "oauth-service", "SecretSHA256": "5e88...", "Scopes": ["users:read"]},
+//			{"ID": "billing", "PublicKeyPath": "billing.rsa.pub", "Scopes": ["users:read"]}
+//		]
+//	}
+type File struct {
+	Clients []struct {
+		ID            string
+		SecretSHA256  string
+		PublicKeyPath string
+		Scopes        []string
+	}
+}
+
+// Read reads the clients registered in the file at the path given.
+func Read(reader jwt.FileReader, path string) (clients []Client, err error) {
+	data, err := reader.Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clients: %s", err)
+	}
+	file := File{}
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to read clients: %s", err)
+	}
+	for _, fc := range file.Clients {
+		c := Client{ID: fc.ID, SecretHash: fc.SecretSHA256, Scopes: fc.Scopes}
+		if fc.PublicKeyPath != "" {
+			keyPath := fc.PublicKeyPath
+			if !filepath.IsAbs(keyPath) {
+				keyPath = filepath.Join(filepath.Dir(path), keyPath)
+			}
+			if data, err = reader.Read(keyPath); err != nil {
+				return nil, fmt.Errorf("failed to read public key of client %s: %s", fc.ID, err)
+			}
+			if c.PublicKey, err = jwtgo.ParseRSAPublicKeyFromPEM(data); err != nil {
+				return nil, fmt.Errorf("failed to read public key of client %s: %s", fc.ID, err)
+			}
+		}
+		clients = append(clients, c)
+	}
+	return
+}
@@ -0,0 +1,194 @@
+package clients_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/darren-west/app/auth-service/clients"
+	"github.com/darren-west/app/auth-service/models"
+	"github.com/darren-west/app/auth-service/revocation"
+	"github.com/darren-west/app/utils/fileutil"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+const audience = "http://auth-service/token/client"
+
+func TestClientsSuite(t *testing.T) {
+	suite.Run(t, &ClientsSuite{})
+}
+
+type ClientsSuite struct {
+	suite.Suite
+	Authenticator clients.Authenticator
+	Writer        jwt.Writer
+}
+
+func (cs *ClientsSuite) SetupTest() {
+	registered, err := clients.Read(fileutil.FileReader{}, "testdata/clients.json")
+	cs.Require().NoError(err)
+	registry, err := clients.NewRegistry(registered...)
+	cs.Require().NoError(err)
+	cs.Authenticator = clients.NewAuthenticator(registry, audience, revocation.NewMemoryDenylist())
+
+	active, signingKeys, err := jwt.ReadKeySet(fileutil.FileReader{}, "../../utils/jwt/testdata/keys.json")
+	cs.Require().NoError(err)
+	keys, err := jwt.NewKeySet(active, signingKeys...)
+	cs.Require().NoError(err)
+	cs.Writer = jwt.NewWriter(jwt.WriterBuilder.WithKeySet(keys))
+}
+
+func (cs *ClientsSuite) TestRead() {
+	registered, err := clients.Read(fileutil.FileReader{}, "testdata/clients.json")
+	cs.Require().NoError(err)
+	cs.Require().Len(registered, 2)
+	cs.Assert().Equal(clients.HashSecret("secret"), registered[0].SecretHash)
+	cs.Assert().Equal([]string{"users:read", "users:write", "tokens:exchange"}, registered[0].Scopes)
+	cs.Assert().NotNil(registered[1].PublicKey)
+}
+
+func (cs *ClientsSuite) TestReadMissingFile() {
+	_, err := clients.Read(fileutil.FileReader{}, "testdata/missing.json")
+	cs.Assert().Error(err)
+}
+
+func (cs *ClientsSuite) TestRegistryInvalid() {
+	_, err := clients.NewRegistry(clients.Client{SecretHash: "foo"})
+	cs.Assert().EqualError(err, "invalid clients: client id is empty")
+	_, err = clients.NewRegistry(clients.Client{ID: "foo"})
+	cs.Assert().EqualError(err, "invalid clients: client foo has no secret or public key")
+	_, err = clients.NewRegistry(clients.Client{ID: "foo", SecretHash: "a"}, clients.Client{ID: "foo", SecretHash: "b"})
+	cs.Assert().EqualError(err, "invalid clients: duplicate client id foo")
+}
+
+func (cs *ClientsSuite) TestAllowedScopes() {
+	c := clients.Client{Scopes: []string{"users:read", "users:write"}}
+	scopes, err := c.AllowedScopes(nil)
+	cs.Require().NoError(err)
+	cs.Assert().Equal([]string{"users:read", "users:write"}, scopes)
+
+	scopes, err = c.AllowedScopes([]string{"users:read"})
+	cs.Require().NoError(err)
+	cs.Assert().Equal([]string{"users:read"}, scopes)
+
+	_, err = c.AllowedScopes([]string{"users:delete"})
+	cs.Assert().Equal(clients.ErrInvalidScope, err)
+}
+
+func (cs *ClientsSuite) TestAuthenticateSecret() {
+	tests := map[string]struct {
+		request    func() *http.Request
+		expectedID string
+	}{
+		"basic auth": {request: func() *http.Request {
+			r := formRequest(url.Values{})
+			r.SetBasicAuth("oauth-service", "secret")
+			return r
+		}, expectedID: "oauth-service"},
+		"form": {request: func() *http.Request {
+			return formRequest(url.Values{"client_id": {"oauth-service"}, "client_secret": {"secret"}})
+		}, expectedID: "oauth-service"},
+		"wrong secret": {request: func() *http.Request {
+			r := formRequest(url.Values{})
+			r.SetBasicAuth("oauth-service", "wrong")
+			return r
+		}},
+		"unknown client": {request: func() *http.Request {
+			r := formRequest(url.Values{})
+			r.SetBasicAuth("unknown", "secret")
+			return r
+		}},
+		"client without secret": {request: func() *http.Request {
+			r := formRequest(url.Values{})
+			r.SetBasicAuth("billing", "")
+			return r
+		}},
+		"no credentials": {request: func() *http.Request {
+			return formRequest(url.Values{})
+		}},
+	}
+	for name, test := range tests {
+		c, err := cs.Authenticator.Authenticate(test.request())
+		if test.expectedID == "" {
+			cs.Assert().Equal(clients.ErrInvalidClient, err, name)
+			continue
+		}
+		cs.Assert().NoError(err, name)
+		cs.Assert().Equal(test.expectedID, c.ID, name)
+	}
+}
+
+func (cs *ClientsSuite) TestAuthenticateAssertion() {
+	assertion := cs.assertion(&jwt.Claims{Issuer: "billing", Subject: "billing", Audience: jwt.Audience{audience}})
+
+	c, err := cs.Authenticator.Authenticate(assertionRequest(assertion))
+	cs.Require().NoError(err)
+	cs.Assert().Equal("billing", c.ID)
+
+	_, err = cs.Authenticator.Authenticate(assertionRequest(assertion))
+	cs.Assert().Equal(clients.ErrInvalidClient, err, "assertions should only be used once")
+}
+
+func (cs *ClientsSuite) TestAuthenticateAssertionConcurrentReplay() {
+	assertion := cs.assertion(&jwt.Claims{Issuer: "billing", Subject: "billing", Audience: jwt.Audience{audience}})
+
+	var authenticated int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cs.Authenticator.Authenticate(assertionRequest(assertion)); err == nil {
+				atomic.AddInt32(&authenticated, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	cs.Assert().Equal(int32(1), authenticated, "an assertion replayed concurrently should only be accepted once")
+}
+
+func (cs *ClientsSuite) TestAuthenticateInvalidAssertion() {
+	tests := map[string]*jwt.Claims{
+		"wrong audience": {Issuer: "billing", Subject: "billing", Audience: jwt.Audience{"http://other"}},
+		"wrong issuer":   {Issuer: "other", Subject: "billing", Audience: jwt.Audience{audience}},
+		"secret client":  {Issuer: "oauth-service", Subject: "oauth-service", Audience: jwt.Audience{audience}},
+		"unknown client": {Issuer: "unknown", Subject: "unknown", Audience: jwt.Audience{audience}},
+		"too long":       {Issuer: "billing", Subject: "billing", Audience: jwt.Audience{audience}, ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		"expired":        {Issuer: "billing", Subject: "billing", Audience: jwt.Audience{audience}, ExpiresAt: time.Now().Add(-time.Hour).Unix()},
+	}
+	for name, claims := range tests {
+		_, err := cs.Authenticator.Authenticate(assertionRequest(cs.assertion(claims)))
+		cs.Assert().Equal(clients.ErrInvalidClient, err, name)
+	}
+
+	_, err := cs.Authenticator.Authenticate(assertionRequest("foo"))
+	cs.Assert().Equal(clients.ErrInvalidClient, err)
+}
+
+// assertion signs the claims, setting an id and an expiry a minute from now if they aren't set.
+func (cs *ClientsSuite) assertion(claims *jwt.Claims) string {
+	claims.ID = uuid.New().String()
+	if claims.ExpiresAt == 0 {
+		claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+	}
+	token, err := cs.Writer.Write(claims)
+	cs.Require().NoError(err)
+	return token.String()
+}
+
+func formRequest(form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/token/client", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func assertionRequest(assertion string) *http.Request {
+	return formRequest(url.Values{"client_assertion_type": {models.ClientAssertionType}, "client_assertion": {assertion}})
+}
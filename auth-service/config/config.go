@@ -0,0 +1,223 @@
+// Package config reads the configuration of the auth service. The configuration is read from a json file, with
+// fields overridden by environment variables prefixed with AUTH, and is then validated.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/darren-west/app/utils/config"
+	"github.com/darren-west/app/utils/validator"
+	"github.com/sirupsen/logrus"
+)
+
+// EnvPrefix is the prefix of the environment variables that override the configuration. The access token
+// lifetime is set by AUTH_TOKENS_ACCESS_TOKEN_LIFETIME, see config.Env for how variables are named.
+const EnvPrefix = "AUTH"
+
+//go:generate mockgen -destination ./mocks/mock_reader.go -package mocks github.com/darren-west/app/auth-service/config FileReader
+
+// FileReader reads a files content into a byte array.
+type FileReader interface {
+	Read(string) ([]byte, error)
+}
+
+// Reader is used to read the configuration. Instantiate using the NewReader function.
+type Reader struct {
+	fileReader FileReader
+	env        config.Env
+}
+
+// NewReader creates a new reader using the injected file reader to read the contents of the file.
+func NewReader(fileReader FileReader) (Reader, error) {
+	if fileReader == nil {
+		return Reader{}, errors.New("file reader is nil")
+	}
+	return Reader{fileReader: fileReader, env: config.Env{Prefix: EnvPrefix}}, nil
+}
+
+// Read reads the config in the file over the defaults, applies the environment variable overrides and validates
+// it. No file is read when the path is empty.
+func (r Reader) Read(path string) (options Options, err error) {
+	options = Default()
+	if path != "" {
+		data, err := r.fileReader.Read(path)
+		if err != nil {
+			return options, fmt.Errorf("failed to read file: %s", err)
+		}
+		if err = json.Unmarshal(data, &options); err != nil {
+			return options, err
+		}
+	}
+	if err = r.env.Apply(&options); err != nil {
+		return
+	}
+	if err = validator.Default.IsValid(&options); err != nil {
+		err = fmt.Errorf("configuration invalid: %s", err)
+		return
+	}
+	return
+}
+
+// Default returns the default options. There is no default signing key set, it has to be configured.
+func Default() Options {
+	return Options{
+		BindAddress: ":80",
+		LogLevel:    "info",
+		Keys: KeyOptions{
+			ReloadInterval: config.Duration(time.Minute),
+		},
+		Tokens: TokenOptions{
+			AccessTokenLifetime:  config.Duration(time.Minute * 15),
+			RefreshTokenLifetime: config.Duration(time.Hour * 24 * 30),
+		},
+		Mongo: MongoOptions{
+			ConnectionString: "mongodb://localhost:27017",
+			DatabaseName:     "dev",
+		},
+		Clients: ClientOptions{
+			TokenEndpoint: "http://auth-service/token/client",
+		},
+		Exchange: ExchangeOptions{
+			Trust:    []string{TrustServiceClient},
+			Audience: "http://auth-service/token",
+		},
+		UserServiceAddress: "http://user-service",
+	}
+}
+
+// Options is a struct containing the options for configuring the service.
+type Options struct {
+	BindAddress        string `validate:"required"`
+	LogLevel           string
+	Keys               KeyOptions
+	Tokens             TokenOptions
+	Mongo              MongoOptions
+	Clients            ClientOptions
+	Exchange           ExchangeOptions
+	TLS                TLSOptions
+	UserServiceAddress string `validate:"required,url"`
+}
+
+func (o Options) IsValid() (err error) {
+	if _, err = logrus.ParseLevel(o.LogLevel); err != nil {
+		return fmt.Errorf("invalid log level: %s", err)
+	}
+	if o.Exchange.trusts(TrustCertificate) && o.TLS.ClientCAPath == "" {
+		return errors.New("certificate exchange trust requires the tls client ca path")
+	}
+	return
+}
+
+// KeyOptions configure the key set tokens are signed with. The key set file is read again on the reload
+// interval so keys can be rotated without a restart.
+type KeyOptions struct {
+	SetPath        string `validate:"required"`
+	ReloadInterval config.Duration
+}
+
+func (o KeyOptions) IsValid() error {
+	if o.ReloadInterval <= 0 {
+		return errors.New("key reload interval must be positive")
+	}
+	return nil
+}
+
+// TokenOptions configure the tokens that are issued. Tokens are issued by the issuer for the audience when they
+// are set, and tokens read by the service must be too.
+type TokenOptions struct {
+	Issuer               string
+	Audience             string
+	AccessTokenLifetime  config.Duration
+	RefreshTokenLifetime config.Duration
+}
+
+func (o TokenOptions) IsValid() error {
+	if o.AccessTokenLifetime <= 0 || o.RefreshTokenLifetime <= 0 {
+		return errors.New("token lifetimes must be positive")
+	}
+	if o.AccessTokenLifetime >= o.RefreshTokenLifetime {
+		return errors.New("access token lifetime must be shorter than the refresh token lifetime")
+	}
+	return nil
+}
+
+// MongoOptions configure the mongo database refresh tokens and revocations are stored in.
+type MongoOptions struct {
+	ConnectionString string `validate:"required"`
+	DatabaseName     string `validate:"required"`
+}
+
+// ClientOptions configure the service clients that can use the client credentials grant. There are no clients
+// when the path is empty. The token endpoint is the audience of client assertions.
+type ClientOptions struct {
+	Path          string
+	TokenEndpoint string `validate:"required,url"`
+}
+
+// Exchange trusts, the callers that can be trusted to exchange users for tokens.
+const (
+	TrustServiceClient = "service-client"
+	TrustHandoff       = "handoff"
+	TrustCertificate   = "certificate"
+)
+
+// ExchangeOptions configure which callers are trusted to exchange users for tokens. The audience is the url of
+// the token exchange endpoint, the audience of session handoffs. Certificates are the names of the client
+// certificates trusted with the certificate trust.
+type ExchangeOptions struct {
+	Trust        []string
+	Audience     string `validate:"url"`
+	Certificates []string
+}
+
+func (o ExchangeOptions) trusts(trust string) bool {
+	for _, t := range o.Trust {
+		if t == trust {
+			return true
+		}
+	}
+	return false
+}
+
+func (o ExchangeOptions) IsValid() error {
+	for _, t := range o.Trust {
+		switch t {
+		case TrustServiceClient, TrustHandoff, TrustCertificate:
+		default:
+			return fmt.Errorf("unknown exchange trust %s", t)
+		}
+	}
+	if o.trusts(TrustHandoff) && o.Audience == "" {
+		return errors.New("handoff exchange trust requires the exchange audience")
+	}
+	if o.trusts(TrustCertificate) && len(o.Certificates) == 0 {
+		return errors.New("certificate exchange trust requires the exchange certificates")
+	}
+	return nil
+}
+
+// TLSOptions configure serving over tls. The service is served over tls when the certificate is set. Client
+// certificates are verified with the client ca when it is set.
+type TLSOptions struct {
+	CertPath     string
+	KeyPath      string
+	ClientCAPath string
+}
+
+// Enabled returns true if the service is served over tls.
+func (o TLSOptions) Enabled() bool {
+	return o.CertPath != ""
+}
+
+func (o TLSOptions) IsValid() error {
+	if (o.CertPath == "") != (o.KeyPath == "") {
+		return errors.New("tls cert path and key path must be set together")
+	}
+	if o.ClientCAPath != "" && o.CertPath == "" {
+		return errors.New("tls client ca path requires the tls cert path")
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/darren-west/app/auth-service/config"
+	"github.com/darren-west/app/auth-service/config/mocks"
+	"github.com/darren-west/app/utils/validator"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	testData := `{
+		"BindAddress": ":8080",
+		"LogLevel": "debug",
+		"Keys": {"SetPath": "/keys/keys.json", "ReloadInterval": "5m"},
+		"Tokens": {"Issuer": "https://auth.example.com", "Audience": "app", "AccessTokenLifetime": "10m"},
+		"Mongo": {"ConnectionString": "mongodb://database", "DatabaseName": "auth"}
+	}`
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	fileReader := mocks.NewMockFileReader(ctrl)
+	fileReader.EXPECT().Read("config.json").Return([]byte(testData), nil)
+
+	reader, err := config.NewReader(fileReader)
+	require.NoError(t, err)
+	options, err := reader.Read("config.json")
+	require.NoError(t, err)
+
+	expected := config.Default()
+	expected.BindAddress = ":8080"
+	expected.LogLevel = "debug"
+	expected.Keys.SetPath = "/keys/keys.json"
+	expected.Keys.ReloadInterval = config.Default().Keys.ReloadInterval * 5
+	expected.Tokens.Issuer = "https://auth.example.com"
+	expected.Tokens.Audience = "app"
+	expected.Tokens.AccessTokenLifetime = config.Default().Tokens.AccessTokenLifetime * 2 / 3
+	expected.Mongo = config.MongoOptions{ConnectionString: "mongodb://database", DatabaseName: "auth"}
+	assert.Equal(t, expected, options)
+}
+
+func TestReaderEnvOverrides(t *testing.T) {
+	env := map[string]string{
+		"AUTH_KEYS_SET_PATH":                "/keys/keys.json",
+		"AUTH_TOKENS_ACCESS_TOKEN_LIFETIME": "5m",
+		"AUTH_MONGO_CONNECTION_STRING":      "mongodb://secret@database",
+		"AUTH_EXCHANGE_TRUST":               "service-client,certificate",
+		"AUTH_EXCHANGE_CERTIFICATES":        "oauth-service",
+		"AUTH_TLS_CERT_PATH":                "/tls/tls.crt",
+		"AUTH_TLS_KEY_PATH":                 "/tls/tls.key",
+		"AUTH_TLS_CLIENT_CA_PATH":           "/tls/ca.crt",
+	}
+	for key, value := range env {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	fileReader := mocks.NewMockFileReader(ctrl)
+	fileReader.EXPECT().Read("config.json").Return([]byte(`{"Mongo": {"ConnectionString": "mongodb://database"}}`), nil)
+
+	reader, err := config.NewReader(fileReader)
+	require.NoError(t, err)
+	options, err := reader.Read("config.json")
+	require.NoError(t, err)
+	assert.Equal(t, "/keys/keys.json", options.Keys.SetPath)
+	assert.Equal(t, time.Minute*5, options.Tokens.AccessTokenLifetime.Duration())
+	assert.Equal(t, "mongodb://secret@database", options.Mongo.ConnectionString, "the environment should override the file")
+	assert.Equal(t, []string{"service-client", "certificate"}, options.Exchange.Trust)
+	assert.Equal(t, []string{"oauth-service"}, options.Exchange.Certificates)
+	assert.Equal(t, config.TLSOptions{CertPath: "/tls/tls.crt", KeyPath: "/tls/tls.key", ClientCAPath: "/tls/ca.crt"}, options.TLS)
+}
+
+func TestReaderWithoutFile(t *testing.T) {
+	os.Setenv("AUTH_KEYS_SET_PATH", "/keys/keys.json")
+	defer os.Unsetenv("AUTH_KEYS_SET_PATH")
+	reader, err := config.NewReader(mocks.NewMockFileReader(gomock.NewController(t)))
+	require.NoError(t, err)
+
+	options, err := reader.Read("")
+	require.NoError(t, err)
+	assert.Equal(t, ":80", options.BindAddress)
+	assert.Equal(t, []string{config.TrustServiceClient}, options.Exchange.Trust)
+}
+
+func TestReaderFileError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	fileReader := mocks.NewMockFileReader(ctrl)
+	fileReader.EXPECT().Read("config.json").Return(nil, errors.New("boom"))
+
+	reader, err := config.NewReader(fileReader)
+	require.NoError(t, err)
+	_, err = reader.Read("config.json")
+	assert.EqualError(t, err, "failed to read file: boom")
+}
+
+func TestReaderInvalidEnv(t *testing.T) {
+	os.Setenv("AUTH_TOKENS_ACCESS_TOKEN_LIFETIME", "soon")
+	defer os.Unsetenv("AUTH_TOKENS_ACCESS_TOKEN_LIFETIME")
+	reader, err := config.NewReader(mocks.NewMockFileReader(gomock.NewController(t)))
+	require.NoError(t, err)
+
+	_, err = reader.Read("")
+	assert.EqualError(t, err, `invalid environment variable AUTH_TOKENS_ACCESS_TOKEN_LIFETIME: invalid duration "soon": time: invalid duration "soon"`)
+}
+
+func TestNewReaderNil(t *testing.T) {
+	_, err := config.NewReader(nil)
+	assert.EqualError(t, err, "file reader is nil")
+}
+
+func TestReaderMissingRequiredField(t *testing.T) {
+	reader, err := config.NewReader(mocks.NewMockFileReader(gomock.NewController(t)))
+	require.NoError(t, err)
+
+	_, err = reader.Read("")
+	assert.EqualError(t, err, "configuration invalid: Keys.SetPath: required field missing")
+}
+
+func TestOptionsInvalid(t *testing.T) {
+	valid := func() config.Options {
+		o := config.Default()
+		o.Keys.SetPath = "/keys/keys.json"
+		return o
+	}
+	tests := map[string]struct {
+		modify      func(*config.Options)
+		expectedErr string
+	}{
+		"bind address":      {modify: func(o *config.Options) { o.BindAddress = "" }, expectedErr: "BindAddress: required field missing"},
+		"log level":         {modify: func(o *config.Options) { o.LogLevel = "loud" }, expectedErr: `invalid log level: not a valid logrus Level: "loud"`},
+		"key set path":      {modify: func(o *config.Options) { o.Keys.SetPath = "" }, expectedErr: "Keys.SetPath: required field missing"},
+		"lifetimes":         {modify: func(o *config.Options) { o.Tokens.AccessTokenLifetime = o.Tokens.RefreshTokenLifetime }, expectedErr: "Tokens: access token lifetime must be shorter than the refresh token lifetime"},
+		"mongo":             {modify: func(o *config.Options) { o.Mongo.DatabaseName = "" }, expectedErr: "Mongo.DatabaseName: required field missing"},
+		"user service":      {modify: func(o *config.Options) { o.UserServiceAddress = "user-service" }, expectedErr: `UserServiceAddress: invalid url "user-service"`},
+		"client token":      {modify: func(o *config.Options) { o.Clients.TokenEndpoint = "" }, expectedErr: "Clients.TokenEndpoint: required field missing"},
+		"unknown trust":     {modify: func(o *config.Options) { o.Exchange.Trust = []string{"anyone"} }, expectedErr: "Exchange: unknown exchange trust anyone"},
+		"exchange audience": {modify: func(o *config.Options) { o.Exchange.Audience = "/token" }, expectedErr: `Exchange.Audience: invalid url "/token"`},
+		"certificates": {modify: func(o *config.Options) {
+			o.Exchange.Trust = []string{config.TrustCertificate}
+			o.TLS = config.TLSOptions{CertPath: "tls.crt", KeyPath: "tls.key", ClientCAPath: "ca.crt"}
+		}, expectedErr: "Exchange: certificate exchange trust requires the exchange certificates"},
+		"tls key": {modify: func(o *config.Options) { o.TLS.CertPath = "tls.crt" }, expectedErr: "TLS: tls cert path and key path must be set together"},
+		"certificate tls": {modify: func(o *config.Options) {
+			o.Exchange.Trust = []string{config.TrustCertificate}
+			o.Exchange.Certificates = []string{"oauth-service"}
+		}, expectedErr: "certificate exchange trust requires the tls client ca path"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := valid()
+			require.NoError(t, validator.Default.IsValid(&o))
+			test.modify(&o)
+			assert.EqualError(t, validator.Default.IsValid(&o), test.expectedErr)
+		})
+	}
+}
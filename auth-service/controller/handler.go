@@ -1,31 +1,125 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/darren-west/app/auth-service/clients"
+	"github.com/darren-west/app/auth-service/models"
+	"github.com/darren-west/app/auth-service/refresh"
+	"github.com/darren-west/app/auth-service/revocation"
+	"github.com/darren-west/app/auth-service/roles"
+	"github.com/darren-west/app/auth-service/trust"
 	"github.com/darren-west/app/utils/jwt"
 
 	"github.com/darren-west/app/utils/httputil"
 
+	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 )
 
+// WithAccessTokenLifetime sets how long access tokens are valid for.
+func WithAccessTokenLifetime(lifetime time.Duration) Option {
+	return func(h *Handler) {
+		h.AccessTokenLifetime = lifetime
+	}
+}
+
+// WithRoles sets where the roles of users are looked up and the scopes each role is given. Without a source
+// users have no roles and their tokens no scopes.
+func WithRoles(source roles.Source, scopes roles.Scopes) Option {
+	return func(h *Handler) {
+		h.Roles = source
+		h.RoleScopes = scopes
+	}
+}
+
+// WithClients sets the service clients that can get tokens with the client credentials grant. Client assertions
+// must be for the audience given, the url of the token endpoint.
+func WithClients(registry *clients.Registry, audience string) Option {
+	return func(h *Handler) {
+		h.Clients = registry
+		h.ClientAudience = audience
+	}
+}
+
+// WithExchangeTrust sets which callers are trusted to exchange users for tokens. By default only service clients
+// with the exchange scope are.
+func WithExchangeTrust(verifier trust.Verifier) Option {
+	return func(h *Handler) {
+		h.ExchangeTrust = verifier
+	}
+}
+
+// WithIssuer sets the issuer of tokens. Tokens read by the handler must be from the issuer.
+func WithIssuer(issuer string) Option {
+	return func(h *Handler) {
+		h.Issuer = issuer
+	}
+}
+
+// WithAudience sets the audience of tokens. Tokens read by the handler must be for the audience.
+func WithAudience(audience string) Option {
+	return func(h *Handler) {
+		h.Audience = audience
+	}
+}
+
+// Option is used to set options on the Handler.
+type Option func(*Handler)
+
 type Handler struct {
 	jwt.Writer
+	Reader              jwt.Reader
+	Keys                *jwt.KeySet
+	RefreshTokens       refresh.Issuer
+	Denylist            revocation.Denylist
+	AccessTokenLifetime time.Duration
+	Roles               roles.Source
+	RoleScopes          roles.Scopes
+	Clients             *clients.Registry
+	ClientAudience      string
+	ExchangeTrust       trust.Verifier
+	Issuer              string
+	Audience            string
 }
 
-func NewHandler(keyPath string, router *httprouter.Router) http.Handler {
+func NewHandler(keys *jwt.KeySet, refreshTokens refresh.Issuer, denylist revocation.Denylist, router *httprouter.Router, opts ...Option) http.Handler {
 	h := Handler{
-		Writer: jwt.NewWriter(jwt.WriterBuilder.WithPrivateKeyPath(keyPath)),
+		Writer:              jwt.NewWriter(jwt.WriterBuilder.WithKeySet(keys)),
+		Keys:                keys,
+		RefreshTokens:       refreshTokens,
+		Denylist:            denylist,
+		AccessTokenLifetime: time.Minute * 15,
+		RoleScopes:          roles.DefaultScopes,
+	}
+	for _, opt := range opts {
+		opt(&h)
+	}
+	h.Reader = jwt.NewReader(
+		jwt.ReaderBuilder.WithKeySource(keys),
+		jwt.ReaderBuilder.WithRevocationChecker(denylist),
+		jwt.ReaderBuilder.WithIssuer(h.Issuer),
+		jwt.ReaderBuilder.WithAudience(h.Audience),
+	)
+	if h.ExchangeTrust == nil {
+		h.ExchangeTrust = trust.ServiceClient{Reader: h.Reader}
 	}
 	router.POST("/token", httputil.UseErrorHandle(h.ExchangeToken))
+	router.POST("/token/refresh", httputil.UseErrorHandle(h.RefreshToken))
+	router.POST("/token/client", httputil.UseErrorHandle(h.ClientCredentials))
+	router.POST("/introspect", httputil.UseErrorHandle(h.Introspect))
+	router.POST("/revoke", httputil.UseErrorHandle(h.Revoke))
+	router.GET("/.well-known/jwks.json", httputil.UseErrorHandle(h.JSONWebKeySet))
 	return router
 }
 
+// ExchangeToken issues tokens for the user in the body. Only callers the exchange trust verifier trusts can
+// exchange users, as the user does not need to be present.
 func (h Handler) ExchangeToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) httputil.Error {
 	user := jwt.User{}
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
@@ -34,15 +128,134 @@ func (h Handler) ExchangeToken(w http.ResponseWriter, r *http.Request, _ httprou
 	if err := isUserValid(user); err != nil {
 		return httputil.NewError(http.StatusBadRequest).WithError(err)
 	}
-	token, err := h.Writer.Write(&jwt.Claims{
-		User:      user,
-		ExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
-		IssuedAt:  time.Now().Unix(),
-	})
+	client, err := h.ExchangeTrust.Verify(r, user)
+	if err == trust.ErrUntrusted {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		return httputil.NewError(http.StatusUnauthorized).WithError(err)
+	} else if err != nil {
+		return httputil.NewError(http.StatusInternalServerError).WithError(err)
+	}
+	user.Roles = nil // roles are looked up when tokens are written, never taken from the caller.
+	refreshToken, err := h.RefreshTokens.Issue(user, client)
 	if err != nil {
 		return httputil.NewError(http.StatusInternalServerError).WithError(err)
 	}
-	fmt.Fprintf(w, "%s", token)
+	return h.writeTokens(r.Context(), w, user, client, refreshToken)
+}
+
+// RefreshToken exchanges a refresh token for a new access token and refresh token, for the client the refresh
+// token was issued to. The refresh token can only be used once, using it again revokes every refresh token
+// descended from the same login. A token issued to another client is rejected as an invalid grant (RFC 6749
+// section 5.2) and left usable by its own client.
+func (h Handler) RefreshToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) httputil.Error {
+	client, httpErr := h.authenticateClient(w, r)
+	if httpErr != nil {
+		return httpErr
+	}
+	token := r.PostForm.Get("refresh_token")
+	if token == "" {
+		return httputil.NewError(http.StatusBadRequest).WithMessage("refresh token is empty")
+	}
+	stored, refreshToken, err := h.RefreshTokens.Rotate(token, client.ID)
+	if err == refresh.ErrWrongClient {
+		return httputil.NewError(http.StatusBadRequest).WithMessage("invalid_grant: %s", err)
+	}
+	if err == refresh.ErrInvalidToken || err == refresh.ErrTokenReused {
+		return httputil.NewError(http.StatusUnauthorized).WithError(err)
+	}
+	if err != nil {
+		return httputil.NewError(http.StatusInternalServerError).WithError(err)
+	}
+	return h.writeTokens(r.Context(), w, stored.User, stored.ClientID, refreshToken)
+}
+
+// writeTokens signs an access token for the user, issued to the client, and writes it with the refresh token. The
+// roles of the user are looked up every time, roles sent by the caller or stored with the refresh token are never
+// trusted, so changes to a users roles apply from their next refresh.
+func (h Handler) writeTokens(ctx context.Context, w http.ResponseWriter, user jwt.User, client, refreshToken string) httputil.Error {
+	user.Roles = nil
+	if h.Roles != nil {
+		userRoles, err := h.Roles.Roles(ctx, user.ID)
+		if err != nil {
+			return httputil.NewError(http.StatusBadGateway).WithMessage("failed to look up user roles: %s", err)
+		}
+		user.Roles = userRoles
+	}
+	return h.writeAccessToken(w, &jwt.Claims{
+		User:            user,
+		Subject:         user.ID,
+		Scope:           strings.Join(h.RoleScopes.For(user.Roles), " "),
+		AuthorizedParty: client,
+	}, refreshToken)
+}
+
+// ClientCredentials issues an access token to a service client for the scopes in the scope form parameter, or
+// every scope the client is allowed when there are none (RFC 6749 section 4.4). The token is for the client
+// itself, so there is no user or refresh token.
+func (h Handler) ClientCredentials(w http.ResponseWriter, r *http.Request, _ httprouter.Params) httputil.Error {
+	if err := r.ParseForm(); err != nil {
+		return httputil.NewError(http.StatusBadRequest).WithError(err)
+	}
+	if grantType := r.PostForm.Get("grant_type"); grantType != "client_credentials" {
+		return httputil.NewError(http.StatusBadRequest).WithMessage("unsupported grant type %q", grantType)
+	}
+	client, httpErr := h.authenticateClient(w, r)
+	if httpErr != nil {
+		return httpErr
+	}
+	scopes, err := client.AllowedScopes(strings.Fields(r.PostForm.Get("scope")))
+	if err != nil {
+		return httputil.NewError(http.StatusBadRequest).WithError(err)
+	}
+	return h.writeAccessToken(w, &jwt.Claims{
+		Subject:  client.ID,
+		ClientID: client.ID,
+		Scope:    strings.Join(scopes, " "),
+	}, "")
+}
+
+// authenticateClient returns the service client that made the request, see clients.Authenticator for how clients
+// authenticate. Requests from clients that can't be authenticated are rejected with 401.
+func (h Handler) authenticateClient(w http.ResponseWriter, r *http.Request) (clients.Client, httputil.Error) {
+	if h.Clients == nil {
+		return clients.Client{}, httputil.NewError(http.StatusUnauthorized).WithError(clients.ErrInvalidClient)
+	}
+	client, err := clients.NewAuthenticator(h.Clients, h.ClientAudience, h.Denylist).Authenticate(r)
+	if err == clients.ErrInvalidClient {
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth-service"`)
+		return clients.Client{}, httputil.NewError(http.StatusUnauthorized).WithError(err)
+	}
+	if err != nil {
+		return clients.Client{}, httputil.NewError(http.StatusInternalServerError).WithError(err)
+	}
+	return client, nil
+}
+
+// writeAccessToken signs the claims as an access token valid from now, and writes it with the refresh token.
+func (h Handler) writeAccessToken(w http.ResponseWriter, claims *jwt.Claims, refreshToken string) httputil.Error {
+	now := time.Now()
+	claims.Issuer = h.Issuer
+	if h.Audience != "" {
+		claims.Audience = jwt.Audience{h.Audience}
+	}
+	claims.ID = uuid.New().String()
+	claims.ExpiresAt = now.Add(h.AccessTokenLifetime).Unix()
+	claims.NotBefore = now.Unix()
+	claims.IssuedAt = now.Unix()
+	token, err := h.Writer.Write(claims)
+	if err != nil {
+		return httputil.NewError(http.StatusInternalServerError).WithError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(models.Tokens{
+		AccessToken:  token.String(),
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(h.AccessTokenLifetime / time.Second),
+		RefreshToken: refreshToken,
+	}); err != nil {
+		return httputil.NewError(http.StatusInternalServerError).WithError(err)
+	}
 	return nil
 }
 
@@ -52,3 +265,123 @@ func isUserValid(user jwt.User) (err error) {
 	}
 	return
 }
+
+// JSONWebKeySet writes the public keys that tokens are verified with.
+func (h Handler) JSONWebKeySet(w http.ResponseWriter, r *http.Request, _ httprouter.Params) httputil.Error {
+	keys, err := h.Keys.JSONWebKeySet()
+	if err != nil {
+		return httputil.NewError(http.StatusInternalServerError).WithError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		return httputil.NewError(http.StatusInternalServerError).WithError(err)
+	}
+	return nil
+}
+
+// Introspect describes the access or refresh token in the token form parameter (RFC 7662). Tokens that are
+// invalid, expired or revoked are reported as not active. The caller must authenticate as a registered client,
+// resource servers introspect the tokens they are sent to check they have not been revoked.
+func (h Handler) Introspect(w http.ResponseWriter, r *http.Request, _ httprouter.Params) httputil.Error {
+	if _, httpErr := h.authenticateClient(w, r); httpErr != nil {
+		return httpErr
+	}
+	token, httpErr := tokenParam(r)
+	if httpErr != nil {
+		return httpErr
+	}
+	introspection := models.Introspection{}
+	if claims, err := h.Reader.Read(jwt.NewToken(token)); err == nil {
+		var user *jwt.User
+		if claims.User.ID != "" {
+			user = &claims.User
+		}
+		introspection = models.Introspection{
+			Active:    true,
+			TokenType: models.AccessTokenType,
+			Subject:   claims.Subject,
+			Issuer:    claims.Issuer,
+			Audience:  claims.Audience,
+			ExpiresAt: claims.ExpiresAt,
+			IssuedAt:  claims.IssuedAt,
+			NotBefore: claims.NotBefore,
+			ID:        claims.ID,
+			Scope:     claims.Scope,
+			ClientID:  tokenClient(claims),
+			User:      user,
+		}
+	} else {
+		stored, err := h.RefreshTokens.Lookup(token)
+		if err != nil && err != refresh.ErrInvalidToken {
+			return httputil.NewError(http.StatusInternalServerError).WithError(err)
+		}
+		if err == nil {
+			introspection = models.Introspection{
+				Active:    true,
+				TokenType: models.RefreshTokenType,
+				Subject:   stored.User.ID,
+				ClientID:  stored.ClientID,
+				ExpiresAt: stored.ExpiresAt.Unix(),
+				User:      &stored.User,
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(&introspection); err != nil {
+		return httputil.NewError(http.StatusInternalServerError).WithError(err)
+	}
+	return nil
+}
+
+// Revoke revokes the access or refresh token in the token form parameter (RFC 7009). Access tokens are denied
+// until they expire, refresh tokens are revoked along with every refresh token from the same login. The caller must
+// authenticate as the client the token was issued to, tokens issued to other clients are rejected with 403.
+// Unknown and invalid tokens are ignored, as there is nothing to revoke.
+func (h Handler) Revoke(w http.ResponseWriter, r *http.Request, _ httprouter.Params) httputil.Error {
+	client, httpErr := h.authenticateClient(w, r)
+	if httpErr != nil {
+		return httpErr
+	}
+	token, httpErr := tokenParam(r)
+	if httpErr != nil {
+		return httpErr
+	}
+	var err error
+	if claims, readErr := h.Reader.Read(jwt.NewToken(token)); readErr == nil {
+		if tokenClient(claims) != client.ID {
+			return httputil.NewError(http.StatusForbidden).WithMessage("token was not issued to the client")
+		}
+		err = h.Denylist.Revoke(claims.ID, time.Unix(claims.ExpiresAt, 0))
+	} else {
+		err = h.RefreshTokens.Revoke(token, client.ID)
+	}
+	if err == refresh.ErrWrongClient {
+		return httputil.NewError(http.StatusForbidden).WithError(err)
+	}
+	if err != nil {
+		return httputil.NewError(http.StatusInternalServerError).WithError(err)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// tokenClient returns the client an access token was issued to, the client itself for client tokens.
+func tokenClient(claims *jwt.Claims) string {
+	if claims.ClientID != "" {
+		return claims.ClientID
+	}
+	return claims.AuthorizedParty
+}
+
+func tokenParam(r *http.Request) (string, httputil.Error) {
+	if err := r.ParseForm(); err != nil {
+		return "", httputil.NewError(http.StatusBadRequest).WithError(err)
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		return "", httputil.NewError(http.StatusBadRequest).WithMessage("token is empty")
+	}
+	return token, nil
+}
@@ -0,0 +1,325 @@
+package controller_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/darren-west/app/auth-service/client"
+	"github.com/darren-west/app/auth-service/clients"
+	"github.com/darren-west/app/auth-service/controller"
+	"github.com/darren-west/app/auth-service/models"
+	"github.com/darren-west/app/auth-service/refresh"
+	"github.com/darren-west/app/auth-service/revocation"
+	"github.com/darren-west/app/auth-service/roles"
+	"github.com/darren-west/app/utils/fileutil"
+	"github.com/darren-west/app/utils/httputil"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestHandlerSuite(t *testing.T) {
+	suite.Run(t, &HandlerSuite{})
+}
+
+type HandlerSuite struct {
+	suite.Suite
+	Server *httptest.Server
+	Client client.Service
+	// Anonymous is a client without credentials.
+	Anonymous client.Service
+	// Credentials are the credentials of the client tokens are exchanged by.
+	Credentials client.Credentials
+	User        jwt.User
+	Roles       roleSource
+	Keys        *jwt.KeySet
+}
+
+// roleSource returns the roles in the map for each user.
+type roleSource map[string][]string
+
+func (s roleSource) Roles(_ context.Context, userID string) ([]string, error) {
+	return s[userID], nil
+}
+
+func (hs *HandlerSuite) SetupTest() {
+	active, signingKeys, err := jwt.ReadKeySet(fileutil.FileReader{}, "../../utils/jwt/testdata/keys.json")
+	hs.Require().NoError(err)
+	keys, err := jwt.NewKeySet(active, signingKeys...)
+	hs.Require().NoError(err)
+
+	registered, err := clients.Read(fileutil.FileReader{}, "../clients/testdata/clients.json")
+	hs.Require().NoError(err)
+	registry, err := clients.NewRegistry(registered...)
+	hs.Require().NoError(err)
+	hs.Keys = keys
+
+	hs.Roles = roleSource{}
+	hs.Server = httptest.NewUnstartedServer(nil)
+	url := "http://" + hs.Server.Listener.Addr().String()
+	hs.Server.Config.Handler = controller.NewHandler(
+		keys,
+		refresh.NewIssuer(refresh.NewMemoryStore(), time.Hour),
+		revocation.NewMemoryDenylist(),
+		httprouter.New(),
+		controller.WithRoles(hs.Roles, roles.Scopes{"admin": {"users:read", "users:delete"}}),
+		controller.WithClients(registry, url+"/token/client"),
+	)
+	hs.Server.Start()
+	hs.Anonymous = client.New(client.WithBaseAddress(hs.Server.URL))
+	hs.Credentials = client.Credentials{ClientID: "oauth-service", Secret: "secret"}
+	hs.Client = client.New(
+		client.WithBaseAddress(hs.Server.URL),
+		client.WithTokenSource(hs.Anonymous.TokenSource(hs.Credentials)),
+	)
+	hs.User = jwt.User{ID: "1234", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}
+}
+
+func (hs *HandlerSuite) TearDownTest() {
+	hs.Server.Close()
+}
+
+func (hs *HandlerSuite) TestIntrospectAccessToken() {
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+
+	introspection, err := hs.Client.Introspect(context.Background(), hs.Credentials, tokens.AccessToken)
+	hs.Require().NoError(err)
+	hs.Assert().True(introspection.Active)
+	hs.Assert().Equal(models.AccessTokenType, introspection.TokenType)
+	hs.Assert().Equal("1234", introspection.Subject)
+	hs.Assert().NotEmpty(introspection.ID)
+	hs.Assert().Equal("oauth-service", introspection.ClientID, "the token should be issued to the client that exchanged the user")
+	hs.Assert().Equal(&hs.User, introspection.User)
+}
+
+func (hs *HandlerSuite) TestIntrospectRefreshToken() {
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+
+	introspection, err := hs.Client.Introspect(context.Background(), hs.Credentials, tokens.RefreshToken)
+	hs.Require().NoError(err)
+	hs.Assert().True(introspection.Active)
+	hs.Assert().Equal(models.RefreshTokenType, introspection.TokenType)
+	hs.Assert().Equal("1234", introspection.Subject)
+	hs.Assert().Equal("oauth-service", introspection.ClientID)
+}
+
+func (hs *HandlerSuite) TestIntrospectAssertion() {
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+
+	introspection, err := hs.Client.Introspect(context.Background(), client.Credentials{ClientID: "billing", Keys: hs.Keys}, tokens.AccessToken)
+	hs.Require().NoError(err)
+	hs.Assert().True(introspection.Active, "any client should be able to introspect a token")
+}
+
+func (hs *HandlerSuite) TestIntrospectInvalidClient() {
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+
+	_, err = hs.Client.Introspect(context.Background(), client.Credentials{ClientID: "oauth-service", Secret: "wrong"}, tokens.AccessToken)
+	hs.Assert().EqualError(err, "introspect token failed: client authentication failed\n")
+}
+
+func (hs *HandlerSuite) TestIntrospectInvalidToken() {
+	introspection, err := hs.Client.Introspect(context.Background(), hs.Credentials, "foo")
+	hs.Require().NoError(err)
+	hs.Assert().Equal(models.Introspection{}, introspection)
+}
+
+func (hs *HandlerSuite) TestRevokeAccessToken() {
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+
+	hs.Require().NoError(hs.Client.Revoke(context.Background(), hs.Credentials, tokens.AccessToken))
+
+	introspection, err := hs.Client.Introspect(context.Background(), hs.Credentials, tokens.AccessToken)
+	hs.Require().NoError(err)
+	hs.Assert().False(introspection.Active)
+
+	introspection, err = hs.Client.Introspect(context.Background(), hs.Credentials, tokens.RefreshToken)
+	hs.Require().NoError(err)
+	hs.Assert().True(introspection.Active, "revoking the access token should not revoke the refresh token")
+}
+
+func (hs *HandlerSuite) TestRevokeRefreshToken() {
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+
+	hs.Require().NoError(hs.Client.Revoke(context.Background(), hs.Credentials, tokens.RefreshToken))
+
+	_, err = hs.Client.RefreshToken(context.Background(), hs.Credentials, tokens.RefreshToken)
+	hs.Assert().EqualError(err, "refresh token failed: refresh token invalid\n")
+}
+
+func (hs *HandlerSuite) TestRevokeInvalidClient() {
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+
+	err = hs.Client.Revoke(context.Background(), client.Credentials{ClientID: "oauth-service", Secret: "wrong"}, tokens.AccessToken)
+	hs.Assert().EqualError(err, "revoke token failed: client authentication failed\n")
+}
+
+func (hs *HandlerSuite) TestRevokeOtherClientsToken() {
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+	billing := client.Credentials{ClientID: "billing", Keys: hs.Keys}
+
+	err = hs.Client.Revoke(context.Background(), billing, tokens.AccessToken)
+	hs.Assert().EqualError(err, "revoke token failed: token was not issued to the client\n")
+	err = hs.Client.Revoke(context.Background(), billing, tokens.RefreshToken)
+	hs.Assert().EqualError(err, "revoke token failed: refresh token was not issued to the client\n")
+
+	for _, token := range []string{tokens.AccessToken, tokens.RefreshToken} {
+		introspection, err := hs.Client.Introspect(context.Background(), hs.Credentials, token)
+		hs.Require().NoError(err)
+		hs.Assert().True(introspection.Active, "only the client the token was issued to should revoke it")
+	}
+}
+
+func (hs *HandlerSuite) TestRevokeClientToken() {
+	tokens, err := hs.Client.ClientCredentials(context.Background(), hs.Credentials)
+	hs.Require().NoError(err)
+
+	hs.Require().NoError(hs.Client.Revoke(context.Background(), hs.Credentials, tokens.AccessToken))
+
+	introspection, err := hs.Client.Introspect(context.Background(), hs.Credentials, tokens.AccessToken)
+	hs.Require().NoError(err)
+	hs.Assert().False(introspection.Active)
+}
+
+func (hs *HandlerSuite) TestRevokeUnknownToken() {
+	hs.Assert().NoError(hs.Client.Revoke(context.Background(), hs.Credentials, "foo"))
+}
+
+func (hs *HandlerSuite) TestAccessTokenScopes() {
+	hs.Roles["1234"] = []string{"admin"}
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+
+	introspection, err := hs.Client.Introspect(context.Background(), hs.Credentials, tokens.AccessToken)
+	hs.Require().NoError(err)
+	hs.Assert().Equal("users:read users:delete", introspection.Scope)
+	hs.Assert().Equal([]string{"admin"}, introspection.User.Roles)
+}
+
+func (hs *HandlerSuite) TestCallerRolesIgnored() {
+	hs.User.Roles = []string{"admin"}
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+
+	introspection, err := hs.Client.Introspect(context.Background(), hs.Credentials, tokens.AccessToken)
+	hs.Require().NoError(err)
+	hs.Assert().Empty(introspection.Scope)
+	hs.Assert().Empty(introspection.User.Roles)
+}
+
+func (hs *HandlerSuite) TestRefreshedTokenHasCurrentRoles() {
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+
+	hs.Roles["1234"] = []string{"admin"}
+	tokens, err = hs.Client.RefreshToken(context.Background(), hs.Credentials, tokens.RefreshToken)
+	hs.Require().NoError(err)
+
+	introspection, err := hs.Client.Introspect(context.Background(), hs.Credentials, tokens.AccessToken)
+	hs.Require().NoError(err)
+	hs.Assert().Equal("users:read users:delete", introspection.Scope)
+}
+
+func (hs *HandlerSuite) TestRefreshTokenInvalidClient() {
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+
+	_, err = hs.Client.RefreshToken(context.Background(), client.Credentials{ClientID: "oauth-service", Secret: "wrong"}, tokens.RefreshToken)
+	hs.Assert().EqualError(err, "refresh token failed: client authentication failed\n")
+	hs.Assert().Equal(http.StatusUnauthorized, client.StatusCode(err))
+}
+
+func (hs *HandlerSuite) TestRefreshOtherClientsToken() {
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+
+	_, err = hs.Client.RefreshToken(context.Background(), client.Credentials{ClientID: "billing", Keys: hs.Keys}, tokens.RefreshToken)
+	hs.Assert().EqualError(err, "refresh token failed: invalid_grant: refresh token was not issued to the client\n")
+	hs.Assert().Equal(http.StatusBadRequest, client.StatusCode(err))
+
+	_, err = hs.Client.RefreshToken(context.Background(), hs.Credentials, tokens.RefreshToken)
+	hs.Assert().NoError(err, "the token should still be usable by the client it was issued to")
+}
+
+func (hs *HandlerSuite) TestClientCredentialsSecret() {
+	tokens, err := hs.Client.ClientCredentials(context.Background(), client.Credentials{ClientID: "oauth-service", Secret: "secret"})
+	hs.Require().NoError(err)
+	hs.Assert().Empty(tokens.RefreshToken)
+
+	introspection, err := hs.Client.Introspect(context.Background(), hs.Credentials, tokens.AccessToken)
+	hs.Require().NoError(err)
+	hs.Assert().True(introspection.Active)
+	hs.Assert().Equal("oauth-service", introspection.Subject)
+	hs.Assert().Equal("oauth-service", introspection.ClientID)
+	hs.Assert().Equal("users:read users:write tokens:exchange", introspection.Scope)
+	hs.Assert().Nil(introspection.User)
+}
+
+func (hs *HandlerSuite) TestClientCredentialsScopes() {
+	tokens, err := hs.Client.ClientCredentials(context.Background(), client.Credentials{ClientID: "oauth-service", Secret: "secret", Scopes: []string{"users:read"}})
+	hs.Require().NoError(err)
+
+	introspection, err := hs.Client.Introspect(context.Background(), hs.Credentials, tokens.AccessToken)
+	hs.Require().NoError(err)
+	hs.Assert().Equal("users:read", introspection.Scope)
+
+	_, err = hs.Client.ClientCredentials(context.Background(), client.Credentials{ClientID: "oauth-service", Secret: "secret", Scopes: []string{"users:delete"}})
+	hs.Assert().EqualError(err, "client credentials failed: requested scope is not allowed\n")
+}
+
+func (hs *HandlerSuite) TestClientCredentialsAssertion() {
+	tokens, err := hs.Client.ClientCredentials(context.Background(), client.Credentials{ClientID: "billing", Keys: hs.Keys})
+	hs.Require().NoError(err)
+
+	introspection, err := hs.Client.Introspect(context.Background(), hs.Credentials, tokens.AccessToken)
+	hs.Require().NoError(err)
+	hs.Assert().Equal("billing", introspection.ClientID)
+	hs.Assert().Equal("users:read tokens:exchange", introspection.Scope)
+}
+
+func (hs *HandlerSuite) TestClientCredentialsInvalidClient() {
+	_, err := hs.Client.ClientCredentials(context.Background(), client.Credentials{ClientID: "oauth-service", Secret: "wrong"})
+	hs.Assert().EqualError(err, "client credentials failed: client authentication failed\n")
+}
+
+func (hs *HandlerSuite) TestTokenSource() {
+	tokens := hs.Client.TokenSource(client.Credentials{ClientID: "oauth-service", Secret: "secret"})
+	first, err := tokens.Token(context.Background())
+	hs.Require().NoError(err)
+	second, err := tokens.Token(context.Background())
+	hs.Require().NoError(err)
+	hs.Assert().Equal(first, second, "the token should be reused until it is about to expire")
+	hs.Assert().WithinDuration(time.Now().Add(time.Minute*15), first.ExpiresAt, time.Minute)
+}
+
+func (hs *HandlerSuite) TestExchangeTokenUntrusted() {
+	_, err := hs.Anonymous.ExchangeToken(context.Background(), hs.User)
+	hs.Assert().EqualError(err, "exchange token failed: caller is not trusted to exchange tokens\n")
+}
+
+func (hs *HandlerSuite) TestExchangeTokenWithoutExchangeScope() {
+	tokens := hs.Anonymous.TokenSource(client.Credentials{ClientID: "oauth-service", Secret: "secret", Scopes: []string{"users:read"}})
+	c := client.New(client.WithBaseAddress(hs.Server.URL), client.WithTokenSource(tokens))
+
+	_, err := c.ExchangeToken(context.Background(), hs.User)
+	hs.Assert().EqualError(err, "exchange token failed: caller is not trusted to exchange tokens\n")
+}
+
+func (hs *HandlerSuite) TestExchangeTokenWithUserToken() {
+	tokens, err := hs.Client.ExchangeToken(context.Background(), hs.User)
+	hs.Require().NoError(err)
+
+	_, err = hs.Anonymous.ExchangeToken(httputil.ContextWithBearerToken(context.Background(), tokens.AccessToken), jwt.User{ID: "5678"})
+	hs.Assert().EqualError(err, "exchange token failed: caller is not trusted to exchange tokens\n")
+}
@@ -1,10 +1,22 @@
 module github.com/darren-west/app/auth-service
 
+go 1.11
+
 require (
 	github.com/darren-west/app/user-service v0.0.0-20181116142938-ab0bccd74720
 	github.com/darren-west/app/utils v0.0.0-20181116154356-1025072d162e
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/golang/mock v1.1.1
+	github.com/google/uuid v1.0.0
+	github.com/hashicorp/errwrap v1.0.0
 	github.com/julienschmidt/httprouter v1.2.0
 	github.com/sirupsen/logrus v1.2.0
+	github.com/stretchr/testify v1.2.2
+	go.mongodb.org/mongo-driver v1.17.10
 	gopkg.in/resty.v1 v1.10.2
 )
+
+replace (
+	github.com/darren-west/app/user-service => ../user-service
+	github.com/darren-west/app/utils => ../utils
+)
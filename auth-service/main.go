@@ -1,16 +1,166 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"time"
 
+	"github.com/darren-west/app/auth-service/clients"
+	"github.com/darren-west/app/auth-service/config"
 	"github.com/darren-west/app/auth-service/controller"
+	"github.com/darren-west/app/auth-service/refresh"
+	"github.com/darren-west/app/auth-service/revocation"
+	"github.com/darren-west/app/auth-service/roles"
+	"github.com/darren-west/app/auth-service/trust"
+	userclient "github.com/darren-west/app/user-service/client"
+	"github.com/darren-west/app/utils/fileutil"
+	"github.com/darren-west/app/utils/jwt"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	configFlag = flag.String("config", "", "--config the path to the configuration file, the defaults and environment variables are used without one")
+)
+
+func init() {
+	flag.Parse()
+}
+
 func main() {
+	reader, err := config.NewReader(fileutil.FileReader{})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	config, err := reader.Read(*configFlag)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	level, _ := logrus.ParseLevel(config.LogLevel)
+	logrus.SetLevel(level)
+
+	active, signingKeys, err := jwt.ReadKeySet(fileutil.FileReader{}, config.Keys.SetPath)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	keys, err := jwt.NewKeySet(active, signingKeys...)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	go reloadKeys(keys, config.Keys)
+
+	store, err := refresh.NewMongoStore(config.Mongo.ConnectionString, config.Mongo.DatabaseName)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	denylist, err := revocation.NewMongoDenylist(config.Mongo.ConnectionString, config.Mongo.DatabaseName)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	roleSource := roles.NewUserServiceSource(
+		userclient.New(userclient.WithBaseAddress(config.UserServiceAddress)),
+		jwt.NewWriter(jwt.WriterBuilder.WithKeySet(keys)),
+		config.Tokens.Issuer,
+		config.Tokens.Audience,
+	)
+
+	registry, err := clients.NewRegistry()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if config.Clients.Path != "" {
+		registered, err := clients.Read(fileutil.FileReader{}, config.Clients.Path)
+		if err == nil {
+			err = registry.Set(registered...)
+		}
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	exchangeTrust := newExchangeTrust(
+		config.Exchange,
+		jwt.NewReader(
+			jwt.ReaderBuilder.WithKeySource(keys),
+			jwt.ReaderBuilder.WithRevocationChecker(denylist),
+			jwt.ReaderBuilder.WithIssuer(config.Tokens.Issuer),
+			jwt.ReaderBuilder.WithAudience(config.Tokens.Audience),
+		),
+		clients.NewAuthenticator(registry, config.Exchange.Audience, denylist),
+	)
+
 	router := httprouter.New()
-	if err := http.ListenAndServe(":80", controller.NewHandler("../utils/jwt/testdata/app.rsa", router)); err != nil {
-		logrus.Error(err)
+	handler := controller.NewHandler(
+		keys,
+		refresh.NewIssuer(store, config.Tokens.RefreshTokenLifetime.Duration()),
+		denylist,
+		router,
+		controller.WithAccessTokenLifetime(config.Tokens.AccessTokenLifetime.Duration()),
+		controller.WithIssuer(config.Tokens.Issuer),
+		controller.WithAudience(config.Tokens.Audience),
+		controller.WithRoles(roleSource, roles.DefaultScopes),
+		controller.WithClients(registry, config.Clients.TokenEndpoint),
+		controller.WithExchangeTrust(exchangeTrust),
+	)
+	logrus.WithField("address", config.BindAddress).Info("Starting auth service.")
+	if err := listenAndServe(config.BindAddress, config.TLS, handler); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+// newExchangeTrust returns the verifier of the callers trusted to exchange users for tokens. The options have
+// been validated, so every trust is known.
+func newExchangeTrust(options config.ExchangeOptions, reader jwt.Reader, authenticator clients.Authenticator) (verifiers trust.Any) {
+	for _, name := range options.Trust {
+		switch name {
+		case config.TrustServiceClient:
+			verifiers = append(verifiers, trust.ServiceClient{Reader: reader})
+		case config.TrustHandoff:
+			verifiers = append(verifiers, trust.Handoff{Authenticator: authenticator})
+		case config.TrustCertificate:
+			verifiers = append(verifiers, trust.Certificate{Allowed: options.Certificates})
+		}
+	}
+	return
+}
+
+// listenAndServe serves the handler, over tls when a certificate is set. Client certificates are verified with
+// the client ca when they are given, callers without one can still authenticate in other ways.
+func listenAndServe(address string, options config.TLSOptions, handler http.Handler) error {
+	if !options.Enabled() {
+		return http.ListenAndServe(address, handler)
+	}
+	server := &http.Server{Addr: address, Handler: handler}
+	if options.ClientCAPath != "" {
+		data, err := ioutil.ReadFile(options.ClientCAPath)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %s", options.ClientCAPath)
+		}
+		server.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	}
+	return server.ListenAndServeTLS(options.CertPath, options.KeyPath)
+}
+
+// reloadKeys reads the key set file again on an interval so keys can be rotated without a restart. A key set
+// that fails to load is logged and the current keys keep being used.
+func reloadKeys(keys *jwt.KeySet, options config.KeyOptions) {
+	for range time.Tick(options.ReloadInterval.Duration()) {
+		active, signingKeys, err := jwt.ReadKeySet(fileutil.FileReader{}, options.SetPath)
+		if err == nil {
+			err = keys.Set(active, signingKeys...)
+		}
+		if err != nil {
+			logrus.WithError(err).Error("Failed to reload the signing key set.")
+		}
 	}
 }
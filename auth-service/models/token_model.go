@@ -0,0 +1,41 @@
+package models
+
+import "github.com/darren-west/app/utils/jwt"
+
+// Tokens are the tokens issued to a user. The access token is a signed jwt, the refresh token is opaque and is
+// exchanged for new tokens before the access token expires.
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// Introspection describes a token, as returned by the introspection endpoint (RFC 7662). Only Active is set when
+// the token is not active.
+type Introspection struct {
+	Active    bool      `json:"active"`
+	TokenType string    `json:"token_type,omitempty"`
+	Subject   string    `json:"sub,omitempty"`
+	Issuer    string    `json:"iss,omitempty"`
+	Audience  []string  `json:"aud,omitempty"`
+	ExpiresAt int64     `json:"exp,omitempty"`
+	IssuedAt  int64     `json:"iat,omitempty"`
+	NotBefore int64     `json:"nbf,omitempty"`
+	ID        string    `json:"jti,omitempty"`
+	Scope     string    `json:"scope,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	User      *jwt.User `json:"user,omitempty"`
+}
+
+// Token type hints and introspection token types.
+const (
+	AccessTokenType  = "access_token"
+	RefreshTokenType = "refresh_token"
+)
+
+// ScopeExchangeTokens allows a service client to exchange users for tokens.
+const ScopeExchangeTokens = "tokens:exchange"
+
+// ClientAssertionType is the client assertion type of jwt assertions clients authenticate with (RFC 7523).
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
@@ -0,0 +1,160 @@
+// Package refresh issues opaque refresh tokens and rotates them. Each refresh token can be used once, using it
+// returns a new refresh token in the same family. If a used refresh token is presented again the whole family
+// is revoked, as either the legitimate user or an attacker holds a stolen copy.
+package refresh
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidToken is returned when the refresh token is unknown or has expired.
+	ErrInvalidToken = errors.New("refresh token invalid")
+	// ErrTokenReused is returned when a refresh token that has already been rotated is used again.
+	ErrTokenReused = errors.New("refresh token reused")
+	// ErrNotFound is returned by a Store when there is no token with the id.
+	ErrNotFound = errors.New("refresh token not found")
+	// ErrWrongClient is returned when a client rotates or revokes a refresh token that was issued to another client.
+	ErrWrongClient = errors.New("refresh token was not issued to the client")
+)
+
+// Token is a stored refresh token. The token itself is never stored, only a hash of it. The client id is the
+// client the token was issued to.
+type Token struct {
+	ID        string    `bson:"_id"`
+	Family    string    `bson:"family"`
+	User      jwt.User  `bson:"user"`
+	ClientID  string    `bson:"client_id,omitempty"`
+	ExpiresAt time.Time `bson:"expires_at"`
+	Used      bool      `bson:"used"`
+}
+
+// Store persists refresh tokens.
+type Store interface {
+	// Create stores a new token.
+	Create(token Token) error
+	// Get returns the token with the id, or ErrNotFound.
+	Get(id string) (Token, error)
+	// Use marks the token as used. It returns ErrTokenReused if the token was already used, so only one caller
+	// can rotate a token.
+	Use(id string) error
+	// RevokeFamily removes every token in the family.
+	RevokeFamily(family string) error
+}
+
+// NewIssuer returns an issuer that stores tokens in the store, valid for the lifetime given.
+func NewIssuer(store Store, lifetime time.Duration) Issuer {
+	return Issuer{store: store, lifetime: lifetime, now: time.Now}
+}
+
+// Issuer issues and rotates refresh tokens.
+type Issuer struct {
+	store    Store
+	lifetime time.Duration
+	now      func() time.Time
+}
+
+// Lifetime is how long issued refresh tokens are valid for.
+func (i Issuer) Lifetime() time.Duration {
+	return i.lifetime
+}
+
+// Issue returns a new refresh token for the user issued to the client, starting a new family.
+func (i Issuer) Issue(user jwt.User, clientID string) (string, error) {
+	return i.issue(Token{Family: uuid.New().String(), User: user, ClientID: clientID})
+}
+
+// Rotate uses the refresh token, if it was issued to the client, and returns the stored token, with the user and
+// client it was issued to, and the refresh token replacing it. ErrWrongClient is returned when it was issued to
+// another client, the token is left as it is so another client can't use it up or revoke its family.
+func (i Issuer) Rotate(refreshToken, clientID string) (token Token, next string, err error) {
+	token, err = i.store.Get(hash(refreshToken))
+	if err == ErrNotFound || (err == nil && !i.now().Before(token.ExpiresAt)) {
+		return Token{}, "", ErrInvalidToken
+	}
+	if err != nil {
+		return
+	}
+	if token.ClientID != clientID {
+		return Token{}, "", ErrWrongClient
+	}
+	if token.Used {
+		err = i.reused(token)
+		return
+	}
+	switch err = i.store.Use(token.ID); err {
+	case nil:
+	case ErrTokenReused:
+		err = i.reused(token)
+		return
+	case ErrNotFound:
+		err = ErrInvalidToken
+		return
+	default:
+		return
+	}
+	next, err = i.issue(token)
+	return token, next, err
+}
+
+// Lookup returns the stored token for the refresh token if it is still usable, or ErrInvalidToken.
+func (i Issuer) Lookup(refreshToken string) (token Token, err error) {
+	token, err = i.store.Get(hash(refreshToken))
+	if err == ErrNotFound || (err == nil && (token.Used || !i.now().Before(token.ExpiresAt))) {
+		return Token{}, ErrInvalidToken
+	}
+	return
+}
+
+// Revoke revokes the refresh token and every token in its family, if the token was issued to the client.
+// ErrWrongClient is returned when it was issued to another client. Unknown tokens are ignored.
+func (i Issuer) Revoke(refreshToken, clientID string) error {
+	token, err := i.store.Get(hash(refreshToken))
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if token.ClientID != clientID {
+		return ErrWrongClient
+	}
+	return i.store.RevokeFamily(token.Family)
+}
+
+func (i Issuer) reused(token Token) error {
+	if err := i.store.RevokeFamily(token.Family); err != nil {
+		return err
+	}
+	return ErrTokenReused
+}
+
+// issue stores a new refresh token in the family of the token given, for the same user and client.
+func (i Issuer) issue(from Token) (refreshToken string, err error) {
+	data := make([]byte, 32)
+	if _, err = rand.Read(data); err != nil {
+		return
+	}
+	refreshToken = base64.RawURLEncoding.EncodeToString(data)
+	err = i.store.Create(Token{
+		ID:        hash(refreshToken),
+		Family:    from.Family,
+		User:      from.User,
+		ClientID:  from.ClientID,
+		ExpiresAt: i.now().Add(i.lifetime),
+	})
+	return
+}
+
+func hash(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
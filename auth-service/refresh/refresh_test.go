@@ -0,0 +1,108 @@
+package refresh_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/darren-west/app/auth-service/refresh"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestIssuerSuite(t *testing.T) {
+	suite.Run(t, &IssuerSuite{})
+}
+
+type IssuerSuite struct {
+	suite.Suite
+	store  *refresh.MemoryStore
+	issuer refresh.Issuer
+	user   jwt.User
+}
+
+func (is *IssuerSuite) SetupTest() {
+	is.store = refresh.NewMemoryStore()
+	is.issuer = refresh.NewIssuer(is.store, time.Hour)
+	is.user = jwt.User{ID: "1234", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}
+}
+
+func (is *IssuerSuite) TestRotate() {
+	token, err := is.issuer.Issue(is.user, "oauth-service")
+	is.Require().NoError(err)
+	is.Assert().NotEmpty(token)
+
+	stored, next, err := is.issuer.Rotate(token, "oauth-service")
+	is.Require().NoError(err)
+	is.Assert().Equal(is.user, stored.User)
+	is.Assert().NotEqual(token, next)
+
+	stored, _, err = is.issuer.Rotate(next, "oauth-service")
+	is.Require().NoError(err)
+	is.Assert().Equal(is.user, stored.User)
+	is.Assert().Equal("oauth-service", stored.ClientID, "rotated tokens should be issued to the same client")
+}
+
+func (is *IssuerSuite) TestRotateUnknownToken() {
+	_, _, err := is.issuer.Rotate("foo", "oauth-service")
+	is.Assert().Equal(refresh.ErrInvalidToken, err)
+}
+
+func (is *IssuerSuite) TestRotateExpiredToken() {
+	token, err := refresh.NewIssuer(is.store, -time.Second).Issue(is.user, "oauth-service")
+	is.Require().NoError(err)
+
+	_, _, err = is.issuer.Rotate(token, "oauth-service")
+	is.Assert().Equal(refresh.ErrInvalidToken, err)
+}
+
+func (is *IssuerSuite) TestReuseRevokesFamily() {
+	token, err := is.issuer.Issue(is.user, "oauth-service")
+	is.Require().NoError(err)
+	_, next, err := is.issuer.Rotate(token, "oauth-service")
+	is.Require().NoError(err)
+
+	other, err := is.issuer.Issue(is.user, "oauth-service")
+	is.Require().NoError(err)
+
+	_, _, err = is.issuer.Rotate(token, "oauth-service")
+	is.Assert().Equal(refresh.ErrTokenReused, err)
+
+	_, _, err = is.issuer.Rotate(next, "oauth-service")
+	is.Assert().Equal(refresh.ErrInvalidToken, err, "tokens descended from the reused token should be revoked")
+
+	_, _, err = is.issuer.Rotate(other, "oauth-service")
+	is.Assert().NoError(err, "tokens from other logins should not be revoked")
+}
+
+func (is *IssuerSuite) TestRotateWrongClient() {
+	token, err := is.issuer.Issue(is.user, "oauth-service")
+	is.Require().NoError(err)
+
+	_, _, err = is.issuer.Rotate(token, "billing")
+	is.Assert().Equal(refresh.ErrWrongClient, err)
+
+	_, err = is.issuer.Lookup(token)
+	is.Assert().NoError(err, "another client should not use the token")
+}
+
+func (is *IssuerSuite) TestRevoke() {
+	token, err := is.issuer.Issue(is.user, "oauth-service")
+	is.Require().NoError(err)
+
+	is.Assert().Equal(refresh.ErrWrongClient, is.issuer.Revoke(token, "billing"))
+	_, err = is.issuer.Lookup(token)
+	is.Assert().NoError(err, "another client should not revoke the token")
+
+	is.Require().NoError(is.issuer.Revoke(token, "oauth-service"))
+	_, err = is.issuer.Lookup(token)
+	is.Assert().Equal(refresh.ErrInvalidToken, err)
+
+	is.Assert().NoError(is.issuer.Revoke("foo", "oauth-service"), "unknown tokens should be ignored")
+}
+
+func (is *IssuerSuite) TestMemoryStoreUseOnce() {
+	is.Require().NoError(is.store.Create(refresh.Token{ID: "1", Family: "a", ExpiresAt: time.Now().Add(time.Hour)}))
+	is.Assert().NoError(is.store.Use("1"))
+	is.Assert().Equal(refresh.ErrTokenReused, is.store.Use("1"))
+	is.Assert().Equal(refresh.ErrNotFound, is.store.Use("2"))
+}
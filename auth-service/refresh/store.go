@@ -0,0 +1,130 @@
+package refresh
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/darren-west/app/utils/mongoutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const refreshTokensCollection = "RefreshTokens"
+
+// connectTimeout is how long the store waits for mongo when it is created.
+const connectTimeout = 30 * time.Second
+
+// NewMemoryStore returns a store that keeps tokens in memory. Tokens are lost on restart, it is intended for tests
+// and running locally.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tokens: make(map[string]Token)}
+}
+
+// MemoryStore is an in memory Store.
+type MemoryStore struct {
+	mu     sync.Mutex
+	tokens map[string]Token
+}
+
+// Create stores a new token.
+func (s *MemoryStore) Create(token Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token.ID] = token
+	return nil
+}
+
+// Get returns the token with the id.
+func (s *MemoryStore) Get(id string) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[id]
+	if !ok {
+		return Token{}, ErrNotFound
+	}
+	return token, nil
+}
+
+// Use marks the token as used.
+func (s *MemoryStore) Use(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if token.Used {
+		return ErrTokenReused
+	}
+	token.Used = true
+	s.tokens[id] = token
+	return nil
+}
+
+// RevokeFamily removes every token in the family.
+func (s *MemoryStore) RevokeFamily(family string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, token := range s.tokens {
+		if token.Family == family {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+// NewMongoStore returns a store that keeps tokens in mongo. Expired tokens are removed by a ttl index.
+func NewMongoStore(connectionString, databaseName string) (store *MongoStore, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	client, err := mongoutil.Connect(ctx, mongoutil.Options{ConnectionString: connectionString})
+	if err != nil {
+		return
+	}
+	store = &MongoStore{collection: client.Database(databaseName).Collection(refreshTokensCollection)}
+	_, err = store.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "family", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return
+}
+
+// MongoStore is a Store backed by mongo.
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+// Create stores a new token.
+func (s *MongoStore) Create(token Token) error {
+	_, err := s.collection.InsertOne(context.Background(), &token)
+	return err
+}
+
+// Get returns the token with the id.
+func (s *MongoStore) Get(id string) (token Token, err error) {
+	err = s.collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&token)
+	if err == mongo.ErrNoDocuments {
+		err = ErrNotFound
+	}
+	return
+}
+
+// Use marks the token as used. The update only matches an unused token so concurrent rotations can't both succeed.
+func (s *MongoStore) Use(id string) error {
+	result, err := s.collection.UpdateOne(context.Background(), bson.M{"_id": id, "used": false}, bson.M{"$set": bson.M{"used": true}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrTokenReused
+	}
+	return nil
+}
+
+// RevokeFamily removes every token in the family.
+func (s *MongoStore) RevokeFamily(family string) error {
+	_, err := s.collection.DeleteMany(context.Background(), bson.M{"family": family})
+	return err
+}
@@ -0,0 +1,64 @@
+// +build integration
+
+package refresh_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/darren-west/app/auth-service/refresh"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMongoStore returns a store in a new database of the mongo listening on the default port.
+func newMongoStore(t *testing.T) *refresh.MongoStore {
+	store, err := refresh.NewMongoStore("mongodb://127.0.0.1:27017", fmt.Sprintf("refresh%d", time.Now().UnixNano()))
+	require.NoError(t, err)
+	return store
+}
+
+func TestMongoStoreRotate(t *testing.T) {
+	issuer := refresh.NewIssuer(newMongoStore(t), time.Hour)
+	user := jwt.User{ID: "1234", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}
+	token, err := issuer.Issue(user, "oauth-service")
+	require.NoError(t, err)
+
+	stored, next, err := issuer.Rotate(token, "oauth-service")
+	require.NoError(t, err)
+	assert.Equal(t, user, stored.User)
+	assert.Equal(t, "oauth-service", stored.ClientID)
+
+	_, _, err = issuer.Rotate(token, "oauth-service")
+	assert.Equal(t, refresh.ErrTokenReused, err)
+	_, _, err = issuer.Rotate(next, "oauth-service")
+	assert.Equal(t, refresh.ErrInvalidToken, err, "reusing a token should revoke its family")
+}
+
+func TestMongoStoreGetNotFound(t *testing.T) {
+	_, err := newMongoStore(t).Get("foo")
+	assert.Equal(t, refresh.ErrNotFound, err)
+}
+
+func TestMongoStoreUseOnce(t *testing.T) {
+	store := newMongoStore(t)
+	require.NoError(t, store.Create(refresh.Token{ID: "token", Family: "family", ExpiresAt: time.Now().Add(time.Hour)}))
+
+	var used int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if store.Use("token") == nil {
+				atomic.AddInt32(&used, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), used, "only one caller should use the token")
+}
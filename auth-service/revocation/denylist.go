@@ -0,0 +1,143 @@
+// Package revocation keeps a denylist of revoked access tokens. Tokens are denied by their jti until they
+// expire, after which the token is rejected anyway and the entry is removed.
+package revocation
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/darren-west/app/utils/mongoutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const revokedTokensCollection = "RevokedTokens"
+
+// connectTimeout is how long the denylist waits for mongo when it is created.
+const connectTimeout = 30 * time.Second
+
+// ErrAlreadyRevoked is returned by RevokeOnce when the token has already been revoked.
+var ErrAlreadyRevoked = errors.New("token has already been revoked")
+
+// Denylist records revoked tokens. It implements jwt.RevocationChecker so it can be used by a jwt.Reader.
+type Denylist interface {
+	jwt.RevocationChecker
+	// Revoke denies the token with the id until it expires.
+	Revoke(id string, expiresAt time.Time) error
+	// RevokeOnce denies the token with the id until it expires, or returns ErrAlreadyRevoked if it already is.
+	// Checking and revoking is a single operation, so only one caller can revoke the id and single use tokens
+	// can't be replayed by concurrent requests.
+	RevokeOnce(id string, expiresAt time.Time) error
+}
+
+var (
+	_ Denylist = &MemoryDenylist{}
+	_ Denylist = &MongoDenylist{}
+)
+
+// NewMemoryDenylist returns a denylist kept in memory. Revocations are lost on restart, it is intended for tests
+// and running locally.
+func NewMemoryDenylist() *MemoryDenylist {
+	return &MemoryDenylist{revoked: make(map[string]time.Time), now: time.Now}
+}
+
+// MemoryDenylist is an in memory Denylist.
+type MemoryDenylist struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+	now     func() time.Time
+}
+
+// Revoke denies the token with the id until it expires.
+func (d *MemoryDenylist) Revoke(id string, expiresAt time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.revoked[id] = expiresAt
+	return nil
+}
+
+// RevokeOnce denies the token with the id until it expires, or returns ErrAlreadyRevoked if it already is.
+func (d *MemoryDenylist) RevokeOnce(id string, expiresAt time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.isRevoked(id) {
+		return ErrAlreadyRevoked
+	}
+	d.revoked[id] = expiresAt
+	return nil
+}
+
+// IsRevoked returns true if the token has been revoked. Expired entries are removed.
+func (d *MemoryDenylist) IsRevoked(claims *jwt.Claims) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.isRevoked(claims.ID), nil
+}
+
+// isRevoked removes the expired entries and returns true if the id is revoked. The lock must be held.
+func (d *MemoryDenylist) isRevoked(id string) bool {
+	now := d.now()
+	for revoked, expiresAt := range d.revoked {
+		if !now.Before(expiresAt) {
+			delete(d.revoked, revoked)
+		}
+	}
+	_, ok := d.revoked[id]
+	return ok
+}
+
+// NewMongoDenylist returns a denylist kept in mongo. Expired entries are removed by a ttl index.
+func NewMongoDenylist(connectionString, databaseName string) (denylist *MongoDenylist, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	client, err := mongoutil.Connect(ctx, mongoutil.Options{ConnectionString: connectionString})
+	if err != nil {
+		return
+	}
+	denylist = &MongoDenylist{collection: client.Database(databaseName).Collection(revokedTokensCollection)}
+	_, err = denylist.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return
+}
+
+// MongoDenylist is a Denylist backed by mongo.
+type MongoDenylist struct {
+	collection *mongo.Collection
+}
+
+type revokedToken struct {
+	ID        string    `bson:"_id"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Revoke denies the token with the id until it expires.
+func (d *MongoDenylist) Revoke(id string, expiresAt time.Time) error {
+	_, err := d.collection.ReplaceOne(context.Background(),
+		bson.M{"_id": id},
+		revokedToken{ID: id, ExpiresAt: expiresAt},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// RevokeOnce denies the token with the id until it expires, or returns ErrAlreadyRevoked if it already is. The id
+// is inserted as the document id, so only one insert can succeed.
+func (d *MongoDenylist) RevokeOnce(id string, expiresAt time.Time) error {
+	_, err := d.collection.InsertOne(context.Background(), revokedToken{ID: id, ExpiresAt: expiresAt})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyRevoked
+	}
+	return err
+}
+
+// IsRevoked returns true if the token has been revoked.
+func (d *MongoDenylist) IsRevoked(claims *jwt.Claims) (bool, error) {
+	n, err := d.collection.CountDocuments(context.Background(), bson.M{"_id": claims.ID})
+	return n > 0, err
+}
@@ -0,0 +1,101 @@
+package revocation_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/darren-west/app/auth-service/revocation"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryDenylist(t *testing.T) {
+	denylist := revocation.NewMemoryDenylist()
+	require.NoError(t, denylist.Revoke("revoked", time.Now().Add(time.Hour)))
+
+	revoked, err := denylist.IsRevoked(&jwt.Claims{ID: "revoked"})
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = denylist.IsRevoked(&jwt.Claims{ID: "other"})
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestMemoryDenylistRevokeOnce(t *testing.T) {
+	denylist := revocation.NewMemoryDenylist()
+	require.NoError(t, denylist.RevokeOnce("revoked", time.Now().Add(time.Hour)))
+	assert.Equal(t, revocation.ErrAlreadyRevoked, denylist.RevokeOnce("revoked", time.Now().Add(time.Hour)))
+
+	require.NoError(t, denylist.Revoke("expired", time.Now().Add(-time.Second)))
+	assert.NoError(t, denylist.RevokeOnce("expired", time.Now().Add(time.Hour)), "expired entries should not count as revoked")
+}
+
+func TestMemoryDenylistRevokeOnceConcurrent(t *testing.T) {
+	denylist := revocation.NewMemoryDenylist()
+	var revoked int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if denylist.RevokeOnce("token", time.Now().Add(time.Hour)) == nil {
+				atomic.AddInt32(&revoked, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), revoked, "only one caller should revoke the token")
+}
+
+func TestMemoryDenylistExpiry(t *testing.T) {
+	denylist := revocation.NewMemoryDenylist()
+	require.NoError(t, denylist.Revoke("expired", time.Now().Add(-time.Second)))
+	require.NoError(t, denylist.Revoke("expiring", time.Now().Add(50*time.Millisecond)))
+	require.NoError(t, denylist.Revoke("revoked", time.Now().Add(time.Hour)))
+
+	revoked, err := denylist.IsRevoked(&jwt.Claims{ID: "expired"})
+	require.NoError(t, err)
+	assert.False(t, revoked, "expired entries should be removed")
+
+	revoked, err = denylist.IsRevoked(&jwt.Claims{ID: "expiring"})
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	time.Sleep(100 * time.Millisecond)
+	revoked, err = denylist.IsRevoked(&jwt.Claims{ID: "expiring"})
+	require.NoError(t, err)
+	assert.False(t, revoked, "entries should be removed once they expire")
+
+	revoked, err = denylist.IsRevoked(&jwt.Claims{ID: "revoked"})
+	require.NoError(t, err)
+	assert.True(t, revoked, "entries that have not expired should be kept")
+}
+
+func TestMemoryDenylistConcurrent(t *testing.T) {
+	denylist := revocation.NewMemoryDenylist()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			assert.NoError(t, denylist.Revoke(id, time.Now().Add(time.Hour)))
+			revoked, err := denylist.IsRevoked(&jwt.Claims{ID: id})
+			assert.NoError(t, err)
+			assert.True(t, revoked)
+			_, err = denylist.IsRevoked(&jwt.Claims{ID: "other"})
+			assert.NoError(t, err)
+		}(fmt.Sprintf("token-%d", i))
+	}
+	wg.Wait()
+
+	for i := 0; i < 50; i++ {
+		revoked, err := denylist.IsRevoked(&jwt.Claims{ID: fmt.Sprintf("token-%d", i)})
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	}
+}
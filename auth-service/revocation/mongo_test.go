@@ -0,0 +1,41 @@
+// +build integration
+
+package revocation_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/darren-west/app/auth-service/revocation"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMongoDenylist returns a denylist in a new database of the mongo listening on the default port.
+func newMongoDenylist(t *testing.T) *revocation.MongoDenylist {
+	denylist, err := revocation.NewMongoDenylist("mongodb://127.0.0.1:27017", fmt.Sprintf("revocation%d", time.Now().UnixNano()))
+	require.NoError(t, err)
+	return denylist
+}
+
+func TestMongoDenylist(t *testing.T) {
+	denylist := newMongoDenylist(t)
+	require.NoError(t, denylist.Revoke("revoked", time.Now().Add(time.Hour)))
+	require.NoError(t, denylist.Revoke("revoked", time.Now().Add(2*time.Hour)), "revoking again should not fail")
+
+	revoked, err := denylist.IsRevoked(&jwt.Claims{ID: "revoked"})
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = denylist.IsRevoked(&jwt.Claims{ID: "other"})
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestMongoDenylistRevokeOnce(t *testing.T) {
+	denylist := newMongoDenylist(t)
+	require.NoError(t, denylist.RevokeOnce("revoked", time.Now().Add(time.Hour)))
+	assert.Equal(t, revocation.ErrAlreadyRevoked, denylist.RevokeOnce("revoked", time.Now().Add(time.Hour)))
+}
@@ -0,0 +1,97 @@
+// Package roles looks up the roles of users and maps them to the scopes put in their access tokens.
+package roles
+
+import (
+	"context"
+	"time"
+
+	"github.com/darren-west/app/user-service/client"
+	"github.com/darren-west/app/user-service/models"
+	"github.com/darren-west/app/utils/httputil"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/google/uuid"
+)
+
+// ServiceSubject is the subject of the tokens auth-service signs for its own requests.
+const ServiceSubject = "auth-service"
+
+// DefaultScopes are the scopes given to each role when no others are configured.
+var DefaultScopes = Scopes{
+	models.RoleAdmin: {
+		models.ScopeReadUsers,
+		models.ScopeWriteUsers,
+		models.ScopeDeleteUsers,
+		models.ScopeWriteRoles,
+	},
+}
+
+// Source looks up the roles of a user.
+type Source interface {
+	Roles(ctx context.Context, userID string) ([]string, error)
+}
+
+// Scopes maps roles to the scopes users with the role are given.
+type Scopes map[string][]string
+
+// For returns the scopes given to a user with the roles, each scope once. Roles without scopes are ignored.
+func (s Scopes) For(roles []string) (scopes []string) {
+	seen := map[string]bool{}
+	for _, role := range roles {
+		for _, scope := range s[role] {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return
+}
+
+// UserGetter reads a user. It is implemented by the user service client.
+type UserGetter interface {
+	GetUser(ctx context.Context, id string) (models.UserInfo, error)
+}
+
+// NewUserServiceSource returns a source that reads roles from the user service. The user service needs a token
+// that can read other users, so the source signs one for each request with the writer, from the issuer for the
+// audience given. They are left out of the token when they are empty.
+func NewUserServiceSource(users UserGetter, writer jwt.Writer, issuer, audience string) Source {
+	return userServiceSource{Users: users, Writer: writer, Issuer: issuer, Audience: audience}
+}
+
+type userServiceSource struct {
+	Users    UserGetter
+	Writer   jwt.Writer
+	Issuer   string
+	Audience string
+}
+
+// Roles returns the roles of the user, users that haven't been created in the user service yet have none.
+func (s userServiceSource) Roles(ctx context.Context, userID string) ([]string, error) {
+	now := time.Now()
+	var audience jwt.Audience
+	if s.Audience != "" {
+		audience = jwt.Audience{s.Audience}
+	}
+	token, err := s.Writer.Write(&jwt.Claims{
+		Issuer:    s.Issuer,
+		Audience:  audience,
+		Subject:   ServiceSubject,
+		ID:        uuid.New().String(),
+		Scope:     models.ScopeReadUsers,
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		NotBefore: now.Unix(),
+		IssuedAt:  now.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.Users.GetUser(httputil.ContextWithBearerToken(ctx, token.String()), userID)
+	if client.IsNotFoundError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user.Roles, nil
+}
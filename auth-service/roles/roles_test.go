@@ -0,0 +1,80 @@
+package roles_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/darren-west/app/auth-service/roles"
+	"github.com/darren-west/app/user-service/models"
+	"github.com/darren-west/app/utils/fileutil"
+	"github.com/darren-west/app/utils/httputil"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopesFor(t *testing.T) {
+	scopes := roles.Scopes{
+		"admin":   {"users:read", "users:write"},
+		"support": {"users:read"},
+	}
+	assert.Equal(t, []string{"users:read", "users:write"}, scopes.For([]string{"support", "admin", "unknown"}))
+	assert.Empty(t, scopes.For(nil))
+}
+
+// userGetter returns the user, checking the request is made with a token the reader accepts.
+type userGetter struct {
+	t      *testing.T
+	reader jwt.Reader
+	user   models.UserInfo
+	err    error
+}
+
+func (g userGetter) GetUser(ctx context.Context, id string) (models.UserInfo, error) {
+	token, ok := httputil.BearerTokenFromContext(ctx)
+	require.True(g.t, ok)
+	claims, err := g.reader.Read(jwt.NewToken(token))
+	require.NoError(g.t, err)
+	assert.Equal(g.t, roles.ServiceSubject, claims.Subject)
+	assert.True(g.t, claims.HasScope(models.ScopeReadUsers))
+	return g.user, g.err
+}
+
+func TestUserServiceSource(t *testing.T) {
+	active, signingKeys, err := jwt.ReadKeySet(fileutil.FileReader{}, "../../utils/jwt/testdata/keys.json")
+	require.NoError(t, err)
+	keys, err := jwt.NewKeySet(active, signingKeys...)
+	require.NoError(t, err)
+	writer := jwt.NewWriter(jwt.WriterBuilder.WithKeySet(keys))
+	reader := jwt.NewReader(
+		jwt.ReaderBuilder.WithKeySource(keys),
+		jwt.ReaderBuilder.WithIssuer("auth-service"),
+		jwt.ReaderBuilder.WithAudience("app"),
+	)
+
+	tests := map[string]struct {
+		user          models.UserInfo
+		err           error
+		expectedRoles []string
+		expectedErr   string
+	}{
+		"roles":     {user: models.UserInfo{ID: "1234", Roles: []string{"admin"}}, expectedRoles: []string{"admin"}},
+		"no roles":  {user: models.UserInfo{ID: "1234"}},
+		"not found": {err: httputil.NewError(http.StatusNotFound).WithMessage("user not found")},
+		"error":     {err: errors.New("boom"), expectedErr: "boom"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			source := roles.NewUserServiceSource(userGetter{t: t, reader: reader, user: test.user, err: test.err}, writer, "auth-service", "app")
+			userRoles, err := source.Roles(context.Background(), "1234")
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedRoles, userRoles)
+		})
+	}
+}
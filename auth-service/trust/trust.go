@@ -0,0 +1,109 @@
+// Package trust decides which callers are trusted to exchange a user for tokens. Exchanging a user signs a token
+// for them without them being present, so only callers that have authenticated the user are trusted to.
+package trust
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/darren-west/app/auth-service/clients"
+	"github.com/darren-west/app/auth-service/models"
+	"github.com/darren-west/app/utils/httputil"
+	"github.com/darren-west/app/utils/jwt"
+)
+
+// HandoffHeader is the header a session handoff is sent in.
+const HandoffHeader = "Session-Handoff"
+
+// ErrUntrusted is returned when the caller is not trusted to exchange the user for tokens.
+var ErrUntrusted = errors.New("caller is not trusted to exchange tokens")
+
+// Verifier checks the caller is trusted to exchange the user for tokens, and returns the client the caller is so
+// the tokens can be issued to it. ErrUntrusted is returned when it is not trusted.
+type Verifier interface {
+	Verify(r *http.Request, user jwt.User) (client string, err error)
+}
+
+// Any trusts callers trusted by any of the verifiers. No verifiers trust no callers.
+type Any []Verifier
+
+// Verify returns the client of the first verifier that trusts the caller.
+func (a Any) Verify(r *http.Request, user jwt.User) (string, error) {
+	for _, v := range a {
+		client, err := v.Verify(r, user)
+		if err != ErrUntrusted {
+			return client, err
+		}
+	}
+	return "", ErrUntrusted
+}
+
+// ServiceClient trusts service clients calling with a client credentials token that has the exchange scope.
+type ServiceClient struct {
+	Reader httputil.TokenReader
+}
+
+// Verify returns the client id of the bearer token if it is a client token with the exchange scope.
+func (s ServiceClient) Verify(r *http.Request, _ jwt.User) (string, error) {
+	token, ok := httputil.BearerToken(r)
+	if !ok {
+		return "", ErrUntrusted
+	}
+	claims, err := s.Reader.Read(jwt.NewToken(token))
+	if err != nil || claims.ClientID == "" || !claims.HasScope(models.ScopeExchangeTokens) {
+		return "", ErrUntrusted
+	}
+	return claims.ClientID, nil
+}
+
+// Handoff trusts callers handing off a session they authenticated the user in. The handoff is a single use
+// assertion in the Session-Handoff header, signed by a registered client with the exchange scope, with the user
+// being exchanged as its user claim.
+type Handoff struct {
+	Authenticator clients.Authenticator
+}
+
+// Verify returns the client that signed the handoff if it is valid and is for the user.
+func (h Handoff) Verify(r *http.Request, user jwt.User) (string, error) {
+	handoff := r.Header.Get(HandoffHeader)
+	if handoff == "" {
+		return "", ErrUntrusted
+	}
+	c, claims, err := h.Authenticator.VerifyAssertion(handoff)
+	if err == clients.ErrInvalidClient {
+		return "", ErrUntrusted
+	}
+	if err != nil {
+		return "", err
+	}
+	if !c.HasScope(models.ScopeExchangeTokens) || claims.User.ID == "" || claims.User.ID != user.ID {
+		return "", ErrUntrusted
+	}
+	return c.ID, nil
+}
+
+// Certificate trusts callers that present a verified tls client certificate with one of the allowed names as its
+// common name or one of its dns names. The client is the allowed name, so it should be the id of a registered client
+// for the client to be able to revoke the tokens. The server must be configured to verify client certificates.
+type Certificate struct {
+	Allowed []string
+}
+
+// Verify returns the allowed name of the verified client certificate.
+func (c Certificate) Verify(r *http.Request, _ jwt.User) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", ErrUntrusted
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, allowed := range c.Allowed {
+		if cert.Subject.CommonName == allowed {
+			return allowed, nil
+		}
+		for _, name := range cert.DNSNames {
+			if name == allowed {
+				return allowed, nil
+			}
+		}
+	}
+	return "", ErrUntrusted
+}
@@ -0,0 +1,151 @@
+package trust_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/darren-west/app/auth-service/clients"
+	"github.com/darren-west/app/auth-service/revocation"
+	"github.com/darren-west/app/auth-service/trust"
+	"github.com/darren-west/app/utils/fileutil"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const audience = "http://auth-service/token"
+
+// verifierFunc is a function used as a trust.Verifier.
+type verifierFunc func(r *http.Request, user jwt.User) (string, error)
+
+func (f verifierFunc) Verify(r *http.Request, user jwt.User) (string, error) {
+	return f(r, user)
+}
+
+func TestAny(t *testing.T) {
+	untrusted := verifierFunc(func(*http.Request, jwt.User) (string, error) { return "", trust.ErrUntrusted })
+	trusted := verifierFunc(func(*http.Request, jwt.User) (string, error) { return "oauth-service", nil })
+	failed := verifierFunc(func(*http.Request, jwt.User) (string, error) { return "", errors.New("boom") })
+	r := httptest.NewRequest(http.MethodPost, "/token", nil)
+
+	_, err := trust.Any{}.Verify(r, jwt.User{})
+	assert.Equal(t, trust.ErrUntrusted, err)
+	_, err = trust.Any{untrusted}.Verify(r, jwt.User{})
+	assert.Equal(t, trust.ErrUntrusted, err)
+	client, err := trust.Any{untrusted, trusted}.Verify(r, jwt.User{})
+	assert.NoError(t, err)
+	assert.Equal(t, "oauth-service", client)
+	_, err = trust.Any{failed, trusted}.Verify(r, jwt.User{})
+	assert.EqualError(t, err, "boom")
+}
+
+func TestServiceClient(t *testing.T) {
+	keys := keySet(t)
+	writer := jwt.NewWriter(jwt.WriterBuilder.WithKeySet(keys))
+	verifier := trust.ServiceClient{Reader: jwt.NewReader(jwt.ReaderBuilder.WithKeySource(keys))}
+
+	tests := map[string]struct {
+		claims  *jwt.Claims
+		trusted bool
+	}{
+		"exchange scope": {claims: &jwt.Claims{Subject: "oauth-service", ClientID: "oauth-service", Scope: "users:read tokens:exchange"}, trusted: true},
+		"missing scope":  {claims: &jwt.Claims{Subject: "oauth-service", ClientID: "oauth-service", Scope: "users:read"}},
+		"user token":     {claims: &jwt.Claims{Subject: "1234", Scope: "tokens:exchange"}},
+		"no token":       {},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/token", nil)
+			if test.claims != nil {
+				test.claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+				token, err := writer.Write(test.claims)
+				require.NoError(t, err)
+				r.Header.Set("Authorization", "Bearer "+token.String())
+			}
+			client, err := verifier.Verify(r, jwt.User{ID: "1234"})
+			if test.trusted {
+				assert.NoError(t, err)
+				assert.Equal(t, "oauth-service", client)
+				return
+			}
+			assert.Equal(t, trust.ErrUntrusted, err)
+		})
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	registered, err := clients.Read(fileutil.FileReader{}, "../clients/testdata/clients.json")
+	require.NoError(t, err)
+	registry, err := clients.NewRegistry(registered...)
+	require.NoError(t, err)
+	verifier := trust.Handoff{Authenticator: clients.NewAuthenticator(registry, audience, revocation.NewMemoryDenylist())}
+	writer := jwt.NewWriter(jwt.WriterBuilder.WithKeySet(keySet(t)))
+
+	handoff := func(user jwt.User) *http.Request {
+		token, err := writer.Write(&jwt.Claims{
+			User:      user,
+			Issuer:    "billing",
+			Subject:   "billing",
+			Audience:  jwt.Audience{audience},
+			ID:        uuid.New().String(),
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		})
+		require.NoError(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/token", nil)
+		r.Header.Set(trust.HandoffHeader, token.String())
+		return r
+	}
+
+	client, err := verifier.Verify(handoff(jwt.User{ID: "1234"}), jwt.User{ID: "1234"})
+	assert.NoError(t, err)
+	assert.Equal(t, "billing", client)
+	_, err = verifier.Verify(handoff(jwt.User{ID: "5678"}), jwt.User{ID: "1234"})
+	assert.Equal(t, trust.ErrUntrusted, err, "the handoff should be for the user")
+	_, err = verifier.Verify(handoff(jwt.User{}), jwt.User{ID: "1234"})
+	assert.Equal(t, trust.ErrUntrusted, err)
+	_, err = verifier.Verify(httptest.NewRequest(http.MethodPost, "/token", nil), jwt.User{ID: "1234"})
+	assert.Equal(t, trust.ErrUntrusted, err)
+
+	r := handoff(jwt.User{ID: "1234"})
+	_, err = verifier.Verify(r, jwt.User{ID: "1234"})
+	assert.NoError(t, err)
+	_, err = verifier.Verify(r, jwt.User{ID: "1234"})
+	assert.Equal(t, trust.ErrUntrusted, err, "the handoff should only be used once")
+}
+
+func TestCertificate(t *testing.T) {
+	verifier := trust.Certificate{Allowed: []string{"oauth-service", "oauth.example.com"}}
+	request := func(cert *x509.Certificate) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/token", nil)
+		if cert != nil {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		return r
+	}
+
+	client, err := verifier.Verify(request(&x509.Certificate{Subject: pkix.Name{CommonName: "oauth-service"}}), jwt.User{})
+	assert.NoError(t, err)
+	assert.Equal(t, "oauth-service", client)
+	client, err = verifier.Verify(request(&x509.Certificate{DNSNames: []string{"other", "oauth.example.com"}}), jwt.User{})
+	assert.NoError(t, err)
+	assert.Equal(t, "oauth.example.com", client)
+	_, err = verifier.Verify(request(&x509.Certificate{Subject: pkix.Name{CommonName: "other"}}), jwt.User{})
+	assert.Equal(t, trust.ErrUntrusted, err)
+	_, err = verifier.Verify(request(nil), jwt.User{})
+	assert.Equal(t, trust.ErrUntrusted, err)
+}
+
+func keySet(t *testing.T) *jwt.KeySet {
+	active, signingKeys, err := jwt.ReadKeySet(fileutil.FileReader{}, "../../utils/jwt/testdata/keys.json")
+	require.NoError(t, err)
+	keys, err := jwt.NewKeySet(active, signingKeys...)
+	require.NoError(t, err)
+	return keys
+}
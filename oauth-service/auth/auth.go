@@ -1,15 +1,20 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	nethttputil "net/http/httputil"
+	"strings"
+	"time"
 
+	"github.com/darren-west/app/auth-service/models"
 	"github.com/darren-west/app/oauth-service/config"
 	"github.com/darren-west/app/oauth-service/httputil"
+	"github.com/darren-west/app/oauth-service/oidc"
+	"github.com/darren-west/app/utils/jwt"
 	"github.com/darren-west/app/utils/session"
 	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
@@ -48,6 +53,44 @@ func WithLoginHandler(h LoginHandler) Option {
 	}
 }
 
+// WithSessionIndex sets the index of each users sessions. It is required for the admin route that
+// revokes all of a users sessions.
+func WithSessionIndex(index SessionIndex) Option {
+	return func(opts *Options) (err error) {
+		opts.SessionIndex = index
+		return
+	}
+}
+
+// WithAPITokens sets the client of the auth service the api tokens of users are refreshed with, and revoked with
+// when they log out. It is required by the token refresh route.
+func WithAPITokens(tokens APITokens) Option {
+	return func(opts *Options) (err error) {
+		opts.APITokens = tokens
+		return
+	}
+}
+
+//go:generate mockgen -destination ./mocks/mock_session_index.go -package mocks github.com/darren-west/app/oauth-service/auth SessionIndex
+
+// SessionIndex keeps track of which sessions belong to which user.
+type SessionIndex interface {
+	Add(ctx context.Context, userID, sessionID string) error
+	Remove(ctx context.Context, sessionID string) error
+	RevokeAll(ctx context.Context, userID string) (int, error)
+}
+
+//go:generate mockgen -destination ./mocks/mock_api_tokens.go -package mocks github.com/darren-west/app/oauth-service/auth APITokens
+
+// APITokens manages the api tokens users are given by the auth service when they log in. It is implemented by a
+// client of the auth service authenticated as the oauth service, the client the tokens were issued to.
+type APITokens interface {
+	// RefreshToken exchanges the refresh token for a new api token and refresh token.
+	RefreshToken(ctx context.Context, refreshToken string) (models.Tokens, error)
+	// Revoke revokes the access or refresh token.
+	Revoke(ctx context.Context, token string) error
+}
+
 // Options are the handlers options. It is a struct for holding
 // setable configuration.
 type (
@@ -55,6 +98,8 @@ type (
 		store        sessions.Store
 		Config       config.Options
 		LoginHandler LoginHandler
+		SessionIndex SessionIndex
+		APITokens    APITokens
 	}
 
 	// Option is used to set an option.
@@ -69,9 +114,35 @@ func NewHandler(opts ...Option) (h Handler, err error) {
 			return
 		}
 	}
+	h.options.Config.Providers = append([]config.Provider(nil), h.options.Config.Providers...)
 	h.mux = http.NewServeMux()
-	h.mux.HandleFunc(h.options.Config.LoginRoutePath, h.login)
-	h.mux.HandleFunc(h.options.Config.RedirectRoutePath, h.redirect)
+	for i, provider := range h.options.Config.Providers {
+		if provider.Type == config.OIDC {
+			if provider, err = h.discover(provider); err != nil {
+				return
+			}
+			h.options.Config.Providers[i] = provider
+		}
+		h.mux.HandleFunc(provider.LoginRoutePath, h.login(provider))
+		h.mux.HandleFunc(provider.RedirectRoutePath, h.redirect(provider))
+	}
+	if h.options.Config.Logout.RoutePath != "" {
+		h.mux.HandleFunc(h.options.Config.Logout.RoutePath, h.logout)
+	}
+	if h.options.Config.Token.RefreshRoutePath != "" {
+		if h.options.APITokens == nil {
+			err = errors.New("invalid option: api tokens are required by the token refresh route")
+			return
+		}
+		h.mux.HandleFunc(h.options.Config.Token.RefreshRoutePath, h.refreshToken)
+	}
+	if h.options.Config.Admin.Token != "" {
+		if h.options.SessionIndex == nil {
+			err = errors.New("invalid option: session index is required by the admin routes")
+			return
+		}
+		h.mux.HandleFunc(h.options.Config.Admin.SessionsRoutePath(), h.revokeSessions)
+	}
 	return
 }
 
@@ -80,28 +151,83 @@ func newDefaultHandler() (h Handler) {
 		options: &Options{
 			store: sessions.NewCookieStore([]byte("abcd")),
 		},
+		verifiers: make(map[string]oidc.Verifier),
 	}
 }
 
+// providerTimeout bounds each request made to an identity provider, so a slow provider can't hang startup, a reload
+// or a login.
+const providerTimeout = 10 * time.Second
+
+// providerClient is the http client requests to identity providers are made with.
+var providerClient = &http.Client{Timeout: providerTimeout}
+
+// discover looks up the OpenID Connect providers endpoints from its issuer and creates the
+// verifier for its id tokens.
+func (h Handler) discover(provider config.Provider) (config.Provider, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), providerTimeout)
+	defer cancel()
+	discovered, err := oidc.Discover(ctx, providerClient, provider.Issuer)
+	if err != nil {
+		return provider, fmt.Errorf("provider %s: %s", provider.Name, err)
+	}
+	oauthConfig := *provider.OAuth
+	oauthConfig.Endpoint = oauth2.Endpoint{
+		AuthURL:  discovered.AuthorizationEndpoint,
+		TokenURL: discovered.TokenEndpoint,
+	}
+	provider.OAuth = &oauthConfig
+	if provider.RevocationURL == "" {
+		provider.RevocationURL = discovered.RevocationEndpoint
+	}
+	if provider.EndSessionURL == "" {
+		provider.EndSessionURL = discovered.EndSessionEndpoint
+	}
+	h.verifiers[provider.Name] = oidc.NewVerifier(discovered.Issuer, oauthConfig.ClientID, jwt.NewRemoteKeySet(discovered.JWKSURI, jwt.RemoteKeySetBuilder.WithHTTPClient(providerClient)))
+	return provider, nil
+}
+
 // Handler is the type used to handle the login and Redirect http.Handles.
 // DO NOT instantiate without using NewHandler().
 type Handler struct {
-	options *Options
-	mux     *http.ServeMux
+	options   *Options
+	mux       *http.ServeMux
+	verifiers map[string]oidc.Verifier
 }
 
-// login this handles a users login with the OAuth2 config passed into the NewHandler function. It will redirect
+// login this handles a users login with the providers OAuth2 config. It will redirect
 // the user to the OAuth2 login and handle updating the session.
-func (h Handler) login(w http.ResponseWriter, r *http.Request) {
-	h.do(w, r, func(ext httpExtension, w http.ResponseWriter, r *http.Request) (httpErr *httputil.Error) {
-		stateValue := uuid.New().String()
-		ext.Session.Values["state"] = stateValue
-		if err := ext.Session.Save(r, w); err != nil {
-			return httputil.NewError(http.StatusInternalServerError, fmt.Errorf("unable to save session: %s", err))
-		}
-		http.Redirect(w, r, h.options.Config.OAuth.AuthCodeURL(stateValue), http.StatusFound)
-		return
-	})
+func (h Handler) login(provider config.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.do(w, r, func(ext httpExtension, w http.ResponseWriter, r *http.Request) (httpErr *httputil.Error) {
+			delete(ext.Session.Values, "return_to")
+			if returnTo := r.URL.Query().Get("return_to"); returnTo != "" {
+				if !h.options.Config.ReturnTo.IsAllowed(returnTo) {
+					return httputil.NewError(http.StatusBadRequest, errors.New("return to url is not allowed"))
+				}
+				ext.Session.Values["return_to"] = returnTo
+			}
+			stateValue := uuid.New().String()
+			ext.Session.Values["state"] = stateValue
+			ext.Session.Values["provider"] = provider.Name
+			verifier, err := newCodeVerifier()
+			if err != nil {
+				return httputil.NewError(http.StatusInternalServerError, fmt.Errorf("unable to create code verifier: %s", err))
+			}
+			ext.Session.Values["code_verifier"] = verifier
+			opts := codeChallengeOptions(verifier)
+			if _, ok := h.verifiers[provider.Name]; ok {
+				nonce := uuid.New().String()
+				ext.Session.Values["nonce"] = nonce
+				opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+			}
+			if err := ext.Session.Save(r, w); err != nil {
+				return httputil.NewError(http.StatusInternalServerError, fmt.Errorf("unable to save session: %s", err))
+			}
+			http.Redirect(w, r, provider.OAuth.AuthCodeURL(stateValue, opts...), http.StatusFound)
+			return
+		})
+	}
 }
 
 // ServeHTTP implements the http handler interface forwarding requests to the underlying handlers.
@@ -111,11 +237,13 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 type (
 	// UserInfo is a struct containing the authenticated user from the oauth server.
+	// Provider is the name of the provider that authenticated the user.
 	UserInfo struct {
 		ID        string
 		FirstName string
 		LastName  string
 		Email     string
+		Provider  string
 	}
 )
 
@@ -126,57 +254,181 @@ type LoginHandler interface {
 	Handle(UserInfo, http.ResponseWriter, *http.Request)
 }
 
-// loginRedirect handles the redirection call from the OAuth2 server. It will trigger the OnAuthenticated callback.
-func (h Handler) redirect(w http.ResponseWriter, r *http.Request) {
-	h.do(w, r, func(ext httpExtension, w http.ResponseWriter, r *http.Request) (httpErr *httputil.Error) {
-		if ext.Session.Values["state"] != r.URL.Query().Get("state") {
-			return httputil.NewError(http.StatusUnauthorized, errors.New("state token invalid"))
-		}
-		token, err := h.options.Config.OAuth.Exchange(oauth2.NoContext, r.URL.Query().Get("code"))
-		if err != nil {
-			return httputil.NewError(http.StatusInternalServerError, err)
-		}
-		client := h.options.Config.OAuth.Client(oauth2.NoContext, token)
-		resp, err := client.Get(h.options.Config.APIEndpoint)
-		if err != nil {
-			return httputil.NewError(http.StatusInternalServerError, err)
-		}
-		user, err := decodeUser(resp.Body, h.options.Config.UserMapping)
-		if err != nil {
-			return httputil.NewError(http.StatusInternalServerError, err)
+// redirect handles the redirection call from the providers OAuth2 server. It will trigger the login handler.
+func (h Handler) redirect(provider config.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.do(w, r, func(ext httpExtension, w http.ResponseWriter, r *http.Request) (httpErr *httputil.Error) {
+			if ext.Session.Values["state"] != r.URL.Query().Get("state") {
+				return httputil.NewError(http.StatusUnauthorized, errors.New("state token invalid"))
+			}
+			if ext.Session.Values["provider"] != provider.Name {
+				return httputil.NewError(http.StatusUnauthorized, errors.New("provider invalid"))
+			}
+			verifier, ok := ext.Session.Values["code_verifier"].(string)
+			if !ok {
+				return httputil.NewError(http.StatusUnauthorized, errors.New("code verifier missing"))
+			}
+			ctx := context.WithValue(r.Context(), oauth2.HTTPClient, providerClient)
+			token, err := provider.OAuth.Exchange(ctx, r.URL.Query().Get("code"), codeVerifierOption(verifier))
+			if err != nil {
+				return httputil.NewError(http.StatusInternalServerError, err)
+			}
+			var user UserInfo
+			if verifier, ok := h.verifiers[provider.Name]; ok {
+				nonce, _ := ext.Session.Values["nonce"].(string)
+				if user, httpErr = userFromIDToken(verifier, token, nonce, provider.UserMapping); httpErr != nil {
+					return
+				}
+			} else if user, err = userFromAPI(ctx, provider, token); err != nil {
+				return httputil.NewError(http.StatusInternalServerError, err)
+			}
+			// users are stored by their provider id, a user without one would share an account with every other.
+			if user.ID == "" {
+				return httputil.NewError(http.StatusInternalServerError, fmt.Errorf("provider %s returned a user without an id", provider.Name))
+			}
+			user.Provider = provider.Name
+			// the user is logged in to a new session, so a session id planted before login (session fixation) is
+			// never logged in.
+			if err = renewSession(h.options.store, r, ext.Session); err != nil {
+				return httputil.NewError(http.StatusInternalServerError, fmt.Errorf("unable to renew session: %s", err))
+			}
+			// kept in the session so the provider token can be revoked, and the provider session ended, on logout.
+			ext.Session.Values["provider_token"] = token.AccessToken
+			if idToken, ok := token.Extra("id_token").(string); ok {
+				ext.Session.Values["id_token"] = idToken
+			}
+			if h.options.LoginHandler != nil {
+				h.options.LoginHandler.Handle(user, w, r)
+			}
+			return
+		})
+	}
+}
+
+// sessionRenewer is implemented by stores that can move a session to a new id, see session.MongoStore.Renew.
+type sessionRenewer interface {
+	Renew(r *http.Request, s *sessions.Session) error
+}
+
+// renewSession moves the session to a new id when it is next saved.
+func renewSession(store sessions.Store, r *http.Request, s *sessions.Session) error {
+	if renewer, ok := store.(sessionRenewer); ok {
+		return renewer.Renew(r, s)
+	}
+	s.ID = ""
+	return nil
+}
+
+// userFromAPI fetches the user from the providers api endpoint using the access token. The request is made with the
+// transport of the http client in the context, the oauth2 client does not keep its timeout so the requests are
+// bounded by the provider timeout instead. The users email is read from the emails endpoint when the api endpoint
+// does not return it.
+func userFromAPI(ctx context.Context, provider config.Provider, token *oauth2.Token) (user UserInfo, err error) {
+	ctx, cancel := context.WithTimeout(ctx, providerTimeout)
+	defer cancel()
+	client := provider.OAuth.Client(ctx, token)
+	var data map[string]interface{}
+	if err = getJSON(ctx, client, provider.APIEndpoint, &data); err != nil {
+		return user, fmt.Errorf("provider %s user request failed: %s", provider.Name, err)
+	}
+	user = mapUser(data, provider.UserMapping)
+	if user.Email == "" && provider.EmailsEndpoint != "" {
+		if user.Email, err = primaryEmail(ctx, client, provider.EmailsEndpoint); err != nil {
+			return user, fmt.Errorf("provider %s emails request failed: %s", provider.Name, err)
 		}
-		if h.options.LoginHandler != nil {
-			h.options.LoginHandler.Handle(user, w, r)
+	}
+	return
+}
+
+// primaryEmail returns the primary verified address from the list of the users email addresses at the endpoint, it
+// is empty when there is none.
+func primaryEmail(ctx context.Context, client *http.Client, endpoint string) (string, error) {
+	var emails []struct {
+		Email    string
+		Primary  bool
+		Verified bool
+	}
+	if err := getJSON(ctx, client, endpoint, &emails); err != nil {
+		return "", err
+	}
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			return email.Email, nil
 		}
-		return
-	})
+	}
+	return "", nil
 }
 
-func mapUser(data map[string]interface{}, m config.UserMapping) (user UserInfo) {
-	if id, ok := data[m.ID].(string); ok {
-		user.ID = id
+// getJSON decodes the json response of a get request to the url into v, numbers are decoded as json.Number. Responses
+// that are not successful are an error.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
 	}
-	if name, ok := data[m.FirstName].(string); ok {
-		user.FirstName = name
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
 	}
-	if name, ok := data[m.LastName].(string); ok {
-		user.LastName = name
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// userFromIDToken verifies the id token returned alongside the access token and maps its claims to the user.
+func userFromIDToken(verifier oidc.Verifier, token *oauth2.Token, nonce string, mapping config.UserMapping) (user UserInfo, httpErr *httputil.Error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return user, httputil.NewError(http.StatusInternalServerError, errors.New("id token missing from token response"))
 	}
-	if email, ok := data[m.EmailAddress].(string); ok {
-		user.Email = email
+	claims, err := verifier.Verify(rawIDToken, nonce)
+	if err != nil {
+		return user, httputil.NewError(http.StatusUnauthorized, err)
 	}
-	return
+	return mapUser(claims, mapping), nil
 }
 
-func decodeUser(data io.Reader, mapping config.UserMapping) (user UserInfo, err error) {
-	var um map[string]interface{}
-	if err = json.NewDecoder(data).Decode(&um); err != nil {
-		return
+func mapUser(data map[string]interface{}, m config.UserMapping) (user UserInfo) {
+	user.ID = stringValue(data[m.ID])
+	user.FirstName = stringValue(data[m.FirstName])
+	user.LastName = stringValue(data[m.LastName])
+	user.Email = stringValue(data[m.EmailAddress])
+	if name := stringValue(data[m.Name]); name != "" && user.FirstName == "" && user.LastName == "" {
+		parts := strings.SplitN(name, " ", 2)
+		user.FirstName = parts[0]
+		if len(parts) > 1 {
+			user.LastName = parts[1]
+		}
+	}
+	// providers often don't return a full name, the login name stands in for the parts of the name that are missing.
+	if username := stringValue(data[m.Username]); username != "" {
+		if user.FirstName == "" {
+			user.FirstName = username
+		}
+		if user.LastName == "" {
+			user.LastName = username
+		}
 	}
-	user = mapUser(um, mapping)
 	return
 }
 
+// stringValue returns the value as a string. Numbers are supported as some providers
+// return numeric ids.
+func stringValue(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	default:
+		return ""
+	}
+}
+
 type httpExtension struct {
 	Session *sessions.Session
 	Logger  *logrus.Entry
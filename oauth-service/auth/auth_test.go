@@ -1,6 +1,8 @@
 package auth_test
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/http"
@@ -8,11 +10,14 @@ import (
 	"net/url"
 	"testing"
 
+	authmodels "github.com/darren-west/app/auth-service/models"
+	utilhttputil "github.com/darren-west/app/utils/httputil"
 	"github.com/darren-west/app/utils/session"
 
 	"github.com/darren-west/app/oauth-service/auth"
 	"github.com/darren-west/app/oauth-service/auth/mocks"
 	"github.com/darren-west/app/oauth-service/config"
+	"github.com/darren-west/app/oauth-service/oidc/oidctest"
 	"github.com/golang/mock/gomock"
 	"github.com/gorilla/sessions"
 	"github.com/stretchr/testify/assert"
@@ -37,6 +42,10 @@ type LoginSuite struct {
 
 	mockLoginHandler *mocks.MockLoginHandler
 
+	mockSessionIndex *mocks.MockSessionIndex
+
+	mockAPITokens *mocks.MockAPITokens
+
 	OAuthConfig *oauth2.Config
 
 	server *httptest.Server
@@ -49,10 +58,29 @@ func (ls *LoginSuite) SetupTest() {
 	controller := gomock.NewController(ls.T())
 	ls.mockStore = mocks.NewMockStore(controller)
 	ls.mockLoginHandler = mocks.NewMockLoginHandler(controller)
+	ls.mockSessionIndex = mocks.NewMockSessionIndex(controller)
+	ls.mockAPITokens = mocks.NewMockAPITokens(controller)
 
 	ls.server = ls.setupEndpoint()
 
 	ls.Options = config.Options{
+		Providers:   []config.Provider{ls.provider("mock", "/login", "/redirect")},
+		BindAddress: ":80",
+	}
+
+	ls.handler, err = auth.NewHandler(
+		auth.WithSessionStore(ls.mockStore),
+		auth.WithConfig(ls.Options),
+		auth.WithLoginHandler(ls.mockLoginHandler),
+	)
+	ls.Require().NoError(err)
+
+}
+
+func (ls *LoginSuite) provider(name, loginPath, redirectPath string) config.Provider {
+	return config.Provider{
+		Name: name,
+		Type: config.Generic,
 		OAuth: &oauth2.Config{
 			ClientID:     "some client id",
 			ClientSecret: "some secret id",
@@ -71,19 +99,10 @@ func (ls *LoginSuite) SetupTest() {
 			LastName:     "LastName",
 			EmailAddress: "Email",
 		},
-		APIEndpoint:       fmt.Sprintf("%s/user/mock", ls.server.URL),
-		LoginRoutePath:    "/login",
-		RedirectRoutePath: "/redirect",
-		BindAddress:       ":80",
+		APIEndpoint:       fmt.Sprintf("%s/user/%s", ls.server.URL, name),
+		LoginRoutePath:    loginPath,
+		RedirectRoutePath: redirectPath,
 	}
-
-	ls.handler, err = auth.NewHandler(
-		auth.WithSessionStore(ls.mockStore),
-		auth.WithConfig(ls.Options),
-		auth.WithLoginHandler(ls.mockLoginHandler),
-	)
-	ls.Require().NoError(err)
-
 }
 
 func (ls *LoginSuite) TeardownTest() {
@@ -98,14 +117,33 @@ func (ls *LoginSuite) TestLogin_Success() {
 
 	ls.handler.ServeHTTP(recorder, request)
 
-	ls.Assert().Len(sess.Values, 1)
+	ls.Assert().Len(sess.Values, 3)
 	ls.Assert().NotEmpty(sess.Values["state"])
+	ls.Assert().Equal("mock", sess.Values["provider"])
+	ls.Assert().NotEmpty(sess.Values["code_verifier"])
 
 	redirected := recorder.Result().Header.Get("Location") // this is where redirected http request urls are put.
 	url, err := url.Parse(redirected)
 	ls.Require().NoError(err)
 	ls.Assert().Equal("http://127.0.0.1:8080/auth", url.Query().Get("redirect_uri"))
 	ls.Assert().Equal("some client id", url.Query().Get("client_id"))
+	ls.Assert().Equal("S256", url.Query().Get("code_challenge_method"))
+	sum := sha256.Sum256([]byte(sess.Values["code_verifier"].(string)))
+	ls.Assert().Equal(base64.RawURLEncoding.EncodeToString(sum[:]), url.Query().Get("code_challenge"))
+}
+
+func (ls *LoginSuite) TestLogin_RedirectMissingCodeVerifier() {
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redirect?state=foo&code=blah", nil)
+
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	sess.Values["state"] = "foo"
+	sess.Values["provider"] = "mock"
+
+	ls.handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal("code verifier missing\n", recorder.Body.String())
+	ls.Assert().Equal(http.StatusUnauthorized, recorder.Code)
 }
 
 func (ls *LoginSuite) TestLogin_SessionError() {
@@ -148,14 +186,146 @@ func (ls *LoginSuite) TestLogin_RedirectSuccess() {
 	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redirect?state=foo&code=blah", nil)
 
 	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	sess.ID = "planted"
 	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
-	ls.mockLoginHandler.EXPECT().Handle(gomock.Any(), recorder, request).Return()
+	ls.mockLoginHandler.EXPECT().Handle(auth.UserInfo{
+		ID:        "1",
+		FirstName: "foo",
+		LastName:  "bar",
+		Email:     "email@email.co.uk",
+		Provider:  "mock",
+	}, recorder, request).Return()
 	sess.Values["state"] = "foo"
+	sess.Values["provider"] = "mock"
+	sess.Values["code_verifier"] = "verifier"
 
 	ls.handler.ServeHTTP(recorder, request)
 
 	ls.Assert().Equal(http.StatusOK, recorder.Code)
 	ls.Assert().Equal("", recorder.Body.String())
+	ls.Assert().Empty(sess.ID, "the session should get a new id on login")
+}
+
+func (ls *LoginSuite) TestLogin_RedirectWrongProvider() {
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redirect?state=foo&code=blah", nil)
+
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	sess.Values["state"] = "foo"
+	sess.Values["provider"] = "other"
+
+	ls.handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal("provider invalid\n", recorder.Body.String())
+	ls.Assert().Equal(http.StatusUnauthorized, recorder.Code)
+}
+
+func (ls *LoginSuite) TestLogin_MultipleProviders() {
+	provider := ls.provider("numeric", "/numeric/login", "/numeric/redirect")
+	provider.UserMapping = config.UserMapping{ID: "ID", Name: "Name", EmailAddress: "Email"}
+	ls.Options.Providers = append(ls.Options.Providers, provider)
+	handler, err := auth.NewHandler(
+		auth.WithSessionStore(ls.mockStore),
+		auth.WithConfig(ls.Options),
+		auth.WithLoginHandler(ls.mockLoginHandler),
+	)
+	ls.Require().NoError(err)
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/numeric/redirect?state=foo&code=blah", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockLoginHandler.EXPECT().Handle(auth.UserInfo{
+		ID:        "42",
+		FirstName: "foo",
+		LastName:  "bar baz",
+		Email:     "email@email.co.uk",
+		Provider:  "numeric",
+	}, recorder, request).Return()
+	sess.Values["state"] = "foo"
+	sess.Values["provider"] = "numeric"
+	sess.Values["code_verifier"] = "verifier"
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusOK, recorder.Code)
+}
+
+func (ls *LoginSuite) TestLogin_GitHubPrivateEmail() {
+	provider := ls.provider("github", "/github/login", "/github/redirect")
+	provider.Type = config.GitHub
+	provider.UserMapping = config.UserMapping{}
+	provider.EmailsEndpoint = fmt.Sprintf("%s/user/github/emails", ls.server.URL)
+	ls.Options.Providers = append(ls.Options.Providers, provider.WithDefaults())
+	handler, err := auth.NewHandler(
+		auth.WithSessionStore(ls.mockStore),
+		auth.WithConfig(ls.Options),
+		auth.WithLoginHandler(ls.mockLoginHandler),
+	)
+	ls.Require().NoError(err)
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/github/redirect?state=foo&code=blah", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockLoginHandler.EXPECT().Handle(auth.UserInfo{
+		ID:        "583231",
+		FirstName: "Octo",
+		LastName:  "octocat",
+		Email:     "octocat@github.com",
+		Provider:  "github",
+	}, recorder, request).Return()
+	sess.Values["state"] = "foo"
+	sess.Values["provider"] = "github"
+	sess.Values["code_verifier"] = "verifier"
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusOK, recorder.Code)
+}
+
+func (ls *LoginSuite) TestLogin_RedirectMissingUserID() {
+	provider := ls.provider("noid", "/noid/login", "/noid/redirect")
+	ls.Options.Providers = append(ls.Options.Providers, provider)
+	handler, err := auth.NewHandler(
+		auth.WithSessionStore(ls.mockStore),
+		auth.WithConfig(ls.Options),
+		auth.WithLoginHandler(ls.mockLoginHandler),
+	)
+	ls.Require().NoError(err)
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/noid/redirect?state=foo&code=blah", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	sess.Values["state"] = "foo"
+	sess.Values["provider"] = "noid"
+	sess.Values["code_verifier"] = "verifier"
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusInternalServerError, recorder.Code)
+	ls.Assert().Equal("provider noid returned a user without an id\n", recorder.Body.String())
+}
+
+func (ls *LoginSuite) TestLogin_RedirectUserRequestFailed() {
+	provider := ls.provider("broken", "/broken/login", "/broken/redirect")
+	ls.Options.Providers = append(ls.Options.Providers, provider)
+	handler, err := auth.NewHandler(
+		auth.WithSessionStore(ls.mockStore),
+		auth.WithConfig(ls.Options),
+		auth.WithLoginHandler(ls.mockLoginHandler),
+	)
+	ls.Require().NoError(err)
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/broken/redirect?state=foo&code=blah", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	sess.Values["state"] = "foo"
+	sess.Values["provider"] = "broken"
+	sess.Values["code_verifier"] = "verifier"
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusInternalServerError, recorder.Code)
+	ls.Assert().Equal("provider broken user request failed: status 401\n", recorder.Body.String())
 }
 
 func (ls *LoginSuite) TestLogin_RedirectExchangeError() {
@@ -164,6 +334,8 @@ func (ls *LoginSuite) TestLogin_RedirectExchangeError() {
 	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
 	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
 	sess.Values["state"] = "foo"
+	sess.Values["provider"] = "mock"
+	sess.Values["code_verifier"] = "verifier"
 	ls.server.Close()
 	ls.handler.ServeHTTP(recorder, request)
 
@@ -173,11 +345,400 @@ func (ls *LoginSuite) TestLogin_RedirectExchangeError() {
 func (ls LoginSuite) setupEndpoint() *httptest.Server {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/o/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code_verifier") != "verifier" {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
 		fmt.Fprintf(w, "access_token=%s", "foo")
 	})
 
 	mux.HandleFunc("/user/mock", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, `{"ID":"1","FirstName":"foo","LastName":"bar","Email":"email@email.co.uk"}`)
 	})
+	mux.HandleFunc("/user/numeric", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ID":42,"Name":"foo bar baz","Email":"email@email.co.uk"}`)
+	})
+	mux.HandleFunc("/user/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"ID":"error","Message":"bad credentials"}`)
+	})
+	mux.HandleFunc("/user/github", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":583231,"login":"octocat","name":"Octo","email":null}`)
+	})
+	mux.HandleFunc("/user/github/emails", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"email":"octo@users.noreply.github.com","primary":false,"verified":true},{"email":"octocat@github.com","primary":true,"verified":true}]`)
+	})
+	mux.HandleFunc("/user/noid", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"FirstName":"foo","LastName":"bar","Email":"email@email.co.uk"}`)
+	})
 	return httptest.NewServer(mux)
 }
+
+func (ls *LoginSuite) oidcHandler(idp *oidctest.Provider) auth.Handler {
+	ls.Options.Providers = []config.Provider{
+		config.Provider{
+			Name:              "oidc",
+			Type:              config.OIDC,
+			Issuer:            idp.URL,
+			LoginRoutePath:    "/oidc/login",
+			RedirectRoutePath: "/oidc/redirect",
+			OAuth: &oauth2.Config{
+				ClientID:    "client",
+				RedirectURL: "http://127.0.0.1:8080/oidc/redirect",
+			},
+			UserMapping: config.UserMapping{ID: "sub", FirstName: "given_name", LastName: "family_name", EmailAddress: "email"},
+		},
+	}
+	handler, err := auth.NewHandler(
+		auth.WithSessionStore(ls.mockStore),
+		auth.WithConfig(ls.Options),
+		auth.WithLoginHandler(ls.mockLoginHandler),
+	)
+	ls.Require().NoError(err)
+	return handler
+}
+
+func (ls *LoginSuite) TestLogin_OIDCLogin() {
+	idp := oidctest.NewProvider("client")
+	defer idp.Close()
+	handler := ls.oidcHandler(idp)
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/oidc/login", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockStore.EXPECT().Save(request, recorder, sess).Return(nil)
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().NotEmpty(sess.Values["nonce"])
+	url, err := url.Parse(recorder.Result().Header.Get("Location"))
+	ls.Require().NoError(err)
+	ls.Assert().Equal(idp.URL+"/authorize", fmt.Sprintf("%s://%s%s", url.Scheme, url.Host, url.Path))
+	ls.Assert().Equal(sess.Values["nonce"], url.Query().Get("nonce"))
+}
+
+func (ls *LoginSuite) TestLogin_OIDCRedirect() {
+	idp := oidctest.NewProvider("client")
+	defer idp.Close()
+	handler := ls.oidcHandler(idp)
+	idp.Claims = map[string]interface{}{
+		"sub":         "1234",
+		"given_name":  "foo",
+		"family_name": "bar",
+		"email":       "email@email.co.uk",
+		"nonce":       "abc",
+	}
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/oidc/redirect?state=foo&code=blah", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockLoginHandler.EXPECT().Handle(auth.UserInfo{
+		ID:        "1234",
+		FirstName: "foo",
+		LastName:  "bar",
+		Email:     "email@email.co.uk",
+		Provider:  "oidc",
+	}, recorder, request).Return()
+	sess.Values["state"] = "foo"
+	sess.Values["provider"] = "oidc"
+	sess.Values["code_verifier"] = "verifier"
+	sess.Values["nonce"] = "abc"
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusOK, recorder.Code)
+}
+
+func (ls *LoginSuite) TestLogin_OIDCRedirectInvalidNonce() {
+	idp := oidctest.NewProvider("client")
+	defer idp.Close()
+	handler := ls.oidcHandler(idp)
+	idp.Claims = map[string]interface{}{"sub": "1234", "nonce": "other"}
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/oidc/redirect?state=foo&code=blah", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	sess.Values["state"] = "foo"
+	sess.Values["provider"] = "oidc"
+	sess.Values["code_verifier"] = "verifier"
+	sess.Values["nonce"] = "abc"
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusUnauthorized, recorder.Code)
+	ls.Assert().Equal("invalid id token: nonce does not match\n", recorder.Body.String())
+}
+
+func (ls *LoginSuite) TestLogin_ReturnTo() {
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/login?return_to=%2Fapp%2Fusers", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockStore.EXPECT().Save(request, recorder, sess).Return(nil)
+
+	ls.handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusFound, recorder.Code)
+	ls.Assert().Equal("/app/users", sess.Values["return_to"])
+}
+
+func (ls *LoginSuite) TestLogin_ReturnToNotAllowed() {
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/login?return_to=https%3A%2F%2Fevil.com", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+
+	ls.handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusBadRequest, recorder.Code)
+	ls.Assert().Equal("return to url is not allowed\n", recorder.Body.String())
+}
+
+func (ls *LoginSuite) logoutHandler(provider config.Provider) auth.Handler {
+	ls.Options.Providers = []config.Provider{provider}
+	ls.Options.Logout = config.LogoutOptions{RoutePath: "/logout", RedirectURL: "http://127.0.0.1:8080/bye"}
+	ls.Options.Admin = config.AdminOptions{Token: "secret"}
+	ls.Options.Token = config.TokenOptions{CookieName: "api-token"}
+	handler, err := auth.NewHandler(
+		auth.WithSessionStore(ls.mockStore),
+		auth.WithConfig(ls.Options),
+		auth.WithLoginHandler(ls.mockLoginHandler),
+		auth.WithSessionIndex(ls.mockSessionIndex),
+		auth.WithAPITokens(ls.mockAPITokens),
+	)
+	ls.Require().NoError(err)
+	return handler
+}
+
+func (ls *LoginSuite) TestLogout() {
+	var revoked string
+	revocation := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revoked = r.FormValue("token")
+	}))
+	defer revocation.Close()
+	provider := ls.provider("mock", "/login", "/redirect")
+	provider.RevocationURL = revocation.URL
+	handler := ls.logoutHandler(provider)
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/logout", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	sess.ID = "abc"
+	sess.Values["provider"] = "mock"
+	sess.Values["provider_token"] = "token"
+	sess.Values["api-token"] = "api"
+	sess.Values["refresh-token"] = "refresh"
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockStore.EXPECT().Save(request, recorder, sess).Return(nil)
+	ls.mockSessionIndex.EXPECT().Remove(gomock.Any(), "abc").Return(nil)
+	ls.mockAPITokens.EXPECT().Revoke(gomock.Any(), "refresh").Return(nil)
+	ls.mockAPITokens.EXPECT().Revoke(gomock.Any(), "api").Return(nil)
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusFound, recorder.Code)
+	ls.Assert().Equal("http://127.0.0.1:8080/bye", recorder.Header().Get("Location"))
+	ls.Assert().Equal(-1, sess.Options.MaxAge)
+	ls.Assert().Equal("token", revoked)
+	cookies := recorder.Result().Cookies()
+	ls.Require().Len(cookies, 1)
+	ls.Assert().Equal("api-token", cookies[0].Name)
+	ls.Assert().Empty(cookies[0].Value)
+	ls.Assert().True(cookies[0].MaxAge < 0, "the api token cookie should be deleted")
+}
+
+func (ls *LoginSuite) TestLogoutRevokeFailed() {
+	handler := ls.logoutHandler(ls.provider("mock", "/login", "/redirect"))
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/logout", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	sess.Values["refresh-token"] = "refresh"
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockStore.EXPECT().Save(request, recorder, sess).Return(nil)
+	ls.mockAPITokens.EXPECT().Revoke(gomock.Any(), "refresh").Return(errors.New("boom"))
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusFound, recorder.Code, "the user should be logged out when the tokens can't be revoked")
+	ls.Assert().Equal("http://127.0.0.1:8080/bye", recorder.Header().Get("Location"))
+}
+
+func (ls *LoginSuite) TestLogoutMethodNotAllowed() {
+	handler := ls.logoutHandler(ls.provider("mock", "/login", "/redirect"))
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/logout", nil)
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusMethodNotAllowed, recorder.Code)
+	ls.Assert().Equal("method GET not allowed\n", recorder.Body.String())
+}
+
+func (ls *LoginSuite) TestLogoutEndSession() {
+	provider := ls.provider("mock", "/login", "/redirect")
+	provider.EndSessionURL = "https://idp.com/logout"
+	handler := ls.logoutHandler(provider)
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/logout", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	sess.Values["provider"] = "mock"
+	sess.Values["id_token"] = "id"
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockStore.EXPECT().Save(request, recorder, sess).Return(nil)
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusFound, recorder.Code)
+	ls.Assert().Equal("https://idp.com/logout?id_token_hint=id&post_logout_redirect_uri=http%3A%2F%2F127.0.0.1%3A8080%2Fbye", recorder.Header().Get("Location"))
+}
+
+func (ls *LoginSuite) refreshHandler() auth.Handler {
+	ls.Options.Token = config.TokenOptions{CookieName: "api-token", RefreshRoutePath: "/token/refresh"}
+	handler, err := auth.NewHandler(
+		auth.WithSessionStore(ls.mockStore),
+		auth.WithConfig(ls.Options),
+		auth.WithLoginHandler(ls.mockLoginHandler),
+		auth.WithAPITokens(ls.mockAPITokens),
+	)
+	ls.Require().NoError(err)
+	return handler
+}
+
+func (ls *LoginSuite) TestRefreshToken() {
+	handler := ls.refreshHandler()
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/token/refresh", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	sess.Values["api-token"] = "api"
+	sess.Values["refresh-token"] = "refresh"
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockAPITokens.EXPECT().RefreshToken(gomock.Any(), "refresh").Return(authmodels.Tokens{AccessToken: "next-api", RefreshToken: "next-refresh"}, nil)
+	ls.mockStore.EXPECT().Save(request, recorder, sess).Return(nil)
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusNoContent, recorder.Code)
+	ls.Assert().Equal("next-api", sess.Values["api-token"])
+	ls.Assert().Equal("next-refresh", sess.Values["refresh-token"])
+	cookies := recorder.Result().Cookies()
+	ls.Require().Len(cookies, 1)
+	ls.Assert().Equal("api-token", cookies[0].Name)
+	ls.Assert().Equal("next-api", cookies[0].Value)
+	ls.Assert().True(cookies[0].HttpOnly)
+}
+
+func (ls *LoginSuite) TestRefreshTokenRejected() {
+	handler := ls.refreshHandler()
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/token/refresh", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	sess.Values["refresh-token"] = "refresh"
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockAPITokens.EXPECT().RefreshToken(gomock.Any(), "refresh").Return(authmodels.Tokens{}, utilhttputil.NewError(http.StatusUnauthorized).WithMessage("refresh token reused"))
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusUnauthorized, recorder.Code)
+	ls.Assert().Equal("refresh token rejected: refresh token reused\n", recorder.Body.String())
+	ls.Assert().Empty(recorder.Result().Cookies())
+}
+
+func (ls *LoginSuite) TestRefreshTokenFailed() {
+	handler := ls.refreshHandler()
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/token/refresh", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	sess.Values["refresh-token"] = "refresh"
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockAPITokens.EXPECT().RefreshToken(gomock.Any(), "refresh").Return(authmodels.Tokens{}, errors.New("boom"))
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusBadGateway, recorder.Code)
+	ls.Assert().Equal("unable to refresh api token: boom\n", recorder.Body.String())
+}
+
+func (ls *LoginSuite) TestRefreshTokenMissing() {
+	handler := ls.refreshHandler()
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/token/refresh", nil)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sessions.NewSession(ls.mockStore, session.UserSessionName), nil)
+
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusUnauthorized, recorder.Code)
+	ls.Assert().Equal("refresh token missing\n", recorder.Body.String())
+}
+
+func (ls *LoginSuite) TestRefreshTokenMethodNotAllowed() {
+	handler := ls.refreshHandler()
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/token/refresh", nil)
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func (ls *LoginSuite) TestRefreshTokenRequiresAPITokens() {
+	ls.Options.Token = config.TokenOptions{RefreshRoutePath: "/token/refresh"}
+	_, err := auth.NewHandler(auth.WithSessionStore(ls.mockStore), auth.WithConfig(ls.Options))
+	ls.Assert().EqualError(err, "invalid option: api tokens are required by the token refresh route")
+}
+
+func (ls *LoginSuite) TestRevokeSessions() {
+	handler := ls.logoutHandler(ls.provider("mock", "/login", "/redirect"))
+	ls.mockSessionIndex.EXPECT().RevokeAll(gomock.Any(), "1234").Return(2, nil)
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/admin/sessions/1234", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusOK, recorder.Code)
+	ls.Assert().Equal(`{"revoked":2}`, recorder.Body.String())
+}
+
+func (ls *LoginSuite) TestRevokeSessionsUnauthorized() {
+	handler := ls.logoutHandler(ls.provider("mock", "/login", "/redirect"))
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/admin/sessions/1234", nil)
+	request.Header.Set("Authorization", "Bearer wrong")
+	handler.ServeHTTP(recorder, request)
+
+	ls.Assert().Equal(http.StatusUnauthorized, recorder.Code)
+	ls.Assert().Equal("admin token invalid\n", recorder.Body.String())
+}
+
+func (ls *LoginSuite) TestReload_LoginStartedBeforeSwap() {
+	reloadable := auth.NewReloadable(ls.handler)
+
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/login", nil)
+	sess := sessions.NewSession(ls.mockStore, session.UserSessionName)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockStore.EXPECT().Save(request, recorder, sess).Return(nil)
+	reloadable.ServeHTTP(recorder, request)
+	ls.Require().Equal(http.StatusFound, recorder.Code)
+	sess.Values["code_verifier"] = "verifier" // the verifier the token endpoint accepts.
+
+	provider := ls.provider("mock", "/login", "/redirect")
+	provider.OAuth.ClientID = "new client id"
+	ls.Options.Providers = []config.Provider{provider}
+	handler, err := auth.NewHandler(
+		auth.WithSessionStore(ls.mockStore),
+		auth.WithConfig(ls.Options),
+		auth.WithLoginHandler(ls.mockLoginHandler),
+	)
+	ls.Require().NoError(err)
+	reloadable.Swap(handler)
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/redirect?state=%s&code=blah", sess.Values["state"]), nil)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockLoginHandler.EXPECT().Handle(gomock.Any(), recorder, request).Return()
+	reloadable.ServeHTTP(recorder, request)
+	ls.Assert().Equal(http.StatusOK, recorder.Code, "the login should be completed by the new handler")
+
+	recorder, request = httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/login", nil)
+	ls.mockStore.EXPECT().Get(request, session.UserSessionName).Return(sess, nil)
+	ls.mockStore.EXPECT().Save(request, recorder, sess).Return(nil)
+	reloadable.ServeHTTP(recorder, request)
+	redirected, err := url.Parse(recorder.Result().Header.Get("Location"))
+	ls.Require().NoError(err)
+	ls.Assert().Equal("new client id", redirected.Query().Get("client_id"))
+	ls.Assert().Equal("new client id", reloadable.Handler().Options().Config.Providers[0].OAuth.ClientID)
+}
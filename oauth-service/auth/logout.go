@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/darren-west/app/oauth-service/config"
+	"github.com/darren-west/app/oauth-service/httputil"
+	"github.com/gorilla/sessions"
+)
+
+// logout deletes the users session and clears the session and api token cookies. The api and refresh tokens are
+// revoked with the auth service, so copies of them can't be used after logging out. If the user logged in with a
+// provider that supports it, the providers token is revoked and the user is sent to end their session with the
+// provider.
+func (h Handler) logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httputil.NewError(http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)).Write(w)
+		return
+	}
+	h.do(w, r, func(ext httpExtension, w http.ResponseWriter, r *http.Request) (httpErr *httputil.Error) {
+		providerName, _ := ext.Session.Values["provider"].(string)
+		providerToken, _ := ext.Session.Values["provider_token"].(string)
+		idToken, _ := ext.Session.Values["id_token"].(string)
+		apiToken, _ := ext.Session.Values["api-token"].(string)
+		refreshToken, _ := ext.Session.Values["refresh-token"].(string)
+
+		if h.options.SessionIndex != nil && ext.Session.ID != "" {
+			if err := h.options.SessionIndex.Remove(r.Context(), ext.Session.ID); err != nil {
+				return httputil.NewError(http.StatusInternalServerError, fmt.Errorf("unable to remove session: %s", err))
+			}
+		}
+		if ext.Session.Options == nil {
+			ext.Session.Options = &sessions.Options{Path: "/"}
+		}
+		ext.Session.Options.MaxAge = -1
+		if err := ext.Session.Save(r, w); err != nil {
+			return httputil.NewError(http.StatusInternalServerError, fmt.Errorf("unable to delete session: %s", err))
+		}
+		if token := h.options.Config.Token; token.CookieName != "" {
+			cookie := token.Cookie("")
+			cookie.MaxAge = -1
+			http.SetCookie(w, cookie)
+		}
+		if h.options.APITokens != nil {
+			for _, token := range []string{refreshToken, apiToken} {
+				if token == "" {
+					continue
+				}
+				if err := h.options.APITokens.Revoke(r.Context(), token); err != nil {
+					ext.Logger.WithError(err).Warn("Failed to revoke api token.")
+				}
+			}
+		}
+
+		redirectURL := h.options.Config.Logout.Redirect()
+		if provider, ok := h.provider(providerName); ok {
+			if provider.RevocationURL != "" && providerToken != "" {
+				if err := revokeToken(provider, providerToken); err != nil {
+					ext.Logger.WithError(err).Warn("Failed to revoke provider token.")
+				}
+			}
+			if provider.EndSessionURL != "" {
+				redirectURL = endSessionURL(provider, idToken, redirectURL)
+			}
+		}
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+		return
+	})
+}
+
+// revokeSessions revokes every session of the user whose id is at the end of the path.
+func (h Handler) revokeSessions(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		httputil.NewError(http.StatusUnauthorized, errors.New("admin token invalid")).Write(w)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		httputil.NewError(http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)).Write(w)
+		return
+	}
+	userID := strings.TrimPrefix(r.URL.Path, h.options.Config.Admin.SessionsRoutePath())
+	if userID == "" {
+		httputil.NewError(http.StatusBadRequest, errors.New("user id missing")).Write(w)
+		return
+	}
+	revoked, err := h.options.SessionIndex.RevokeAll(r.Context(), userID)
+	if err != nil {
+		httputil.NewError(http.StatusInternalServerError, fmt.Errorf("unable to revoke sessions: %s", err)).Write(w)
+		return
+	}
+	fmt.Fprintf(w, `{"revoked":%d}`, revoked)
+}
+
+func (h Handler) isAdmin(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.options.Config.Admin.Token)) == 1
+}
+
+func (h Handler) provider(name string) (config.Provider, bool) {
+	for _, p := range h.options.Config.Providers {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return config.Provider{}, false
+}
+
+// revokeToken revokes the token with the providers revocation endpoint (RFC 7009).
+func revokeToken(provider config.Provider, token string) error {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest(http.MethodPost, provider.RevocationURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(provider.OAuth.ClientID), url.QueryEscape(provider.OAuth.ClientSecret))
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revocation failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// endSessionURL is the OpenID Connect RP-initiated logout url for the provider.
+func endSessionURL(provider config.Provider, idToken, redirectURL string) string {
+	query := url.Values{"post_logout_redirect_uri": {redirectURL}}
+	if idToken != "" {
+		query.Set("id_token_hint", idToken)
+	}
+	separator := "?"
+	if strings.Contains(provider.EndSessionURL, "?") {
+		separator = "&"
+	}
+	return provider.EndSessionURL + separator + query.Encode()
+}
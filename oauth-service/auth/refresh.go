@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	authclient "github.com/darren-west/app/auth-service/client"
+	"github.com/darren-west/app/oauth-service/httputil"
+)
+
+// refreshToken exchanges the refresh token in the users session for a new api token with the auth service. The new
+// tokens are stored in the session and the api token cookie is set again. Users without a refresh token, or whose
+// refresh token the auth service rejects, get 401 and have to log in again.
+func (h Handler) refreshToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httputil.NewError(http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)).Write(w)
+		return
+	}
+	h.do(w, r, func(ext httpExtension, w http.ResponseWriter, r *http.Request) (httpErr *httputil.Error) {
+		refreshToken, _ := ext.Session.Values["refresh-token"].(string)
+		if refreshToken == "" {
+			return httputil.NewError(http.StatusUnauthorized, errors.New("refresh token missing"))
+		}
+		tokens, err := h.options.APITokens.RefreshToken(r.Context(), refreshToken)
+		if authclient.StatusCode(err) == http.StatusUnauthorized {
+			return httputil.NewError(http.StatusUnauthorized, fmt.Errorf("refresh token rejected: %s", err))
+		}
+		if err != nil {
+			return httputil.NewError(http.StatusBadGateway, fmt.Errorf("unable to refresh api token: %s", err))
+		}
+		ext.Session.Values["api-token"] = tokens.AccessToken
+		ext.Session.Values["refresh-token"] = tokens.RefreshToken
+		if err = ext.Session.Save(r, w); err != nil {
+			return httputil.NewError(http.StatusInternalServerError, fmt.Errorf("unable to save session: %s", err))
+		}
+		if token := h.options.Config.Token; token.CookieName != "" {
+			http.SetCookie(w, token.Cookie(tokens.AccessToken))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	})
+}
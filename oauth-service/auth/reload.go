@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Reloadable serves requests with a handler that can be swapped for one built from new config without a restart.
+// Requests already being served finish with the handler they started with, and logins started before a swap are
+// completed by the new handler as the login state is kept in the session. Instantiate using NewReloadable.
+type Reloadable struct {
+	handler atomic.Value
+}
+
+// NewReloadable returns a reloadable handler serving the handler given until it is swapped.
+func NewReloadable(h Handler) *Reloadable {
+	r := &Reloadable{}
+	r.Swap(h)
+	return r
+}
+
+// Swap replaces the handler serving new requests.
+func (r *Reloadable) Swap(h Handler) {
+	r.handler.Store(h)
+}
+
+// Handler returns the handler serving new requests.
+func (r *Reloadable) Handler() Handler {
+	return r.handler.Load().(Handler)
+}
+
+// ServeHTTP implements the http handler interface forwarding requests to the current handler.
+func (r *Reloadable) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.Handler().ServeHTTP(w, req)
+}
@@ -1,16 +1,25 @@
 package config
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
 
+	"github.com/darren-west/app/utils/config"
 	"github.com/darren-west/app/utils/session"
 	"github.com/darren-west/app/utils/validator"
 
 	"golang.org/x/oauth2"
 )
 
+// EnvPrefix is the prefix of the environment variables that override the configuration. The session encryption
+// key is set by OAUTH_MONGO_SESSION_ENCRYPT_KEY, see config.Env for how variables are named. Providers can't be
+// overridden by environment variables, their secrets are interpolated into the file with ${VAR} instead.
+const EnvPrefix = "OAUTH"
+
 //go:generate mockgen -destination ./mocks/mock_reader.go -package mocks github.com/darren-west/app/oauth-service/config FileReader
 
 // FileReader reads a files content into a byte array.
@@ -21,20 +30,48 @@ type FileReader interface {
 // Reader is used to read configuration in from a file. Instantiate using the NewReader function.
 type Reader struct {
 	fileReader FileReader
+	env        config.Env
+	flags      *config.Flags
+}
+
+// WithFlags returns a copy of the reader that overrides the configuration with the flags that are set. The flags
+// are defined for the Options type, see config.Flags for how they are named.
+func (r Reader) WithFlags(flags *config.Flags) Reader {
+	r.flags = flags
+	return r
 }
 
-// Read reads the config in the file and returns the oauth2 config.
-func (r Reader) Read(path string) (config Options, err error) {
-	//config = defaultConfig
+// Read reads the config in the file and returns the oauth2 config. The file is json, or yaml when it has a .yaml
+// or .yml extension, and ${VAR} in the string values of the file is replaced with the environment variable VAR once
+// it is decoded.
+// The config in the file is overridden by environment variables, and then by the flags that are set.
+func (r Reader) Read(path string) (options Options, err error) {
 	data, err := r.fileReader.Read(path)
 	if err != nil {
 		err = fmt.Errorf("failed to read file: %s", err)
 		return
 	}
-	if err = json.Unmarshal(data, &config); err != nil {
+	return r.decode(path, data)
+}
+
+// decode decodes the contents of the file at the path, applies the overrides and validates the config.
+func (r Reader) decode(path string, data []byte) (options Options, err error) {
+	if err = r.env.Unmarshal(path, data, &options); err != nil {
+		err = fmt.Errorf("failed to read file: %s", err)
+		return
+	}
+	if err = r.env.Apply(&options); err != nil {
 		return
 	}
-	if err = validator.Default.IsValid(&config); err != nil {
+	if r.flags != nil {
+		if err = r.flags.Apply(&options); err != nil {
+			return
+		}
+	}
+	for i, p := range options.Providers {
+		options.Providers[i] = p.WithDefaults()
+	}
+	if err = validator.Default.IsValid(&options); err != nil {
 		err = fmt.Errorf("configuration invalid: %s", err)
 		return
 	}
@@ -46,44 +83,318 @@ func NewReader(fileReader FileReader) (Reader, error) {
 	if fileReader == nil {
 		return Reader{}, errors.New("file reader is nil")
 	}
-	return Reader{fileReader: fileReader}, nil
+	return Reader{fileReader: fileReader, env: config.Env{Prefix: EnvPrefix}}, nil
 }
 
 // Options is a struct containing the options for configuring the service.
 type Options struct {
-	BindAddress       string
-	LoginRoutePath    string
-	RedirectRoutePath string
-	OAuth             *oauth2.Config
-	MongoSession      session.Options
-	APIEndpoint       string
-	UserMapping       UserMapping
+	BindAddress  string `validate:"required"`
+	MongoSession session.Options
+	Providers    []Provider `validate:"required"`
+	ReturnTo     ReturnToOptions
+	Logout       LogoutOptions
+	Admin        AdminOptions
+	Token        TokenOptions
+	Provision    ProvisionOptions
 }
 
 func (o Options) IsValid() (err error) {
-	if o.BindAddress == "" {
-		return errors.New("required field bind address missing")
+	names, routes := make(map[string]bool), map[string]bool{o.Logout.RoutePath: o.Logout.RoutePath != ""}
+	if o.Token.RefreshRoutePath != "" {
+		if routes[o.Token.RefreshRoutePath] {
+			return fmt.Errorf("token refresh route path %s is already in use", o.Token.RefreshRoutePath)
+		}
+		routes[o.Token.RefreshRoutePath] = true
+	}
+	for _, p := range o.Providers {
+		if err = p.IsValid(); err != nil {
+			return
+		}
+		if names[p.Name] {
+			return fmt.Errorf("provider %s is defined more than once", p.Name)
+		}
+		names[p.Name] = true
+		for _, route := range []string{p.LoginRoutePath, p.RedirectRoutePath} {
+			if routes[route] {
+				return fmt.Errorf("provider %s route path %s is already in use", p.Name, route)
+			}
+			routes[route] = true
+		}
+	}
+	return
+}
+
+// TokenOptions configure how the api token is obtained from the auth service after login and given to the user.
+// The token is always stored in the session, it is also set as an http only cookie when the cookie name is set.
+// The auth service only exchanges users for trusted callers, the client id and secret are the credentials of the
+// service client the exchange is made as.
+// The refresh route exchanges the refresh token in the users session for a new api token, so users stay logged in
+// after the api token expires. It is not registered when the refresh route path is empty.
+type TokenOptions struct {
+	AuthServiceAddress string `validate:"url"`
+	ClientID           string
+	ClientSecret       string
+	CookieName         string
+	CookieDomain       string
+	CookieSecure       bool
+	RefreshRoutePath   string
+}
+
+// Cookie returns the http only cookie the api token is set in. The cookie is deleted by setting it with a negative
+// max age.
+func (o TokenOptions) Cookie(token string) *http.Cookie {
+	return &http.Cookie{
+		Name:     o.CookieName,
+		Value:    token,
+		Path:     "/",
+		Domain:   o.CookieDomain,
+		Secure:   o.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// ProvisionOptions configure how users are created and kept up to date in the user service when they log in.
+type ProvisionOptions struct {
+	UserServiceAddress string `validate:"url"`
+}
+
+// LogoutOptions configure the logout route. The route is not registered when the route path is empty. Only POST
+// requests log the user out, so another site can't log users out with a link or an image.
+// When the user logged in with a provider that has an end session url they are sent there, with the
+// redirect url as the post logout redirect, instead of straight to the redirect url.
+type LogoutOptions struct {
+	RoutePath   string
+	RedirectURL string
+}
+
+// Redirect returns the url to send users to after logging out.
+func (o LogoutOptions) Redirect() string {
+	if o.RedirectURL == "" {
+		return "/"
+	}
+	return o.RedirectURL
+}
+
+// AdminOptions configure the admin routes, which are authenticated with a bearer token.
+// The routes are not registered when the token is empty.
+type AdminOptions struct {
+	RoutePath string
+	Token     string
+}
+
+// SessionsRoutePath returns the route path for revoking a users sessions. The user id is appended to the path.
+func (o AdminOptions) SessionsRoutePath() string {
+	if o.RoutePath == "" {
+		return "/admin/sessions/"
+	}
+	return strings.TrimSuffix(o.RoutePath, "/") + "/sessions/"
+}
+
+// ReturnToOptions configure where a user can be sent back to after logging in. The login routes accept
+// a return_to query parameter which must be a relative path, or an absolute url on one of the allowed hosts.
+// When path prefixes are set the path must also be one of them, or below one of them: the prefix /app allows /app
+// and /app/users but not /application.
+type ReturnToOptions struct {
+	DefaultURL          string
+	AllowedHosts        []string
+	AllowedPathPrefixes []string
+}
+
+// Default returns the url to send users to when no return to url is given.
+func (o ReturnToOptions) Default() string {
+	if o.DefaultURL == "" {
+		return "app/"
+	}
+	return o.DefaultURL
+}
+
+// IsAllowed returns true if the url can be used as a return to url.
+func (o ReturnToOptions) IsAllowed(rawURL string) bool {
+	if strings.ContainsAny(rawURL, "\\\r\n") {
+		return false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User != nil || u.Opaque != "" {
+		return false
 	}
-	if o.LoginRoutePath == "" {
-		return errors.New("required field login route path missing")
+	if u.Scheme == "" && u.Host == "" {
+		if !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(rawURL, "//") {
+			return false
+		}
+	} else if !(u.Scheme == "https" || u.Scheme == "http") || !contains(o.AllowedHosts, u.Host) {
+		return false
 	}
-	if o.RedirectRoutePath == "" {
-		return errors.New("required field redirect route path missing")
+	if len(o.AllowedPathPrefixes) == 0 {
+		return true
+	}
+	p := path.Clean("/" + u.Path)
+	for _, prefix := range o.AllowedPathPrefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
 	}
-	if o.OAuth == nil {
-		return errors.New("required field oauth missing")
+	return false
+}
+
+// ProviderType is the kind of identity provider. It is used to fill in defaults for the provider.
+type ProviderType string
+
+const (
+	// Generic is a provider that has no defaults, everything must be set in the configuration.
+	Generic ProviderType = "generic"
+	// Google is the google oauth2 provider.
+	Google ProviderType = "google"
+	// GitHub is the github oauth2 provider.
+	GitHub ProviderType = "github"
+	// GitLab is the gitlab oauth2 provider.
+	GitLab ProviderType = "gitlab"
+	// OIDC is an OpenID Connect provider. The endpoints are discovered from the issuer and the user
+	// is read from the verified id token rather than an api endpoint.
+	OIDC ProviderType = "oidc"
+)
+
+// Provider are the options for a single identity provider.
+// Issuer is only used by OIDC providers. RevocationURL and EndSessionURL are used on logout to revoke
+// the providers token and end the users session with the provider, they are discovered for OIDC providers.
+// EmailsEndpoint lists the email addresses of the user, the primary verified address is used when the api endpoint
+// does not return the users email, as github does for users with a private email.
+type Provider struct {
+	Name              string `validate:"required"`
+	Type              ProviderType
+	Issuer            string         `validate:"url"`
+	LoginRoutePath    string         `validate:"required"`
+	RedirectRoutePath string         `validate:"required"`
+	OAuth             *oauth2.Config `validate:"required"`
+	APIEndpoint       string
+	EmailsEndpoint    string `validate:"url"`
+	UserMapping       UserMapping
+	RevocationURL     string `validate:"url"`
+	EndSessionURL     string `validate:"url"`
+}
+
+func (p Provider) IsValid() (err error) {
+	if _, ok := providerDefaults[p.Type]; !ok && p.Type != Generic {
+		return fmt.Errorf("provider %s has unknown type %s", p.Name, p.Type)
 	}
-	if o.APIEndpoint == "" {
-		return errors.New("required field api endpoint missing")
+	if p.Type == OIDC && p.Issuer == "" {
+		return fmt.Errorf("provider %s required field issuer missing", p.Name)
+	}
+	if p.Type != OIDC && p.APIEndpoint == "" {
+		return fmt.Errorf("provider %s required field api endpoint missing", p.Name)
+	}
+	if err = p.UserMapping.IsValid(); err != nil {
+		return fmt.Errorf("provider %s %s", p.Name, err)
 	}
 	return
 }
 
+// WithDefaults returns the provider with any unset fields filled in from the defaults for its type.
+// The login and redirect routes default to /{name}/login and /{name}/redirect.
+func (p Provider) WithDefaults() Provider {
+	if p.Type == "" {
+		p.Type = Generic
+	}
+	if p.LoginRoutePath == "" && p.Name != "" {
+		p.LoginRoutePath = fmt.Sprintf("/%s/login", p.Name)
+	}
+	if p.RedirectRoutePath == "" && p.Name != "" {
+		p.RedirectRoutePath = fmt.Sprintf("/%s/redirect", p.Name)
+	}
+	d, ok := providerDefaults[p.Type]
+	if !ok {
+		return p
+	}
+	if p.OAuth != nil {
+		if p.OAuth.Endpoint.AuthURL == "" {
+			p.OAuth.Endpoint.AuthURL = d.endpoint.AuthURL
+		}
+		if p.OAuth.Endpoint.TokenURL == "" {
+			p.OAuth.Endpoint.TokenURL = d.endpoint.TokenURL
+		}
+		if len(p.OAuth.Scopes) == 0 {
+			p.OAuth.Scopes = d.scopes
+		}
+	}
+	if p.APIEndpoint == "" {
+		p.APIEndpoint = d.apiEndpoint
+	}
+	if p.EmailsEndpoint == "" {
+		p.EmailsEndpoint = d.emailsEndpoint
+	}
+	if p.UserMapping == (UserMapping{}) {
+		p.UserMapping = d.userMapping
+	}
+	if p.RevocationURL == "" {
+		p.RevocationURL = d.revocationURL
+	}
+	return p
+}
+
+type defaults struct {
+	endpoint       oauth2.Endpoint
+	scopes         []string
+	apiEndpoint    string
+	emailsEndpoint string
+	userMapping    UserMapping
+	revocationURL  string
+}
+
+var providerDefaults = map[ProviderType]defaults{
+	Google: {
+		endpoint: oauth2.Endpoint{
+			AuthURL:  "https://accounts.google.com/o/oauth2/auth",
+			TokenURL: "https://accounts.google.com/o/oauth2/token",
+		},
+		scopes:        []string{"openid", "profile", "email"},
+		apiEndpoint:   "https://www.googleapis.com/oauth2/v3/userinfo",
+		userMapping:   UserMapping{ID: "sub", FirstName: "given_name", LastName: "family_name", EmailAddress: "email"},
+		revocationURL: "https://accounts.google.com/o/oauth2/revoke",
+	},
+	GitHub: {
+		endpoint: oauth2.Endpoint{
+			AuthURL:  "https://github.com/login/oauth/authorize",
+			TokenURL: "https://github.com/login/oauth/access_token",
+		},
+		scopes:         []string{"read:user", "user:email"},
+		apiEndpoint:    "https://api.github.com/user",
+		emailsEndpoint: "https://api.github.com/user/emails",
+		userMapping:    UserMapping{ID: "id", Name: "name", Username: "login", EmailAddress: "email"},
+	},
+	GitLab: {
+		endpoint: oauth2.Endpoint{
+			AuthURL:  "https://gitlab.com/oauth/authorize",
+			TokenURL: "https://gitlab.com/oauth/token",
+		},
+		scopes:      []string{"read_user"},
+		apiEndpoint: "https://gitlab.com/api/v4/user",
+		userMapping: UserMapping{ID: "id", Name: "name", Username: "username", EmailAddress: "email"},
+	},
+	OIDC: {
+		scopes:      []string{"openid", "profile", "email"},
+		userMapping: UserMapping{ID: "sub", FirstName: "given_name", LastName: "family_name", EmailAddress: "email"},
+	},
+}
+
 // UserMapping are the options for configuring the marshalling of the returned user.
+// Name can be used instead of FirstName and LastName for providers that only return a full name. Username is the
+// users login name, it is used for the first or last name when the provider does not return them.
 type UserMapping struct {
 	ID           string
 	FirstName    string
 	LastName     string
+	Name         string
+	Username     string
 	EmailAddress string
 }
 
@@ -91,10 +402,10 @@ func (um UserMapping) IsValid() (err error) {
 	if um.ID == "" {
 		return errors.New("required field user mapping id missing")
 	}
-	if um.FirstName == "" {
+	if um.FirstName == "" && um.Name == "" {
 		return errors.New("required field user mapping first name missing")
 	}
-	if um.LastName == "" {
+	if um.LastName == "" && um.Name == "" {
 		return errors.New("required field user mapping last name missing")
 	}
 	if um.EmailAddress == "" {
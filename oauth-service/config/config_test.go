@@ -2,10 +2,13 @@ package config_test
 
 import (
 	"errors"
+	"flag"
+	"os"
 	"testing"
 
 	"github.com/darren-west/app/oauth-service/config"
 	"github.com/darren-west/app/oauth-service/config/mocks"
+	utilconfig "github.com/darren-west/app/utils/config"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -14,31 +17,35 @@ import (
 func TestReader(t *testing.T) {
 	testData := `{
 		"BindAddress":":80",
-		"LoginRoutePath":"/Login",
-		"RedirectRoutePath":"/Redirect",
-		"APIEndpoint":"www.foo.com",
 		"MongoSession": {
 			"Name": "session",
 			"EncryptKey":"KEY",
 			"ConnectionString":"mongodb://database",
 			"DatabaseName":"db"
 		},
-		"OAuth":{
-			"ClientID":"foobar", 
-			"ClientSecret":"foo", 
-			"RedirectURL":"http://redirect.co.uk",
-			"Scopes":["scope","scope1"],
-			"Endpoint": { 
-				"AuthURL":"https://www.facebook.com/dialog/oauth",
-				"TokenURL":"https://graph.facebook.com/oauth/access_token"
+		"Providers": [{
+			"Name":"facebook",
+			"Type":"generic",
+			"LoginRoutePath":"/Login",
+			"RedirectRoutePath":"/Redirect",
+			"APIEndpoint":"www.foo.com",
+			"OAuth":{
+				"ClientID":"foobar", 
+				"ClientSecret":"foo", 
+				"RedirectURL":"http://redirect.co.uk",
+				"Scopes":["scope","scope1"],
+				"Endpoint": { 
+					"AuthURL":"https://www.facebook.com/dialog/oauth",
+					"TokenURL":"https://graph.facebook.com/oauth/access_token"
+				}
+			},
+			"userMapping": {
+				"ID": "sub",
+				"FirstName": "given_name",
+				"LastName": "family_name",
+				"EmailAddress": "email"
 			}
-		},
-		"userMapping": {
-			"ID": "sub",
-			"FirstName": "given_name",
-			"LastName": "family_name",
-			"EmailAddress": "email"
-		}
+		}]
 	}`
 	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
 	mockFileReader.EXPECT().Read("/foo/path.config").Return([]byte(testData), nil)
@@ -49,49 +56,56 @@ func TestReader(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Equal(t, ":80", conf.BindAddress)
-	assert.Equal(t, "/Login", conf.LoginRoutePath)
-	assert.Equal(t, "/Redirect", conf.RedirectRoutePath)
 
 	assert.Equal(t, "KEY", conf.MongoSession.EncryptKey)
 	assert.Equal(t, "mongodb://database", conf.MongoSession.ConnectionString)
 	assert.Equal(t, "db", conf.MongoSession.DatabaseName)
 
-	assert.Equal(t, "foobar", conf.OAuth.ClientID)
-	assert.Equal(t, "foo", conf.OAuth.ClientSecret)
-	assert.Equal(t, "http://redirect.co.uk", conf.OAuth.RedirectURL)
-	assert.Equal(t, []string{"scope", "scope1"}, conf.OAuth.Scopes)
-	assert.Equal(t, "https://www.facebook.com/dialog/oauth", conf.OAuth.Endpoint.AuthURL)
-	assert.Equal(t, "https://graph.facebook.com/oauth/access_token", conf.OAuth.Endpoint.TokenURL)
+	require.Len(t, conf.Providers, 1)
+	provider := conf.Providers[0]
+	assert.Equal(t, "facebook", provider.Name)
+	assert.Equal(t, config.Generic, provider.Type)
+	assert.Equal(t, "/Login", provider.LoginRoutePath)
+	assert.Equal(t, "/Redirect", provider.RedirectRoutePath)
+	assert.Equal(t, "foobar", provider.OAuth.ClientID)
+	assert.Equal(t, "foo", provider.OAuth.ClientSecret)
+	assert.Equal(t, "http://redirect.co.uk", provider.OAuth.RedirectURL)
+	assert.Equal(t, []string{"scope", "scope1"}, provider.OAuth.Scopes)
+	assert.Equal(t, "https://www.facebook.com/dialog/oauth", provider.OAuth.Endpoint.AuthURL)
+	assert.Equal(t, "https://graph.facebook.com/oauth/access_token", provider.OAuth.Endpoint.TokenURL)
 }
 
 func TestReaderCaseInsensitive(t *testing.T) {
 	testData := `{
 		"bindAddress":":80",
-		"loginRoutePath":"/Login",
-		"redirectRoutePath":"/Redirect",
-		"apiEndpoint":"www.foo.com",
 		"mongoSession": {
 			"name":"session",
 			"encryptKey":"KEY",
 			"connectionString":"mongodb://database",
 			"databaseName":"db"
 		},
-		"oAuth":{
-			"clientID":"foobar", 
-			"clientSecret":"foo", 
-			"redirectURL":"http://redirect.co.uk",
-			"scopes":["scope","scope1"],
-			"endpoint": { 
-				"authURL":"https://www.facebook.com/dialog/oauth",
-				"tokenURL":"https://graph.facebook.com/oauth/access_token"
+		"providers": [{
+			"name":"facebook",
+			"loginRoutePath":"/Login",
+			"redirectRoutePath":"/Redirect",
+			"apiEndpoint":"www.foo.com",
+			"oAuth":{
+				"clientID":"foobar", 
+				"clientSecret":"foo", 
+				"redirectURL":"http://redirect.co.uk",
+				"scopes":["scope","scope1"],
+				"endpoint": { 
+					"authURL":"https://www.facebook.com/dialog/oauth",
+					"tokenURL":"https://graph.facebook.com/oauth/access_token"
+				}
+			},
+			"userMapping": {
+				"ID": "sub",
+				"FirstName": "given_name",
+				"LastName": "family_name",
+				"EmailAddress": "email"
 			}
-		},
-		"userMapping": {
-			"ID": "sub",
-			"FirstName": "given_name",
-			"LastName": "family_name",
-			"EmailAddress": "email"
-		}
+		}]
 	}`
 	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
 	mockFileReader.EXPECT().Read("/foo/path.config").Return([]byte(testData), nil)
@@ -102,19 +116,23 @@ func TestReaderCaseInsensitive(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Equal(t, ":80", conf.BindAddress)
-	assert.Equal(t, "/Login", conf.LoginRoutePath)
-	assert.Equal(t, "/Redirect", conf.RedirectRoutePath)
 
 	assert.Equal(t, "KEY", conf.MongoSession.EncryptKey)
 	assert.Equal(t, "mongodb://database", conf.MongoSession.ConnectionString)
 	assert.Equal(t, "db", conf.MongoSession.DatabaseName)
 
-	assert.Equal(t, "foobar", conf.OAuth.ClientID)
-	assert.Equal(t, "foo", conf.OAuth.ClientSecret)
-	assert.Equal(t, "http://redirect.co.uk", conf.OAuth.RedirectURL)
-	assert.Equal(t, []string{"scope", "scope1"}, conf.OAuth.Scopes)
-	assert.Equal(t, "https://www.facebook.com/dialog/oauth", conf.OAuth.Endpoint.AuthURL)
-	assert.Equal(t, "https://graph.facebook.com/oauth/access_token", conf.OAuth.Endpoint.TokenURL)
+	require.Len(t, conf.Providers, 1)
+	provider := conf.Providers[0]
+	assert.Equal(t, "facebook", provider.Name)
+	assert.Equal(t, config.Generic, provider.Type)
+	assert.Equal(t, "/Login", provider.LoginRoutePath)
+	assert.Equal(t, "/Redirect", provider.RedirectRoutePath)
+	assert.Equal(t, "foobar", provider.OAuth.ClientID)
+	assert.Equal(t, "foo", provider.OAuth.ClientSecret)
+	assert.Equal(t, "http://redirect.co.uk", provider.OAuth.RedirectURL)
+	assert.Equal(t, []string{"scope", "scope1"}, provider.OAuth.Scopes)
+	assert.Equal(t, "https://www.facebook.com/dialog/oauth", provider.OAuth.Endpoint.AuthURL)
+	assert.Equal(t, "https://graph.facebook.com/oauth/access_token", provider.OAuth.Endpoint.TokenURL)
 }
 
 func TestFileReaderError(t *testing.T) {
@@ -132,31 +150,138 @@ func TestFileReaderNil(t *testing.T) {
 	assert.EqualError(t, err, "file reader is nil")
 }
 
+func TestReaderYAML(t *testing.T) {
+	testData := `
+bindAddress: ":80"
+mongoSession:
+  encryptKey: KEY
+  connectionString: mongodb://database
+  databaseName: db
+  maxAge: 86400
+providers:
+- name: google
+  type: google
+  oAuth:
+    clientID: foo
+    clientSecret: bar
+    redirectURL: http://redirect.co.uk/google/redirect
+returnTo:
+  allowedHosts: [app.example.com]
+`
+	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
+	mockFileReader.EXPECT().Read("/foo/config.yaml").Return([]byte(testData), nil)
+	reader, err := config.NewReader(mockFileReader)
+	require.NoError(t, err)
+
+	conf, err := reader.Read("/foo/config.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, ":80", conf.BindAddress)
+	assert.Equal(t, "KEY", conf.MongoSession.EncryptKey)
+	assert.Equal(t, 86400, conf.MongoSession.MaxAge, "the max age is in seconds")
+	require.Len(t, conf.Providers, 1)
+	assert.Equal(t, "bar", conf.Providers[0].OAuth.ClientSecret)
+	assert.Equal(t, "/google/login", conf.Providers[0].LoginRoutePath)
+	assert.Equal(t, []string{"app.example.com"}, conf.ReturnTo.AllowedHosts)
+}
+
+const overrideTestData = `{
+	"bindAddress":":80",
+	"mongoSession": {
+		"encryptKey":"${TEST_ENCRYPT_KEY}",
+		"connectionString":"mongodb://database",
+		"databaseName":"db"
+	},
+	"providers": [{
+		"name":"google",
+		"type":"google",
+		"oAuth":{"clientID":"foo", "clientSecret":"${TEST_GOOGLE_SECRET}", "redirectURL":"http://redirect.co.uk/google/redirect"}
+	}]
+}`
+
+func TestReaderOverrides(t *testing.T) {
+	env := map[string]string{
+		"TEST_ENCRYPT_KEY":                  "KEY",
+		"TEST_GOOGLE_SECRET":                "s3cret",
+		"OAUTH_MONGO_SESSION_DATABASE_NAME": "sessions",
+		"OAUTH_TOKEN_CLIENT_SECRET":         "client-secret",
+		"OAUTH_BIND_ADDRESS":                ":8080",
+	}
+	for key, value := range env {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
+	mockFileReader.EXPECT().Read("/foo/path.config").Return([]byte(overrideTestData), nil)
+
+	fs := flag.NewFlagSet("oauth-service", flag.ContinueOnError)
+	flags, err := utilconfig.NewFlags(fs, &config.Options{})
+	require.NoError(t, err)
+	require.NoError(t, fs.Parse([]string{"--bind-address", ":9090", "--token-cookie-secure"}))
+
+	reader, err := config.NewReader(mockFileReader)
+	require.NoError(t, err)
+	conf, err := reader.WithFlags(flags).Read("/foo/path.config")
+	require.NoError(t, err)
+
+	assert.Equal(t, "KEY", conf.MongoSession.EncryptKey, "the key should be interpolated into the file")
+	assert.Equal(t, "s3cret", conf.Providers[0].OAuth.ClientSecret, "the secret should be interpolated into the file")
+	assert.Equal(t, "sessions", conf.MongoSession.DatabaseName, "the environment should override the file")
+	assert.Equal(t, "client-secret", conf.Token.ClientSecret)
+	assert.Equal(t, ":9090", conf.BindAddress, "the flags should override the environment")
+	assert.True(t, conf.Token.CookieSecure)
+}
+
+func TestReaderInterpolationMissing(t *testing.T) {
+	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
+	mockFileReader.EXPECT().Read("/foo/path.config").Return([]byte(overrideTestData), nil)
+	reader, err := config.NewReader(mockFileReader)
+	require.NoError(t, err)
+
+	_, err = reader.Read("/foo/path.config")
+	assert.EqualError(t, err, "failed to read file: environment variables not set: TEST_ENCRYPT_KEY, TEST_GOOGLE_SECRET")
+}
+
 func TestConfigValidation(t *testing.T) {
 	testData := `{
-		"loginRoutePath":"/Login",
-		"redirectRoutePath":"/Redirect",
 		"mongoSession": {
 			"encryptKey":"KEY",
 			"connectionString":"mongodb://database",
 			"databaseName":"db"
 		},
-		"oAuth":{
-			"clientID":"foobar", 
-			"clientSecret":"foo", 
-			"redirectURL":"http://redirect.co.uk",
-			"scopes":["scope","scope1"],
-			"endpoint": { 
-				"authURL":"https://www.facebook.com/dialog/oauth",
-				"tokenURL":"https://graph.facebook.com/oauth/access_token"
+		"providers": [{
+			"name":"google",
+			"type":"google",
+			"oAuth":{
+				"clientID":"foobar", 
+				"clientSecret":"foo", 
+				"redirectURL":"http://redirect.co.uk"
 			}
+		}]
+	}`
+	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
+	mockFileReader.EXPECT().Read("/foo/path.config").Return([]byte(testData), nil)
+	reader, err := config.NewReader(mockFileReader)
+	require.NoError(t, err)
+
+	_, err = reader.Read("/foo/path.config")
+	assert.EqualError(t, err, "configuration invalid: BindAddress: required field missing")
+}
+
+func TestConfigValidationReportsAllErrors(t *testing.T) {
+	testData := `{
+		"mongoSession": {
+			"connectionString":"mongodb://database",
+			"databaseName":"db"
 		},
-		"userMapping": {
-			"ID": "sub",
-			"FirstName": "given_name",
-			"LastName": "family_name",
-			"EmailAddress": "email"
-		}
+		"providers": [{
+			"name":"google",
+			"type":"google",
+			"oAuth":{"clientID":"foo", "clientSecret":"bar", "redirectURL":"http://redirect.co.uk/google/redirect"}
+		},
+		{
+			"name":"github",
+			"type":"github"
+		}]
 	}`
 	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
 	mockFileReader.EXPECT().Read("/foo/path.config").Return([]byte(testData), nil)
@@ -164,26 +289,72 @@ func TestConfigValidation(t *testing.T) {
 	require.NoError(t, err)
 
 	_, err = reader.Read("/foo/path.config")
-	assert.EqualError(t, err, "configuration invalid: required field bind address missing")
+	assert.EqualError(t, err, "configuration invalid: BindAddress: required field missing; "+
+		"MongoSession.EncryptKey: required field missing; "+
+		"Providers[1].OAuth: required field missing")
 }
 
 func TestConfigValidationMissingOAuth(t *testing.T) {
 	testData := `{
 		"bindAddress":":80",
-		"loginRoutePath":"/Login",
-		"redirectRoutePath":"/Redirect",
 		"mongoSession": {
 			"name": "session",
 			"encryptKey":"KEY",
 			"connectionString":"mongodb://database",
 			"databaseName":"db"
 		},
-		"userMapping": {
-			"ID": "sub",
-			"FirstName": "given_name",
-			"LastName": "family_name",
-			"EmailAddress": "email"
-		}
+		"providers": [{
+			"name":"google",
+			"type":"google"
+		}]
+	}`
+	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
+	mockFileReader.EXPECT().Read("/foo/path.config").Return([]byte(testData), nil)
+	reader, err := config.NewReader(mockFileReader)
+	require.NoError(t, err)
+
+	_, err = reader.Read("/foo/path.config")
+	assert.EqualError(t, err, "configuration invalid: Providers[0].OAuth: required field missing")
+}
+
+func TestConfigValidationInvalidURL(t *testing.T) {
+	testData := `{
+		"bindAddress":":80",
+		"mongoSession": {
+			"encryptKey":"KEY",
+			"connectionString":"mongodb://database",
+			"databaseName":"db"
+		},
+		"token": {"authServiceAddress":"auth-service"},
+		"providers": [{
+			"name":"google",
+			"type":"google",
+			"oAuth":{"clientID":"foo"}
+		}]
+	}`
+	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
+	mockFileReader.EXPECT().Read("/foo/path.config").Return([]byte(testData), nil)
+	reader, err := config.NewReader(mockFileReader)
+	require.NoError(t, err)
+
+	_, err = reader.Read("/foo/path.config")
+	assert.EqualError(t, err, `configuration invalid: Token.AuthServiceAddress: invalid url "auth-service"`)
+}
+
+func TestConfigValidationNegativeSessionMaxAge(t *testing.T) {
+	testData := `{
+		"bindAddress":":80",
+		"mongoSession": {
+			"encryptKey":"KEY",
+			"connectionString":"mongodb://database",
+			"databaseName":"db",
+			"maxAge":-1
+		},
+		"providers": [{
+			"name":"google",
+			"type":"google",
+			"oAuth":{"clientID":"foo"}
+		}]
 	}`
 	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
 	mockFileReader.EXPECT().Read("/foo/path.config").Return([]byte(testData), nil)
@@ -191,5 +362,167 @@ func TestConfigValidationMissingOAuth(t *testing.T) {
 	require.NoError(t, err)
 
 	_, err = reader.Read("/foo/path.config")
-	assert.EqualError(t, err, "configuration invalid: required field oauth missing")
+	assert.EqualError(t, err, "configuration invalid: MongoSession.MaxAge: value must be at least 0")
+}
+
+func TestConfigProviderDefaults(t *testing.T) {
+	testData := `{
+		"bindAddress":":80",
+		"mongoSession": {
+			"encryptKey":"KEY",
+			"connectionString":"mongodb://database",
+			"databaseName":"db"
+		},
+		"providers": [{
+			"name":"google",
+			"type":"google",
+			"oAuth":{"clientID":"foo", "clientSecret":"bar", "redirectURL":"http://redirect.co.uk/google/redirect"}
+		},
+		{
+			"name":"github",
+			"type":"github",
+			"oAuth":{"clientID":"foo", "clientSecret":"bar", "redirectURL":"http://redirect.co.uk/github/redirect"}
+		}]
+	}`
+	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
+	mockFileReader.EXPECT().Read("/foo/path.config").Return([]byte(testData), nil)
+	reader, err := config.NewReader(mockFileReader)
+	require.NoError(t, err)
+
+	conf, err := reader.Read("/foo/path.config")
+	require.NoError(t, err)
+	require.Len(t, conf.Providers, 2)
+
+	google := conf.Providers[0]
+	assert.Equal(t, "/google/login", google.LoginRoutePath)
+	assert.Equal(t, "/google/redirect", google.RedirectRoutePath)
+	assert.Equal(t, "https://accounts.google.com/o/oauth2/auth", google.OAuth.Endpoint.AuthURL)
+	assert.Equal(t, "https://www.googleapis.com/oauth2/v3/userinfo", google.APIEndpoint)
+	assert.Equal(t, "sub", google.UserMapping.ID)
+
+	github := conf.Providers[1]
+	assert.Equal(t, "/github/login", github.LoginRoutePath)
+	assert.Equal(t, "https://github.com/login/oauth/access_token", github.OAuth.Endpoint.TokenURL)
+	assert.Equal(t, "https://api.github.com/user", github.APIEndpoint)
+	assert.Equal(t, "https://api.github.com/user/emails", github.EmailsEndpoint)
+	assert.Equal(t, "login", github.UserMapping.Username)
+	assert.Equal(t, "name", github.UserMapping.Name)
+}
+
+func TestConfigValidationDuplicateProvider(t *testing.T) {
+	testData := `{
+		"bindAddress":":80",
+		"mongoSession": {
+			"encryptKey":"KEY",
+			"connectionString":"mongodb://database",
+			"databaseName":"db"
+		},
+		"providers": [{
+			"name":"google",
+			"type":"google",
+			"oAuth":{"clientID":"foo"}
+		},
+		{
+			"name":"google",
+			"type":"google",
+			"loginRoutePath":"/other/login",
+			"redirectRoutePath":"/other/redirect",
+			"oAuth":{"clientID":"foo"}
+		}]
+	}`
+	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
+	mockFileReader.EXPECT().Read("/foo/path.config").Return([]byte(testData), nil)
+	reader, err := config.NewReader(mockFileReader)
+	require.NoError(t, err)
+
+	_, err = reader.Read("/foo/path.config")
+	assert.EqualError(t, err, "configuration invalid: provider google is defined more than once")
+}
+
+func TestConfigValidationRefreshRouteInUse(t *testing.T) {
+	testData := `{
+		"bindAddress":":80",
+		"mongoSession": {
+			"encryptKey":"KEY",
+			"connectionString":"mongodb://database",
+			"databaseName":"db"
+		},
+		"logout": {"routePath":"/logout"},
+		"token": {"refreshRoutePath":"/logout"},
+		"providers": [{
+			"name":"google",
+			"type":"google",
+			"oAuth":{"clientID":"foo"}
+		}]
+	}`
+	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
+	mockFileReader.EXPECT().Read("/foo/path.config").Return([]byte(testData), nil)
+	reader, err := config.NewReader(mockFileReader)
+	require.NoError(t, err)
+
+	_, err = reader.Read("/foo/path.config")
+	assert.EqualError(t, err, "configuration invalid: token refresh route path /logout is already in use")
+}
+
+func TestConfigValidationOIDCMissingIssuer(t *testing.T) {
+	testData := `{
+		"bindAddress":":80",
+		"mongoSession": {
+			"encryptKey":"KEY",
+			"connectionString":"mongodb://database",
+			"databaseName":"db"
+		},
+		"providers": [{
+			"name":"idp",
+			"type":"oidc",
+			"oAuth":{"clientID":"foo"}
+		}]
+	}`
+	mockFileReader := mocks.NewMockFileReader(gomock.NewController(t))
+	mockFileReader.EXPECT().Read("/foo/path.config").Return([]byte(testData), nil)
+	reader, err := config.NewReader(mockFileReader)
+	require.NoError(t, err)
+
+	_, err = reader.Read("/foo/path.config")
+	assert.EqualError(t, err, "configuration invalid: provider idp required field issuer missing")
+}
+
+func TestReturnToIsAllowed(t *testing.T) {
+	opts := config.ReturnToOptions{
+		AllowedHosts:        []string{"app.example.com"},
+		AllowedPathPrefixes: []string{"/app/"},
+	}
+	tests := map[string]bool{
+		"/app/users/1":                         true,
+		"/app/users?page=2#top":                true,
+		"https://app.example.com/app/settings": true,
+		"/admin":                               false,
+		"/app/../admin":                        false,
+		"app/users":                            false,
+		"//evil.com/app/":                      false,
+		"/\\evil.com/app/":                     false,
+		"https://evil.com/app/":                false,
+		"https://app.example.com.evil.com/app": false,
+		"javascript:alert(1)":                  false,
+		"https://user@app.example.com/app/":    false,
+	}
+	for url, expected := range tests {
+		assert.Equal(t, expected, opts.IsAllowed(url), url)
+	}
+	assert.Equal(t, "app/", opts.Default())
+}
+
+func TestReturnToIsAllowedPathSegments(t *testing.T) {
+	opts := config.ReturnToOptions{AllowedPathPrefixes: []string{"/app"}}
+	tests := map[string]bool{
+		"/app":         true,
+		"/app/":        true,
+		"/app/users/1": true,
+		"/application": false,
+		"/app-admin":   false,
+		"/apps/1":      false,
+	}
+	for url, expected := range tests {
+		assert.Equal(t, expected, opts.IsAllowed(url), url)
+	}
 }
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Watcher reads the configuration again when the file changes, or when a signal is received, and applies it.
+// The file is polled rather than watched for events, as secrets and config maps mounted by Kubernetes are
+// updated by swapping a symlink. Config that fails to read, fails validation or is rejected by Apply is logged
+// and the current config is kept.
+type Watcher struct {
+	Reader Reader
+	Path   string
+	// Current is the config in use when watching starts. Config read from the file is only applied when it
+	// differs from the config in use.
+	Current Options
+	// Interval is how often the file is checked for changes, every ten seconds when it is not set.
+	Interval time.Duration
+	// Signals forces the config to be read and applied again, even when it has not changed. It is typically
+	// notified of SIGHUP.
+	Signals <-chan os.Signal
+	// Apply is called with each new valid config. An error rejects the config.
+	Apply func(Options) error
+}
+
+// Watch watches for changes until the stop channel is closed.
+func (w Watcher) Watch(stop <-chan struct{}) {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	current, last := w.Current, []byte(nil)
+	for {
+		force := false
+		select {
+		case <-stop:
+			return
+		case <-w.Signals:
+			force = true
+		case <-ticker.C:
+		}
+		logger := logrus.WithField("path", w.Path)
+		data, err := w.Reader.fileReader.Read(w.Path)
+		if err != nil {
+			logger.WithError(err).Error("Failed to read the configuration file, the current configuration is still in use.")
+			continue
+		}
+		if !force && bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		options, err := w.Reader.decode(w.Path, data)
+		if err == nil && !force && reflect.DeepEqual(options, current) {
+			continue
+		}
+		if err == nil {
+			err = w.Apply(options)
+		}
+		if err != nil {
+			logger.WithError(err).Error("Failed to reload the configuration, the current configuration is still in use.")
+			continue
+		}
+		current = options
+		logger.Info("Reloaded the configuration.")
+	}
+}
@@ -0,0 +1,136 @@
+package config_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/darren-west/app/oauth-service/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const watchTestData = `{
+	"bindAddress":":80",
+	"mongoSession": {"encryptKey":"KEY", "connectionString":"mongodb://database", "databaseName":"db"},
+	"providers": [{
+		"name":"google",
+		"type":"google",
+		"oAuth":{"clientID":"%s", "clientSecret":"bar", "redirectURL":"http://redirect.co.uk/google/redirect"}
+	}]
+}`
+
+// fileReader returns the data it is set to, safe to change while it is being read.
+type fileReader struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (f *fileReader) Read(string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.data, nil
+}
+
+func (f *fileReader) Set(data string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data = []byte(data)
+}
+
+func newWatcher(t *testing.T, data string) (*fileReader, config.Watcher, chan os.Signal, chan config.Options) {
+	files := &fileReader{}
+	files.Set(data)
+	reader, err := config.NewReader(files)
+	require.NoError(t, err)
+	current, err := reader.Read("config.json")
+	require.NoError(t, err)
+	signals, applied := make(chan os.Signal), make(chan config.Options, 1)
+	return files, config.Watcher{
+		Reader:   reader,
+		Path:     "config.json",
+		Current:  current,
+		Interval: time.Millisecond,
+		Signals:  signals,
+		Apply: func(o config.Options) error {
+			applied <- o
+			return nil
+		},
+	}, signals, applied
+}
+
+func receive(t *testing.T, applied chan config.Options) config.Options {
+	select {
+	case o := <-applied:
+		return o
+	case <-time.After(time.Second):
+		require.FailNow(t, "the config was not applied")
+	}
+	return config.Options{}
+}
+
+func TestWatcherFileChanged(t *testing.T) {
+	files, watcher, _, applied := newWatcher(t, fmt.Sprintf(watchTestData, "first"))
+	stop := make(chan struct{})
+	defer close(stop)
+	go watcher.Watch(stop)
+
+	files.Set(fmt.Sprintf(watchTestData, "second"))
+	options := receive(t, applied)
+	assert.Equal(t, "second", options.Providers[0].OAuth.ClientID)
+	assert.Equal(t, "/google/login", options.Providers[0].LoginRoutePath, "the provider defaults should be applied")
+}
+
+func TestWatcherSignal(t *testing.T) {
+	_, watcher, signals, applied := newWatcher(t, fmt.Sprintf(watchTestData, "first"))
+	stop := make(chan struct{})
+	defer close(stop)
+	go watcher.Watch(stop)
+
+	select {
+	case <-applied:
+		require.FailNow(t, "the config should not be applied when the file has not changed")
+	case <-time.After(10 * time.Millisecond):
+	}
+	signals <- syscall.SIGHUP
+	options := receive(t, applied)
+	assert.Equal(t, "first", options.Providers[0].OAuth.ClientID)
+}
+
+func TestWatcherRejectsInvalidConfig(t *testing.T) {
+	files, watcher, _, applied := newWatcher(t, fmt.Sprintf(watchTestData, "first"))
+	stop := make(chan struct{})
+	defer close(stop)
+	go watcher.Watch(stop)
+
+	files.Set(`{"bindAddress":":80"}`)
+	select {
+	case <-applied:
+		require.FailNow(t, "invalid config should not be applied")
+	case <-time.After(10 * time.Millisecond):
+	}
+	files.Set(fmt.Sprintf(watchTestData, "third"))
+	assert.Equal(t, "third", receive(t, applied).Providers[0].OAuth.ClientID)
+}
+
+func TestWatcherApplyError(t *testing.T) {
+	files, watcher, signals, applied := newWatcher(t, fmt.Sprintf(watchTestData, "first"))
+	attempts := make(chan string, 2)
+	watcher.Apply = func(o config.Options) error {
+		attempts <- o.Providers[0].OAuth.ClientID
+		return errors.New("boom")
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go watcher.Watch(stop)
+
+	files.Set(fmt.Sprintf(watchTestData, "second"))
+	assert.Equal(t, "second", <-attempts)
+	signals <- syscall.SIGHUP
+	assert.Equal(t, "second", <-attempts, "a rejected config should be retried on a signal")
+	assert.Empty(t, applied)
+}
@@ -1,21 +1,28 @@
 module github.com/darren-west/app/oauth-service
 
+go 1.11
+
 require (
+	github.com/darren-west/app/auth-service v0.0.0-00010101000000-000000000000
 	github.com/darren-west/app/session v0.0.0-20181026151129-75678e0b758f
-	github.com/darren-west/app/utils v0.0.0-20181116113853-806c67e1bf85
+	github.com/darren-west/app/user-service v0.0.0-20181116142938-ab0bccd74720
+	github.com/darren-west/app/utils v0.0.0-20181116154356-1025072d162e
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-playground/locales v0.12.1 // indirect
 	github.com/go-playground/universal-translator v0.16.0 // indirect
 	github.com/golang/mock v1.1.1
 	github.com/golang/protobuf v1.2.0 // indirect
 	github.com/google/uuid v1.0.1-0.20180917140005-9b3b1e0f5f99
 	github.com/gorilla/sessions v1.1.3
-	github.com/kidstuff/mongostore v0.0.0-20180412085134-db2a8b4fac1f
-	github.com/sirupsen/logrus v1.1.0
+	github.com/sirupsen/logrus v1.2.0
 	github.com/stretchr/testify v1.2.2
-	golang.org/x/net v0.0.0-20180911220305-26e67e76b6c3 // indirect
 	golang.org/x/oauth2 v0.0.0-20180821212333-d2e6202438be
-	golang.org/x/sync v0.0.0-20180314180146-1d60e4601c6f // indirect
 	google.golang.org/appengine v1.1.0 // indirect
 	gopkg.in/go-playground/validator.v9 v9.23.0
+)
 
+replace (
+	github.com/darren-west/app/auth-service => ../auth-service
+	github.com/darren-west/app/user-service => ../user-service
+	github.com/darren-west/app/utils => ../utils
 )
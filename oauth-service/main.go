@@ -1,24 +1,39 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	authclient "github.com/darren-west/app/auth-service/client"
+	authmodels "github.com/darren-west/app/auth-service/models"
+	userclient "github.com/darren-west/app/user-service/client"
+	utilconfig "github.com/darren-west/app/utils/config"
 	"github.com/darren-west/app/utils/fileutil"
 	"github.com/darren-west/app/utils/session"
 
 	"github.com/darren-west/app/oauth-service/auth"
 	"github.com/darren-west/app/oauth-service/config"
+	"github.com/darren-west/app/oauth-service/provisioner"
 	"github.com/darren-west/app/oauth-service/redirector"
+	"github.com/gorilla/sessions"
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	configFlag = flag.String("config", "config.json", "--config the path to the oauth2 configuration file")
+	configFlag = flag.String("config", "config.json", "--config the path to the oauth2 configuration file, json or yaml")
+	flags      *utilconfig.Flags
 )
 
 func init() {
+	var err error
+	if flags, err = utilconfig.NewFlags(flag.CommandLine, &config.Options{}); err != nil {
+		logrus.Fatal(err)
+	}
 	flag.Parse()
 }
 
@@ -27,28 +42,123 @@ func main() {
 	if err != nil {
 		logrus.Fatal(err)
 	}
-	config, err := reader.Read(*configFlag)
+	reader = reader.WithFlags(flags)
+	options, err := reader.Read(*configFlag)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 
-	store, err := session.NewMongoStore(config.MongoSession)
+	store, err := session.NewMongoStore(options.MongoSession)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 
-	h, err := auth.NewHandler(
-		auth.WithConfig(config),
-		auth.WithSessionStore(store),
-		auth.WithLoginHandler(redirector.Login{Store: store}),
-	)
+	index, err := session.NewMongoIndex(options.MongoSession)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	h, err := newHandler(options, store, index)
 	if err != nil {
 		log.Fatal(err)
 	}
+	handler := auth.NewReloadable(h)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	watcher := newWatcher(reader, options, signals, func(options config.Options) error {
+		h, err := newHandler(options, store, index)
+		if err != nil {
+			return err
+		}
+		handler.Swap(h)
+		return nil
+	})
+	go watcher.Watch(nil)
+
 	mux := http.NewServeMux()
-	mux.Handle("/", h)
+	mux.Handle("/", handler)
 	mux.HandleFunc("/health", health)
-	log.Fatal(http.ListenAndServe(":80", mux))
+	log.Fatal(http.ListenAndServe(options.BindAddress, mux))
+}
+
+// newWatcher returns a watcher of the config file. The bind address and mongo session are used before the
+// handler is created, changes to them are logged and only take effect after a restart.
+func newWatcher(reader config.Reader, current config.Options, signals <-chan os.Signal, swap func(config.Options) error) config.Watcher {
+	return config.Watcher{
+		Reader:  reader,
+		Path:    *configFlag,
+		Current: current,
+		Signals: signals,
+		Apply: func(options config.Options) error {
+			if options.BindAddress != current.BindAddress || options.MongoSession != current.MongoSession {
+				logrus.Warn("The bind address and mongo session configuration are only changed by a restart.")
+			}
+			return swap(options)
+		},
+	}
+}
+
+// newHandler creates the handler for the config. It is called again with the new config when the config is
+// reloaded, the session store and index are kept as they are.
+func newHandler(options config.Options, store sessions.Store, index auth.SessionIndex) (auth.Handler, error) {
+	tokenOpts := []authclient.Option{}
+	if options.Token.AuthServiceAddress != "" {
+		tokenOpts = append(tokenOpts, authclient.WithBaseAddress(options.Token.AuthServiceAddress))
+	}
+	if options.Token.ClientID != "" {
+		credentials := authclient.Credentials{
+			ClientID: options.Token.ClientID,
+			Secret:   options.Token.ClientSecret,
+			Scopes:   []string{authmodels.ScopeExchangeTokens},
+		}
+		tokenOpts = append(tokenOpts, authclient.WithTokenSource(authclient.New(tokenOpts...).TokenSource(credentials)))
+	}
+	tokens := authclient.New(tokenOpts...)
+	redirect := redirector.Login{
+		Store:    store,
+		Tokens:   tokens,
+		Token:    options.Token,
+		ReturnTo: options.ReturnTo,
+		Index:    index,
+	}
+
+	userOpts := []userclient.Option{}
+	if options.Provision.UserServiceAddress != "" {
+		userOpts = append(userOpts, userclient.WithBaseAddress(options.Provision.UserServiceAddress))
+	}
+	login := provisioner.Login{
+		Users:  userclient.New(userOpts...),
+		Tokens: redirect.Tokens,
+		Next:   redirect,
+	}
+
+	handlerOpts := []auth.Option{
+		auth.WithConfig(options),
+		auth.WithSessionStore(store),
+		auth.WithSessionIndex(index),
+		auth.WithLoginHandler(login),
+	}
+	if options.Token.ClientID != "" {
+		credentials := authclient.Credentials{ClientID: options.Token.ClientID, Secret: options.Token.ClientSecret}
+		handlerOpts = append(handlerOpts, auth.WithAPITokens(apiTokens{Service: tokens, credentials: credentials}))
+	}
+	return auth.NewHandler(handlerOpts...)
+}
+
+// apiTokens is the auth service client api tokens are managed with, authenticated as the oauth service client the
+// tokens are issued to.
+type apiTokens struct {
+	authclient.Service
+	credentials authclient.Credentials
+}
+
+func (t apiTokens) RefreshToken(ctx context.Context, refreshToken string) (authmodels.Tokens, error) {
+	return t.Service.RefreshToken(ctx, t.credentials, refreshToken)
+}
+
+func (t apiTokens) Revoke(ctx context.Context, token string) error {
+	return t.Service.Revoke(ctx, t.credentials, token)
 }
 
 func health(w http.ResponseWriter, _ *http.Request) {
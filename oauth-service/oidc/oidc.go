@@ -0,0 +1,142 @@
+// Package oidc implements the parts of OpenID Connect needed to log a user in with the authorization code flow:
+// provider discovery and verification of the returned id token.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/darren-west/app/utils/jwt"
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+// Configuration is the providers metadata returned from the discovery endpoint.
+type Configuration struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	RevocationEndpoint    string `json:"revocation_endpoint"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// Discover fetches the providers configuration from the issuers well known discovery endpoint.
+func Discover(ctx context.Context, client *http.Client, issuer string) (config Configuration, err error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(issuer, "/")+discoveryPath, nil)
+	if err != nil {
+		return
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		err = fmt.Errorf("discovery failed: %s", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("discovery failed: status %d", resp.StatusCode)
+		return
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		err = fmt.Errorf("discovery failed: %s", err)
+		return
+	}
+	if strings.TrimSuffix(config.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		err = fmt.Errorf("discovery failed: issuer %s does not match %s", config.Issuer, issuer)
+		return
+	}
+	return
+}
+
+// NewVerifier returns a verifier for id tokens issued by the issuer to the client id given.
+func NewVerifier(issuer, clientID string, keys jwt.KeySource) Verifier {
+	return Verifier{
+		issuer:   issuer,
+		clientID: clientID,
+		keys:     keys,
+		now:      time.Now,
+	}
+}
+
+// Verifier verifies id tokens.
+type Verifier struct {
+	issuer   string
+	clientID string
+	keys     jwt.KeySource
+	now      func() time.Time
+}
+
+// Verify checks the id tokens signature, issuer, audience, expiry and nonce and returns its claims.
+func (v Verifier) Verify(rawIDToken, nonce string) (claims map[string]interface{}, err error) {
+	parser := jwtgo.Parser{UseJSONNumber: true, SkipClaimsValidation: true}
+	mapClaims := jwtgo.MapClaims{}
+	if _, err = parser.ParseWithClaims(rawIDToken, mapClaims, v.key); err != nil {
+		err = fmt.Errorf("invalid id token: %s", err)
+		return
+	}
+	if err = v.validate(mapClaims, nonce); err != nil {
+		err = fmt.Errorf("invalid id token: %s", err)
+		return
+	}
+	return map[string]interface{}(mapClaims), nil
+}
+
+func (v Verifier) key(token *jwtgo.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwtgo.SigningMethodRSA, *jwtgo.SigningMethodECDSA:
+	default:
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	kid, _ := token.Header["kid"].(string)
+	return v.keys.PublicKey(kid)
+}
+
+func (v Verifier) validate(claims jwtgo.MapClaims, nonce string) error {
+	if iss, _ := claims["iss"].(string); iss != v.issuer {
+		return fmt.Errorf("issuer %s does not match %s", iss, v.issuer)
+	}
+	if !hasAudience(claims["aud"], v.clientID) {
+		return fmt.Errorf("audience does not contain %s", v.clientID)
+	}
+	exp, ok := number(claims["exp"])
+	if !ok {
+		return errors.New("missing expiry")
+	}
+	if v.now().Unix() > exp {
+		return errors.New("token is expired")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return errors.New("nonce does not match")
+	}
+	return nil
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == clientID
+	case []interface{}:
+		for _, a := range value {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func number(v interface{}) (int64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return int64(f), err == nil
+}
@@ -0,0 +1,84 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/darren-west/app/oauth-service/oidc"
+	"github.com/darren-west/app/oauth-service/oidc/oidctest"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscover(t *testing.T) {
+	provider := oidctest.NewProvider("client")
+	defer provider.Close()
+
+	conf, err := oidc.Discover(context.Background(), http.DefaultClient, provider.URL)
+	require.NoError(t, err)
+	assert.Equal(t, provider.URL, conf.Issuer)
+	assert.Equal(t, provider.URL+"/authorize", conf.AuthorizationEndpoint)
+	assert.Equal(t, provider.URL+"/token", conf.TokenEndpoint)
+	assert.Equal(t, provider.URL+"/jwks", conf.JWKSURI)
+}
+
+func TestDiscoverError(t *testing.T) {
+	provider := oidctest.NewProvider("client")
+	defer provider.Close()
+
+	_, err := oidc.Discover(context.Background(), http.DefaultClient, provider.URL+"/missing")
+	assert.EqualError(t, err, "discovery failed: status 404")
+}
+
+func TestVerify(t *testing.T) {
+	provider := oidctest.NewProvider("client")
+	defer provider.Close()
+	verifier := oidc.NewVerifier(provider.URL, "client", jwt.NewRemoteKeySet(provider.URL+"/jwks"))
+
+	claims, err := verifier.Verify(provider.IDToken(map[string]interface{}{"sub": "1234", "nonce": "abc"}), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "1234", claims["sub"])
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	provider := oidctest.NewProvider("client")
+	defer provider.Close()
+	other := oidctest.NewProvider("client")
+	defer other.Close()
+	verifier := oidc.NewVerifier(provider.URL, "client", jwt.NewRemoteKeySet(provider.URL+"/jwks"))
+
+	tests := map[string]struct {
+		token string
+		err   string
+	}{
+		"nonce": {
+			token: provider.IDToken(map[string]interface{}{"nonce": "other"}),
+			err:   "invalid id token: nonce does not match",
+		},
+		"audience": {
+			token: provider.IDToken(map[string]interface{}{"nonce": "abc", "aud": []string{"foo", "bar"}}),
+			err:   "invalid id token: audience does not contain client",
+		},
+		"issuer": {
+			token: provider.IDToken(map[string]interface{}{"nonce": "abc", "iss": "https://evil.com"}),
+			err:   "invalid id token: issuer https://evil.com does not match " + provider.URL,
+		},
+		"expired": {
+			token: provider.IDToken(map[string]interface{}{"nonce": "abc", "exp": time.Now().Add(-time.Minute).Unix()}),
+			err:   "invalid id token: token is expired",
+		},
+		"signature": {
+			token: other.IDToken(map[string]interface{}{"nonce": "abc", "iss": provider.URL}),
+			err:   "invalid id token: crypto/rsa: verification error",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := verifier.Verify(test.token, "abc")
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
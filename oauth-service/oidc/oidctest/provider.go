@@ -0,0 +1,97 @@
+// Package oidctest provides a minimal OpenID Connect provider for use in tests.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/darren-west/app/oauth-service/oidc"
+	"github.com/darren-west/app/utils/jwt"
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+const keyID = "test-key"
+
+// NewProvider starts a provider that issues id tokens to the client id given.
+func NewProvider(clientID string) *Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	p := &Provider{
+		ClientID: clientID,
+		Claims:   make(map[string]interface{}),
+		key:      key,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// Provider is a test OpenID Connect provider. The token endpoint returns an id token containing Claims.
+// A PKCE code verifier is required when exchanging the code.
+type Provider struct {
+	*httptest.Server
+	ClientID string
+	Claims   map[string]interface{}
+	key      *rsa.PrivateKey
+}
+
+// IDToken returns an id token signed by the provider. The issuer, audience and expiry are
+// set to valid values unless they are in the claims given.
+func (p *Provider) IDToken(claims map[string]interface{}) string {
+	mapClaims := jwtgo.MapClaims{
+		"iss": p.URL,
+		"aud": p.ClientID,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+	for k, v := range claims {
+		mapClaims[k] = v
+	}
+	token := jwtgo.NewWithClaims(jwtgo.SigningMethodRS256, mapClaims)
+	token.Header["kid"] = keyID
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, _ *http.Request) {
+	json.NewEncoder(w).Encode(oidc.Configuration{
+		Issuer:                p.URL,
+		AuthorizationEndpoint: p.URL + "/authorize",
+		TokenEndpoint:         p.URL + "/token",
+		JWKSURI:               p.URL + "/jwks",
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, _ *http.Request) {
+	key, err := jwt.NewJSONWebKey(keyID, &p.key.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(jwt.JSONWebKeySet{Keys: []jwt.JSONWebKey{key}})
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("code_verifier") == "" {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "foo",
+		"token_type":   "Bearer",
+		"id_token":     p.IDToken(p.Claims),
+	})
+}
@@ -0,0 +1,97 @@
+// Package provisioner creates users in the user service the first time they log in and keeps their details
+// in sync with their identity provider on later logins.
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/darren-west/app/oauth-service/auth"
+	"github.com/darren-west/app/oauth-service/httputil"
+	"github.com/darren-west/app/oauth-service/redirector"
+	"github.com/darren-west/app/user-service/client"
+	"github.com/darren-west/app/user-service/models"
+	utilshttputil "github.com/darren-west/app/utils/httputil"
+	"github.com/sirupsen/logrus"
+)
+
+var _ auth.LoginHandler = Login{} // ensure the Login handler implements the interface.
+
+//go:generate mockgen -destination ./mocks/mock_user_service.go -package mocks github.com/darren-west/app/oauth-service/provisioner UserService
+
+// UserService reads and writes users. It is implemented by the user service client.
+type UserService interface {
+	GetUser(ctx context.Context, id string) (models.UserInfo, error)
+	CreateUser(ctx context.Context, user models.UserInfo) error
+	UpdateUser(ctx context.Context, user models.UserInfo) error
+}
+
+// Login provisions the logged in user in the user service before passing them on to the next login handler.
+// The user id is qualified by the provider so ids from different providers can't collide, the next handler
+// is passed the user with the qualified id. The user service only lets users change their own record, so the user
+// is exchanged for an api token first and the user service is called with it. The tokens are passed on to the
+// next handler on the request context. A user whose email is already used by a user from another provider can't be
+// stored, they are logged in without a record in the user service rather than being locked out.
+type Login struct {
+	Users  UserService
+	Tokens redirector.TokenExchanger
+	Next   auth.LoginHandler
+}
+
+func (l Login) Handle(user auth.UserInfo, w http.ResponseWriter, r *http.Request) {
+	user.ID = UserID(user)
+	tokens, err := redirector.ExchangeToken(r.Context(), l.Tokens, user)
+	if err == nil {
+		err = l.provision(utilshttputil.ContextWithBearerToken(r.Context(), tokens.AccessToken), user)
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("user", user.ID).Error("Failed to provision user.")
+		httputil.NewError(http.StatusBadGateway, errors.New("failed to provision user")).Write(w)
+		return
+	}
+	if l.Next != nil {
+		l.Next.Handle(user, w, r.WithContext(redirector.ContextWithTokens(r.Context(), tokens)))
+	}
+}
+
+func (l Login) provision(ctx context.Context, user auth.UserInfo) (err error) {
+	err = l.sync(ctx, user)
+	if client.IsConflictError(err) {
+		logrus.WithError(err).WithField("user", user.ID).Warn("The email of the user is used by another user, logging in without provisioning.")
+		return nil
+	}
+	return
+}
+
+// sync creates the user in the user service, or updates them when their details have changed.
+func (l Login) sync(ctx context.Context, user auth.UserInfo) (err error) {
+	expected := models.UserInfo{
+		ID:        user.ID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Email:     user.Email,
+	}
+	existing, err := l.Users.GetUser(ctx, user.ID)
+	if client.IsNotFoundError(err) {
+		return l.Users.CreateUser(ctx, expected)
+	}
+	if err != nil {
+		return
+	}
+	if existing.FirstName == expected.FirstName && existing.LastName == expected.LastName && existing.Email == expected.Email {
+		return
+	}
+	// users can't change their own roles, so the roles are kept as they are.
+	expected.Roles = existing.Roles
+	return l.Users.UpdateUser(ctx, expected)
+}
+
+// UserID returns the id the user is stored under in the user service, the providers id for the user prefixed
+// by the provider name.
+func UserID(user auth.UserInfo) string {
+	if user.Provider == "" {
+		return user.ID
+	}
+	return user.Provider + ":" + user.ID
+}
@@ -0,0 +1,155 @@
+package provisioner_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authmodels "github.com/darren-west/app/auth-service/models"
+	"github.com/darren-west/app/oauth-service/auth"
+	authmocks "github.com/darren-west/app/oauth-service/auth/mocks"
+	"github.com/darren-west/app/oauth-service/provisioner"
+	"github.com/darren-west/app/oauth-service/provisioner/mocks"
+	"github.com/darren-west/app/oauth-service/redirector"
+	redirectormocks "github.com/darren-west/app/oauth-service/redirector/mocks"
+	"github.com/darren-west/app/user-service/models"
+	"github.com/darren-west/app/utils/httputil"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestProvisionerSuite(t *testing.T) {
+	suite.Run(t, &ProvisionerSuite{})
+}
+
+type ProvisionerSuite struct {
+	suite.Suite
+	ctrl   *gomock.Controller
+	users  *mocks.MockUserService
+	tokens *redirectormocks.MockTokenExchanger
+	next   *authmocks.MockLoginHandler
+	login  provisioner.Login
+	user   auth.UserInfo
+}
+
+func (ps *ProvisionerSuite) SetupTest() {
+	ps.ctrl = gomock.NewController(ps.T())
+	ps.users = mocks.NewMockUserService(ps.ctrl)
+	ps.tokens = redirectormocks.NewMockTokenExchanger(ps.ctrl)
+	ps.next = authmocks.NewMockLoginHandler(ps.ctrl)
+	ps.login = provisioner.Login{Users: ps.users, Tokens: ps.tokens, Next: ps.next}
+	ps.user = auth.UserInfo{ID: "123", FirstName: "foo", LastName: "bar", Email: "foo@bar.com", Provider: "google"}
+	ps.tokens.EXPECT().
+		ExchangeToken(gomock.Any(), jwt.User{ID: "google:123", FirstName: "foo", LastName: "bar", Email: "foo@bar.com"}).
+		Return(authmodels.Tokens{AccessToken: "access", RefreshToken: "refresh"}, nil).
+		AnyTimes()
+}
+
+func (ps *ProvisionerSuite) TearDownTest() {
+	ps.ctrl.Finish()
+}
+
+func (ps *ProvisionerSuite) expectNext() {
+	qualified := ps.user
+	qualified.ID = "google:123"
+	ps.next.EXPECT().Handle(qualified, gomock.Any(), gomock.Any()).Do(func(_ auth.UserInfo, _ http.ResponseWriter, r *http.Request) {
+		tokens, ok := redirector.TokensFromContext(r.Context())
+		ps.Assert().True(ok, "the exchanged tokens should be passed to the next handler")
+		ps.Assert().Equal("access", tokens.AccessToken)
+	})
+}
+
+func (ps *ProvisionerSuite) TestCallsUserServiceWithUsersToken() {
+	ps.users.EXPECT().GetUser(gomock.Any(), "google:123").
+		Do(func(ctx context.Context, _ string) {
+			token, _ := httputil.BearerTokenFromContext(ctx)
+			ps.Assert().Equal("access", token)
+		}).
+		Return(models.UserInfo{ID: "google:123", FirstName: "foo", LastName: "bar", Email: "foo@bar.com"}, nil)
+	ps.expectNext()
+
+	ps.login.Handle(ps.user, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redirect", nil))
+}
+
+func (ps *ProvisionerSuite) TestTokenExchangeError() {
+	tokens := redirectormocks.NewMockTokenExchanger(ps.ctrl)
+	tokens.EXPECT().ExchangeToken(gomock.Any(), gomock.Any()).Return(authmodels.Tokens{}, errors.New("boom"))
+	ps.login.Tokens = tokens
+
+	recorder := httptest.NewRecorder()
+	ps.login.Handle(ps.user, recorder, httptest.NewRequest(http.MethodGet, "/redirect", nil))
+	ps.Assert().Equal(http.StatusBadGateway, recorder.Code)
+}
+
+func (ps *ProvisionerSuite) TestCreatesNewUser() {
+	ps.users.EXPECT().GetUser(gomock.Any(), "google:123").
+		Return(models.UserInfo{}, httputil.NewError(http.StatusNotFound).WithMessage("user not found"))
+	ps.users.EXPECT().CreateUser(gomock.Any(), models.UserInfo{ID: "google:123", FirstName: "foo", LastName: "bar", Email: "foo@bar.com"})
+	ps.expectNext()
+
+	ps.login.Handle(ps.user, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redirect", nil))
+}
+
+func (ps *ProvisionerSuite) TestUpdatesChangedUser() {
+	ps.users.EXPECT().GetUser(gomock.Any(), "google:123").
+		Return(models.UserInfo{ID: "google:123", FirstName: "foo", LastName: "baz", Email: "old@bar.com", Roles: []string{"admin"}}, nil)
+	ps.users.EXPECT().UpdateUser(gomock.Any(), models.UserInfo{ID: "google:123", FirstName: "foo", LastName: "bar", Email: "foo@bar.com", Roles: []string{"admin"}})
+	ps.expectNext()
+
+	ps.login.Handle(ps.user, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redirect", nil))
+}
+
+func (ps *ProvisionerSuite) TestUnchangedUser() {
+	ps.users.EXPECT().GetUser(gomock.Any(), "google:123").
+		Return(models.UserInfo{ID: "google:123", FirstName: "foo", LastName: "bar", Email: "foo@bar.com", Roles: []string{"admin"}}, nil)
+	ps.expectNext()
+
+	ps.login.Handle(ps.user, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redirect", nil))
+}
+
+func (ps *ProvisionerSuite) TestUserServiceError() {
+	ps.users.EXPECT().GetUser(gomock.Any(), "google:123").Return(models.UserInfo{}, errors.New("boom"))
+
+	recorder := httptest.NewRecorder()
+	ps.login.Handle(ps.user, recorder, httptest.NewRequest(http.MethodGet, "/redirect", nil))
+	ps.Assert().Equal(http.StatusBadGateway, recorder.Code)
+	ps.Assert().Equal("failed to provision user\n", recorder.Body.String())
+}
+
+func (ps *ProvisionerSuite) TestCreateUserError() {
+	ps.users.EXPECT().GetUser(gomock.Any(), "google:123").
+		Return(models.UserInfo{}, httputil.NewError(http.StatusNotFound).WithMessage("user not found"))
+	ps.users.EXPECT().CreateUser(gomock.Any(), gomock.Any()).Return(errors.New("boom"))
+
+	recorder := httptest.NewRecorder()
+	ps.login.Handle(ps.user, recorder, httptest.NewRequest(http.MethodGet, "/redirect", nil))
+	ps.Assert().Equal(http.StatusBadGateway, recorder.Code)
+}
+
+func (ps *ProvisionerSuite) TestEmailUsedByAnotherProvider() {
+	ps.users.EXPECT().GetUser(gomock.Any(), "google:123").
+		Return(models.UserInfo{}, httputil.NewError(http.StatusNotFound).WithMessage("user not found"))
+	ps.users.EXPECT().CreateUser(gomock.Any(), gomock.Any()).
+		Return(httputil.NewError(http.StatusConflict).WithMessage("duplicate user"))
+	ps.expectNext()
+
+	ps.login.Handle(ps.user, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redirect", nil))
+}
+
+func (ps *ProvisionerSuite) TestChangedEmailUsedByAnotherUser() {
+	ps.users.EXPECT().GetUser(gomock.Any(), "google:123").
+		Return(models.UserInfo{ID: "google:123", FirstName: "foo", LastName: "bar", Email: "old@bar.com"}, nil)
+	ps.users.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).
+		Return(httputil.NewError(http.StatusConflict).WithMessage("duplicate user"))
+	ps.expectNext()
+
+	ps.login.Handle(ps.user, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redirect", nil))
+}
+
+func (ps *ProvisionerSuite) TestUserID() {
+	ps.Assert().Equal("github:42", provisioner.UserID(auth.UserInfo{ID: "42", Provider: "github"}))
+	ps.Assert().Equal("42", provisioner.UserID(auth.UserInfo{ID: "42"}))
+}
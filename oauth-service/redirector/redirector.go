@@ -1,20 +1,66 @@
 package redirector
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"html/template"
 	"net/http"
 
+	"github.com/darren-west/app/auth-service/models"
 	"github.com/darren-west/app/oauth-service/auth"
+	"github.com/darren-west/app/oauth-service/config"
 	"github.com/darren-west/app/oauth-service/httputil"
+	"github.com/darren-west/app/utils/jwt"
 	"github.com/darren-west/app/utils/session"
 	"github.com/gorilla/sessions"
+	"github.com/sirupsen/logrus"
 )
 
 var _ auth.LoginHandler = Login{} // ensure the Login handler implements the interface.
 
+//go:generate mockgen -destination ./mocks/mock_token_exchanger.go -package mocks github.com/darren-west/app/oauth-service/redirector TokenExchanger
+
+// TokenExchanger exchanges a user for a signed api token and a refresh token. It is implemented by the auth
+// service client.
+type TokenExchanger interface {
+	ExchangeToken(ctx context.Context, user jwt.User) (models.Tokens, error)
+}
+
+// ExchangeToken exchanges the logged in user for an api token and refresh token.
+func ExchangeToken(ctx context.Context, tokens TokenExchanger, user auth.UserInfo) (models.Tokens, error) {
+	return tokens.ExchangeToken(ctx, jwt.User{
+		ID:        user.ID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Email:     user.Email,
+	})
+}
+
+type contextKey int
+
+const tokensKey contextKey = 0
+
+// ContextWithTokens returns a copy of the context holding tokens already exchanged for the user, so that Login
+// doesn't exchange the user again.
+func ContextWithTokens(ctx context.Context, tokens models.Tokens) context.Context {
+	return context.WithValue(ctx, tokensKey, tokens)
+}
+
+// TokensFromContext returns the tokens put on the context by ContextWithTokens.
+func TokensFromContext(ctx context.Context) (models.Tokens, bool) {
+	tokens, ok := ctx.Value(tokensKey).(models.Tokens)
+	return tokens, ok
+}
+
+// Login exchanges the logged in user for an api token, stores them in the session and redirects them back to
+// the return to url stored at login, or the default url if there isn't one. If an index is set the session is
+// added to the users sessions. Tokens already on the request context are used instead of exchanging the user again.
 type Login struct {
-	Store sessions.Store
+	Store    sessions.Store
+	Tokens   TokenExchanger
+	Token    config.TokenOptions
+	ReturnTo config.ReturnToOptions
+	Index    auth.SessionIndex
 }
 
 func (l Login) Handle(user auth.UserInfo, w http.ResponseWriter, r *http.Request) {
@@ -23,8 +69,25 @@ func (l Login) Handle(user auth.UserInfo, w http.ResponseWriter, r *http.Request
 		httputil.NewError(http.StatusInternalServerError, err).Write(w)
 		return
 	}
+	returnTo := l.ReturnTo.Default()
+	if value, ok := session.Values["return_to"].(string); ok && l.ReturnTo.IsAllowed(value) {
+		returnTo = value
+	}
 	delete(session.Values, "state")
-	session.Values["api-token"] = base64.StdEncoding.EncodeToString([]byte(user.FirstName))
+	delete(session.Values, "nonce")
+	delete(session.Values, "code_verifier")
+	delete(session.Values, "return_to")
+
+	tokens, ok := TokensFromContext(r.Context())
+	if !ok {
+		if tokens, err = ExchangeToken(r.Context(), l.Tokens, user); err != nil {
+			logrus.WithError(err).WithField("user", user.ID).Error("Failed to exchange user for an api token.")
+			writeErrorPage(w, http.StatusBadGateway, "We couldn't sign you in right now. Please try again in a few minutes.")
+			return
+		}
+	}
+	session.Values["api-token"] = tokens.AccessToken
+	session.Values["refresh-token"] = tokens.RefreshToken
 
 	data, err := json.Marshal(&user)
 	if err != nil {
@@ -38,8 +101,33 @@ func (l Login) Handle(user auth.UserInfo, w http.ResponseWriter, r *http.Request
 		httputil.NewError(http.StatusInternalServerError, err).Write(w)
 		return
 	}
+	if l.Index != nil {
+		if err = l.Index.Add(r.Context(), user.ID, session.ID); err != nil {
+			httputil.NewError(http.StatusInternalServerError, err).Write(w)
+			return
+		}
+	}
+	if l.Token.CookieName != "" {
+		http.SetCookie(w, l.Token.Cookie(tokens.AccessToken))
+	}
 
-	http.Redirect(w, r, "app/", http.StatusPermanentRedirect)
+	http.Redirect(w, r, returnTo, http.StatusFound)
 	// TODO: write username + name to cookie so it can be read by js.
 
 }
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Sign in failed</title></head>
+<body>
+<h1>Sign in failed</h1>
+<p>{{.}}</p>
+</body>
+</html>
+`))
+
+func writeErrorPage(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(statusCode)
+	errorPage.Execute(w, message)
+}
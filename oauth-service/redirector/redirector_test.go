@@ -0,0 +1,132 @@
+package redirector_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/darren-west/app/auth-service/models"
+	"github.com/darren-west/app/oauth-service/auth"
+	"github.com/darren-west/app/oauth-service/config"
+	"github.com/darren-west/app/oauth-service/redirector"
+	"github.com/darren-west/app/oauth-service/redirector/mocks"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/darren-west/app/utils/session"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginRedirectsToReturnTo(t *testing.T) {
+	tests := map[string]struct {
+		returnTo string
+		expected string
+	}{
+		"default":     {returnTo: "", expected: "/home/"},
+		"allowed":     {returnTo: "/app/users/1", expected: "/app/users/1"},
+		"not allowed": {returnTo: "https://evil.com/app/", expected: "/home/"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			tokens := mocks.NewMockTokenExchanger(ctrl)
+			tokens.EXPECT().ExchangeToken(gomock.Any(), gomock.Any()).Return(models.Tokens{AccessToken: "token"}, nil)
+
+			store := sessions.NewCookieStore([]byte("secret"))
+			login := redirector.Login{
+				Store:    store,
+				Tokens:   tokens,
+				ReturnTo: config.ReturnToOptions{DefaultURL: "/home/", AllowedPathPrefixes: []string{"/app/"}},
+			}
+			recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redirect", nil)
+			sess, err := store.Get(request, session.UserSessionName)
+			require.NoError(t, err)
+			if test.returnTo != "" {
+				sess.Values["return_to"] = test.returnTo
+			}
+
+			login.Handle(auth.UserInfo{ID: "1", FirstName: "foo"}, recorder, request)
+
+			assert.Equal(t, http.StatusFound, recorder.Code)
+			assert.Equal(t, test.expected, recorder.Header().Get("Location"))
+			assert.NotContains(t, sess.Values, "return_to")
+		})
+	}
+}
+
+func TestLoginStoresApiToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	tokens := mocks.NewMockTokenExchanger(ctrl)
+	tokens.EXPECT().
+		ExchangeToken(gomock.Any(), jwt.User{ID: "1", FirstName: "foo", LastName: "bar", Email: "foo@bar.com"}).
+		Return(models.Tokens{AccessToken: "signed.jwt.token", RefreshToken: "refresh"}, nil)
+
+	store := sessions.NewCookieStore([]byte("secret"))
+	login := redirector.Login{
+		Store:  store,
+		Tokens: tokens,
+		Token:  config.TokenOptions{CookieName: "api-token", CookieSecure: true},
+	}
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redirect", nil)
+	sess, err := store.Get(request, session.UserSessionName)
+	require.NoError(t, err)
+
+	login.Handle(auth.UserInfo{ID: "1", FirstName: "foo", LastName: "bar", Email: "foo@bar.com"}, recorder, request)
+
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	assert.Equal(t, "signed.jwt.token", sess.Values["api-token"])
+	assert.Equal(t, "refresh", sess.Values["refresh-token"])
+
+	var cookie *http.Cookie
+	for _, c := range recorder.Result().Cookies() {
+		if c.Name == "api-token" {
+			cookie = c
+		}
+	}
+	require.NotNil(t, cookie)
+	assert.Equal(t, "signed.jwt.token", cookie.Value)
+	assert.True(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+}
+
+func TestLoginTokenExchangeFails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	tokens := mocks.NewMockTokenExchanger(ctrl)
+	tokens.EXPECT().ExchangeToken(gomock.Any(), gomock.Any()).Return(models.Tokens{}, errors.New("boom"))
+
+	store := sessions.NewCookieStore([]byte("secret"))
+	login := redirector.Login{Store: store, Tokens: tokens}
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redirect", nil)
+	sess, err := store.Get(request, session.UserSessionName)
+	require.NoError(t, err)
+
+	login.Handle(auth.UserInfo{ID: "1", FirstName: "foo"}, recorder, request)
+
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "Sign in failed")
+	assert.NotContains(t, recorder.Body.String(), "boom")
+	assert.NotContains(t, sess.Values, "api-token")
+	assert.Empty(t, recorder.Header().Get("Set-Cookie"))
+}
+
+func TestLoginUsesTokensFromContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := sessions.NewCookieStore([]byte("secret"))
+	login := redirector.Login{Store: store, Tokens: mocks.NewMockTokenExchanger(ctrl)}
+	recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redirect", nil)
+	request = request.WithContext(redirector.ContextWithTokens(request.Context(), models.Tokens{AccessToken: "exchanged"}))
+	sess, err := store.Get(request, session.UserSessionName)
+	require.NoError(t, err)
+
+	login.Handle(auth.UserInfo{ID: "1", FirstName: "foo"}, recorder, request)
+
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	assert.Equal(t, "exchanged", sess.Values["api-token"])
+}
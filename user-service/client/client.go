@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/darren-west/app/user-service/models"
 	"github.com/darren-west/app/utils/httputil"
@@ -37,6 +38,14 @@ func WithRetryCount(retries int) Option {
 	}
 }
 
+// WithTokenSource sets the source of access tokens to authorize requests with, for services calling the user
+// service as themselves. A bearer token on the request context is used instead when there is one.
+func WithTokenSource(tokens httputil.TokenSource) Option {
+	return func(s *Service) {
+		s.tokens = tokens
+	}
+}
+
 // Option is a function for setting the options in the service.
 type Option func(*Service)
 
@@ -59,6 +68,25 @@ func New(ops ...Option) Service {
 type Service struct {
 	httpClient *resty.Client
 	base       string
+	tokens     httputil.TokenSource
+}
+
+// request returns a request with the context, authorized with the bearer token on the context if there is one.
+// Use httputil.ContextWithBearerToken to set the token. Requests without one are authorized with a token from
+// the token source, if the service has one.
+func (s Service) request(ctx context.Context) (*resty.Request, error) {
+	req := s.httpClient.R().SetContext(ctx)
+	if token, ok := httputil.BearerTokenFromContext(ctx); ok {
+		return req.SetAuthToken(token), nil
+	}
+	if s.tokens != nil {
+		token, err := s.tokens.Token(ctx)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to get access token: {{err}}", err)
+		}
+		req.SetAuthToken(token.Value)
+	}
+	return req, nil
 }
 
 func (s Service) pathf(format string, args ...interface{}) string {
@@ -68,10 +96,13 @@ func (s Service) pathf(format string, args ...interface{}) string {
 // CreateUser creates a user.
 func (s Service) CreateUser(ctx context.Context, user models.UserInfo) (err error) {
 	err = func(ctx context.Context, user models.UserInfo) (err error) {
-		resp, err := s.httpClient.R().
+		req, err := s.request(ctx)
+		if err != nil {
+			return
+		}
+		resp, err := req.
 			SetHeader("Content-Type", "application/json").
 			SetBody(&user).
-			SetContext(ctx).
 			Post(s.pathf("/%s", "users"))
 		if err != nil {
 			return
@@ -90,9 +121,12 @@ func (s Service) CreateUser(ctx context.Context, user models.UserInfo) (err erro
 // GetUser return the user with the id given.
 func (s Service) GetUser(ctx context.Context, id string) (user models.UserInfo, err error) {
 	user, err = func(ctx context.Context, id string) (user models.UserInfo, err error) {
-		resp, err := s.httpClient.R().
+		req, err := s.request(ctx)
+		if err != nil {
+			return
+		}
+		resp, err := req.
 			SetResult(&user).
-			SetContext(ctx).
 			Get(s.pathf("/%s/%s", "users", id))
 		if httpErr := handleError(http.StatusOK, resp); httpErr != nil {
 			return user, httpErr
@@ -107,27 +141,83 @@ func (s Service) GetUser(ctx context.Context, id string) (user models.UserInfo,
 }
 
 // IsNotFoundError returns true if the error is a not found error. i.e. the user is not found.
-func IsNotFoundError(err error) bool {
-	if e, ok := err.(httputil.Error); ok {
-		if e.StatusCode() == http.StatusNotFound {
-			return true
+// Errors returned by the client are wrapped, so the wrapped errors are checked too.
+func IsNotFoundError(err error) (notFound bool) {
+	errwrap.Walk(err, func(err error) {
+		if e, ok := err.(httputil.Error); ok && e.StatusCode() == http.StatusNotFound {
+			notFound = true
+		}
+	})
+	return
+}
+
+// IsConflictError returns true if the error is a conflict error. i.e. the email of the user is used by another
+// user. Errors returned by the client are wrapped, so the wrapped errors are checked too.
+func IsConflictError(err error) (conflict bool) {
+	errwrap.Walk(err, func(err error) {
+		if e, ok := err.(httputil.Error); ok && e.StatusCode() == http.StatusConflict {
+			conflict = true
+		}
+	})
+	return
+}
+
+// Query filters and sorts the users listed. The email and name filters match exactly, or by prefix when they
+// end with *. Sort is the field to sort by, id, firstName, lastName or email, prefixed with - to sort in
+// descending order. Limit is the number of users fetched in each page, the service default is used when it is 0.
+type Query struct {
+	Email     string
+	FirstName string
+	LastName  string
+	Sort      string
+	Limit     int
+}
+
+func (q Query) values(next string) map[string]string {
+	values := map[string]string{}
+	for param, value := range map[string]string{
+		models.QueryEmail:     q.Email,
+		models.QueryFirstName: q.FirstName,
+		models.QueryLastName:  q.LastName,
+		models.QuerySort:      q.Sort,
+		models.QueryNext:      next,
+	} {
+		if value != "" {
+			values[param] = value
 		}
 	}
-	return false
+	if q.Limit > 0 {
+		values[models.QueryLimit] = strconv.Itoa(q.Limit)
+	}
+	return values
+}
+
+// ListUsers returns all the users that match the query, fetching every page.
+func (s Service) ListUsers(ctx context.Context, query Query) (users []models.UserInfo, err error) {
+	users = []models.UserInfo{}
+	it := s.Users(query)
+	for it.Next(ctx) {
+		users = append(users, it.User())
+	}
+	return users, it.Err()
 }
 
-// ListUsers returns all the users.
-// TODO: implement filtering.
-func (s Service) ListUsers(ctx context.Context) (users []models.UserInfo, err error) {
-	users, err = func(ctx context.Context) (users []models.UserInfo, err error) {
-		resp, err := s.httpClient.R().
+// ListPage returns a page of the users that match the query. The first page is listed with an empty next token,
+// the following pages with the token returned with the previous page. The token is empty on the last page.
+func (s Service) ListPage(ctx context.Context, query Query, next string) (users []models.UserInfo, token string, err error) {
+	users, token, err = func(ctx context.Context) (users []models.UserInfo, token string, err error) {
+		req, err := s.request(ctx)
+		if err != nil {
+			return
+		}
+		resp, err := req.
+			SetQueryParams(query.values(next)).
 			SetResult(&users).
-			SetContext(ctx).
 			Get(s.pathf("/%s", "users"))
 		if httpErr := handleError(http.StatusOK, resp); httpErr != nil {
-			return nil, httpErr
+			return nil, "", httpErr
 		}
-		return
+		return users, resp.Header().Get(models.NextPageHeader), nil
 	}(ctx)
 	if err != nil {
 		err = errwrap.Wrapf("list users failed: {{err}}", err)
@@ -136,13 +226,55 @@ func (s Service) ListUsers(ctx context.Context) (users []models.UserInfo, err er
 	return
 }
 
+// Users returns an iterator over the users that match the query. Pages are fetched as the iterator reaches them.
+func (s Service) Users(query Query) *UserIterator {
+	return &UserIterator{service: s, query: query}
+}
+
+// UserIterator iterates over the users that match a query. Call Next to move to each user in turn and then User
+// to get it. Check Err once Next returns false.
+type UserIterator struct {
+	service Service
+	query   Query
+	users   []models.UserInfo
+	next    string
+	fetched bool
+	err     error
+}
+
+// Next moves to the next user, fetching the next page when needed. It returns false when there are no more users
+// or a page failed to be fetched.
+func (it *UserIterator) Next(ctx context.Context) bool {
+	if len(it.users) > 0 {
+		it.users = it.users[1:]
+	}
+	for len(it.users) == 0 && it.err == nil && (!it.fetched || it.next != "") {
+		it.users, it.next, it.err = it.service.ListPage(ctx, it.query, it.next)
+		it.fetched = true
+	}
+	return len(it.users) > 0 && it.err == nil
+}
+
+// User returns the current user.
+func (it *UserIterator) User() models.UserInfo {
+	return it.users[0]
+}
+
+// Err returns the error fetching a page, if there was one.
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
 // UpdateUser updates the user. The ID in the user is used to update the user in the service.
 func (s Service) UpdateUser(ctx context.Context, user models.UserInfo) (err error) {
 	err = func(ctx context.Context, user models.UserInfo) (err error) {
-		resp, err := s.httpClient.R().
+		req, err := s.request(ctx)
+		if err != nil {
+			return
+		}
+		resp, err := req.
 			SetHeader("Content-Type", "application/json").
 			SetBody(&user).
-			SetContext(ctx).
 			Put(s.pathf("/%s/%s", "users", user.ID))
 		if httpErr := handleError(http.StatusOK, resp); httpErr != nil {
 			return httpErr
@@ -158,7 +290,11 @@ func (s Service) UpdateUser(ctx context.Context, user models.UserInfo) (err erro
 // DeleteUser removes the user in the service.
 func (s Service) DeleteUser(ctx context.Context, id string) (err error) {
 	err = func(ctx context.Context, id string) (err error) {
-		resp, err := s.httpClient.R().SetContext(ctx).Delete(s.pathf("/%s/%s", "users", id))
+		req, err := s.request(ctx)
+		if err != nil {
+			return
+		}
+		resp, err := req.Delete(s.pathf("/%s/%s", "users", id))
 		if httpErr := handleError(http.StatusOK, resp); httpErr != nil {
 			return httpErr
 		}
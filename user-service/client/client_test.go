@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"testing"
 
 	"github.com/darren-west/app/user-service/client"
 	"github.com/darren-west/app/user-service/models"
+	"github.com/darren-west/app/utils/httputil"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -76,12 +78,78 @@ func (cs *ClientSuite) TestListUsers() {
 		return
 	}
 	s := client.New(client.WithRoundTripper(RoundTripFunc(fn)))
-	users, err := s.ListUsers(context.TODO())
+	users, err := s.ListUsers(context.TODO(), client.Query{})
 	cs.Assert().NoError(err)
 	cs.Assert().Len(users, 2)
 	cs.Assert().Equal(expected, users)
 }
 
+func (cs *ClientSuite) TestListUsersPages() {
+	pages := map[string][]models.UserInfo{
+		"":      {{ID: "1"}, {ID: "2"}},
+		"page2": {{ID: "3"}},
+		"page3": {},
+	}
+	nextPages := map[string]string{"": "page2", "page2": "page3"}
+	fn := func(r *http.Request) (resp *http.Response, err error) {
+		query := r.URL.Query()
+		cs.Assert().Equal("Sm*", query.Get("lastName"))
+		cs.Assert().Equal("-email", query.Get("sort"))
+		cs.Assert().Equal("2", query.Get("limit"))
+		cs.Assert().Empty(query.Get("email"))
+		next := query.Get("next")
+		resp = new(http.Response)
+		resp.StatusCode = http.StatusOK
+		buf := &bytes.Buffer{}
+		cs.Require().NoError(json.NewEncoder(buf).Encode(pages[next]))
+		resp.Body = ioutil.NopCloser(buf)
+		resp.Header = make(map[string][]string)
+		resp.Header.Set("Content-Type", "application/json")
+		if nextPages[next] != "" {
+			resp.Header.Set(models.NextPageHeader, nextPages[next])
+		}
+		return
+	}
+	s := client.New(client.WithRoundTripper(RoundTripFunc(fn)))
+	query := client.Query{LastName: "Sm*", Sort: "-email", Limit: 2}
+
+	users, next, err := s.ListPage(context.TODO(), query, "")
+	cs.Require().NoError(err)
+	cs.Assert().Equal(pages[""], users)
+	cs.Assert().Equal("page2", next)
+
+	users, err = s.ListUsers(context.TODO(), query)
+	cs.Require().NoError(err)
+	cs.Assert().Equal([]models.UserInfo{{ID: "1"}, {ID: "2"}, {ID: "3"}}, users)
+}
+
+func (cs *ClientSuite) TestUsersIteratorError() {
+	calls := 0
+	fn := func(r *http.Request) (resp *http.Response, err error) {
+		calls++
+		resp = new(http.Response)
+		resp.Header = make(map[string][]string)
+		if calls > 1 {
+			resp.StatusCode = http.StatusBadRequest
+			resp.Body = ioutil.NopCloser(bytes.NewBufferString("invalid page: cursor is malformed"))
+			return
+		}
+		resp.StatusCode = http.StatusOK
+		resp.Body = ioutil.NopCloser(bytes.NewBufferString(`[{"ID":"1"}]`))
+		resp.Header.Set("Content-Type", "application/json")
+		resp.Header.Set(models.NextPageHeader, "page2")
+		return
+	}
+	it := client.New(client.WithRoundTripper(RoundTripFunc(fn))).Users(client.Query{})
+
+	cs.Require().True(it.Next(context.TODO()))
+	cs.Assert().Equal("1", it.User().ID)
+	cs.Assert().False(it.Next(context.TODO()))
+	cs.Assert().EqualError(it.Err(), "list users failed: invalid page: cursor is malformed, code 400")
+	cs.Assert().False(it.Next(context.TODO()), "the iterator should stop after an error")
+	cs.Assert().Equal(2, calls)
+}
+
 func (cs ClientSuite) TestUpdateUser() {
 	expected := models.UserInfo{ID: "1", FirstName: "foo", LastName: "bar", Email: "email@email.com"}
 	fn := func(r *http.Request) (resp *http.Response, err error) {
@@ -152,6 +220,83 @@ func (cs ClientSuite) TestGetUser() {
 
 	cs.Assert().Equal(expected, user)
 }
+
+func (cs ClientSuite) TestBearerTokenFromContext() {
+	fn := func(r *http.Request) (resp *http.Response, err error) {
+		cs.Assert().Equal("Bearer foo", r.Header.Get("Authorization"))
+		resp = new(http.Response)
+		resp.StatusCode = http.StatusOK
+		resp.Body = ioutil.NopCloser(&bytes.Buffer{})
+		return
+	}
+	s := client.New(client.WithRoundTripper(RoundTripFunc(fn)))
+	cs.Assert().NoError(s.DeleteUser(httputil.ContextWithBearerToken(context.TODO(), "foo"), "123"))
+}
+
+func (cs ClientSuite) TestTokenSource() {
+	var header string
+	fn := func(r *http.Request) (resp *http.Response, err error) {
+		header = r.Header.Get("Authorization")
+		resp = new(http.Response)
+		resp.StatusCode = http.StatusOK
+		resp.Body = ioutil.NopCloser(&bytes.Buffer{})
+		return
+	}
+	tokens := httputil.TokenSourceFunc(func(context.Context) (httputil.AccessToken, error) {
+		return httputil.AccessToken{Value: "service"}, nil
+	})
+	s := client.New(client.WithRoundTripper(RoundTripFunc(fn)), client.WithTokenSource(tokens))
+
+	cs.Require().NoError(s.DeleteUser(context.TODO(), "123"))
+	cs.Assert().Equal("Bearer service", header)
+
+	cs.Require().NoError(s.DeleteUser(httputil.ContextWithBearerToken(context.TODO(), "user"), "123"))
+	cs.Assert().Equal("Bearer user", header, "the token on the context should be used over the token source")
+}
+
+func (cs ClientSuite) TestTokenSourceError() {
+	fn := func(r *http.Request) (resp *http.Response, err error) {
+		cs.Fail("no request should be made without a token")
+		return
+	}
+	tokens := httputil.TokenSourceFunc(func(context.Context) (httputil.AccessToken, error) {
+		return httputil.AccessToken{}, errors.New("boom")
+	})
+	s := client.New(client.WithRoundTripper(RoundTripFunc(fn)), client.WithTokenSource(tokens))
+
+	_, err := s.GetUser(context.TODO(), "123")
+	cs.Assert().EqualError(err, "get user failed: failed to get access token: boom")
+}
+
+func (cs ClientSuite) TestGetUserNotFound() {
+	fn := func(r *http.Request) (resp *http.Response, err error) {
+		resp = new(http.Response)
+		resp.StatusCode = http.StatusNotFound
+		resp.Body = ioutil.NopCloser(bytes.NewBuffer([]byte("user not found")))
+		return
+	}
+	s := client.New(client.WithRoundTripper(RoundTripFunc(fn)))
+	_, err := s.GetUser(context.TODO(), "123")
+	cs.Assert().EqualError(err, "get user failed: user not found, code 404")
+	cs.Assert().True(client.IsNotFoundError(err))
+	cs.Assert().False(client.IsNotFoundError(errors.New("user not found")))
+}
+
+func (cs ClientSuite) TestCreateUserConflict() {
+	fn := func(r *http.Request) (resp *http.Response, err error) {
+		resp = new(http.Response)
+		resp.StatusCode = http.StatusConflict
+		resp.Body = ioutil.NopCloser(bytes.NewBuffer([]byte("duplicate user")))
+		return
+	}
+	s := client.New(client.WithRoundTripper(RoundTripFunc(fn)))
+	err := s.CreateUser(context.TODO(), models.UserInfo{ID: "123", Email: "foo@bar.com"})
+	cs.Assert().EqualError(err, "create user failed: duplicate user, code 409")
+	cs.Assert().True(client.IsConflictError(err))
+	cs.Assert().False(client.IsConflictError(errors.New("duplicate user")))
+	cs.Assert().False(client.IsNotFoundError(err))
+}
+
 func (cs ClientSuite) decodeUser(r *http.Request) (user models.UserInfo) {
 	cs.Require().NoError(json.NewDecoder(r.Body).Decode(&user))
 	return
@@ -0,0 +1,151 @@
+package config_test
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/darren-west/app/user-service/config"
+	"github.com/darren-west/app/user-service/config/mocks"
+	utilconfig "github.com/darren-west/app/utils/config"
+	"github.com/darren-west/app/utils/validator"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	testData := `{
+		"BindAddress": ":8080",
+		"Log": {"Level": "debug", "Format": "json"},
+		"Mongo": {"ConnectionString": "mongodb://database", "DatabaseName": "users"},
+		"Tokens": {"Issuer": "https://auth.example.com", "Audience": "app"}
+	}`
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	fileReader := mocks.NewMockFileReader(ctrl)
+	fileReader.EXPECT().Read("config.json").Return([]byte(testData), nil)
+
+	reader, err := config.NewReader(fileReader)
+	require.NoError(t, err)
+	options, err := reader.Read("config.json")
+	require.NoError(t, err)
+
+	expected := config.Default()
+	expected.BindAddress = ":8080"
+	expected.Log = config.LogOptions{Level: "debug", Format: config.LogFormatJSON}
+	expected.Mongo.ConnectionString = "mongodb://database"
+	expected.Mongo.DatabaseName = "users"
+	expected.Tokens.Issuer = "https://auth.example.com"
+	expected.Tokens.Audience = "app"
+	assert.Equal(t, expected, options)
+}
+
+func TestReaderOverrides(t *testing.T) {
+	env := map[string]string{
+		"USERS_MONGO_CONNECTION_STRING": "mongodb://secret@database",
+		"USERS_LOG_LEVEL":               "warn",
+		"USERS_TLS_CERT_PATH":           "/tls/tls.crt",
+		"USERS_TLS_KEY_PATH":            "/tls/tls.key",
+	}
+	for key, value := range env {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	fileReader := mocks.NewMockFileReader(ctrl)
+	fileReader.EXPECT().Read("config.json").Return([]byte(`{"BindAddress": ":8080", "Log": {"Level": "debug"}}`), nil)
+
+	fs := flag.NewFlagSet("user-service", flag.ContinueOnError)
+	flags, err := utilconfig.NewFlags(fs, &config.Options{})
+	require.NoError(t, err)
+	require.NoError(t, fs.Parse([]string{"--log-level", "error", "--mongo-database-name", "users"}))
+
+	reader, err := config.NewReader(fileReader)
+	require.NoError(t, err)
+	options, err := reader.WithFlags(flags).Read("config.json")
+	require.NoError(t, err)
+	assert.Equal(t, ":8080", options.BindAddress)
+	assert.Equal(t, "error", options.Log.Level, "the flags should override the environment")
+	assert.Equal(t, "mongodb://secret@database", options.Mongo.ConnectionString, "the environment should override the defaults")
+	assert.Equal(t, "users", options.Mongo.DatabaseName)
+	assert.Equal(t, config.TLSOptions{CertPath: "/tls/tls.crt", KeyPath: "/tls/tls.key"}, options.TLS)
+	assert.True(t, options.TLS.Enabled())
+}
+
+func TestReaderWithoutFile(t *testing.T) {
+	reader, err := config.NewReader(mocks.NewMockFileReader(gomock.NewController(t)))
+	require.NoError(t, err)
+
+	options, err := reader.Read("")
+	require.NoError(t, err)
+	assert.Equal(t, config.Default(), options)
+}
+
+func TestReaderFileError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	fileReader := mocks.NewMockFileReader(ctrl)
+	fileReader.EXPECT().Read("config.json").Return(nil, errors.New("boom"))
+
+	reader, err := config.NewReader(fileReader)
+	require.NoError(t, err)
+	_, err = reader.Read("config.json")
+	assert.EqualError(t, err, "failed to read file: boom")
+}
+
+func TestReaderInvalid(t *testing.T) {
+	os.Setenv("USERS_LOG_FORMAT", "xml")
+	defer os.Unsetenv("USERS_LOG_FORMAT")
+	reader, err := config.NewReader(mocks.NewMockFileReader(gomock.NewController(t)))
+	require.NoError(t, err)
+
+	_, err = reader.Read("")
+	assert.EqualError(t, err, "configuration invalid: Log: invalid log format xml, expecting text or json")
+}
+
+func TestNewReaderNil(t *testing.T) {
+	_, err := config.NewReader(nil)
+	assert.EqualError(t, err, "file reader is nil")
+}
+
+func TestReaderMissingRequiredField(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	fileReader := mocks.NewMockFileReader(ctrl)
+	fileReader.EXPECT().Read("config.json").Return([]byte(`{"Tokens": {"JWKSURL": ""}}`), nil)
+
+	reader, err := config.NewReader(fileReader)
+	require.NoError(t, err)
+	_, err = reader.Read("config.json")
+	assert.EqualError(t, err, "configuration invalid: Tokens.JWKSURL: required field missing")
+}
+
+func TestOptionsInvalid(t *testing.T) {
+	tests := map[string]struct {
+		modify      func(*config.Options)
+		expectedErr string
+	}{
+		"bind address":  {modify: func(o *config.Options) { o.BindAddress = "" }, expectedErr: "BindAddress: required field missing"},
+		"repository":    {modify: func(o *config.Options) { o.Repository = "disk" }, expectedErr: "invalid repository disk, expecting mongo, sql or memory"},
+		"sql":           {modify: func(o *config.Options) { o.Repository = config.RepositorySQL }, expectedErr: "required field sql data source missing"},
+		"log level":     {modify: func(o *config.Options) { o.Log.Level = "loud" }, expectedErr: `Log: invalid log level: not a valid logrus Level: "loud"`},
+		"mongo":         {modify: func(o *config.Options) { o.Mongo.CollectionName = "" }, expectedErr: "Mongo.CollectionName: required field missing"},
+		"read concern":  {modify: func(o *config.Options) { o.Mongo.ReadConcern = "eventual" }, expectedErr: "Mongo: invalid mongo options: invalid read concern eventual"},
+		"pool size":     {modify: func(o *config.Options) { o.Mongo.MinPoolSize, o.Mongo.MaxPoolSize = 10, 5 }, expectedErr: "Mongo: invalid mongo options: min pool size 10 is larger than the max pool size 5"},
+		"jwks url":      {modify: func(o *config.Options) { o.Tokens.JWKSURL = "" }, expectedErr: "Tokens.JWKSURL: required field missing"},
+		"jwks url path": {modify: func(o *config.Options) { o.Tokens.JWKSURL = "/jwks.json" }, expectedErr: `Tokens.JWKSURL: invalid url "/jwks.json"`},
+		"introspection": {modify: func(o *config.Options) { o.Tokens.IntrospectionURL = "http://auth-service/introspect" }, expectedErr: "Tokens: tokens client id and client secret are required to introspect tokens"},
+		"tls key":       {modify: func(o *config.Options) { o.TLS.CertPath = "tls.crt" }, expectedErr: "TLS: tls cert path and key path must be set together"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := config.Default()
+			require.NoError(t, validator.Default.IsValid(&o))
+			test.modify(&o)
+			assert.EqualError(t, validator.Default.IsValid(&o), test.expectedErr)
+		})
+	}
+}
@@ -3,11 +3,17 @@ package controller
 // TODO: do some error handling to test for duplicate entires and other common errors. Print nicer messages
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/darren-west/app/utils/httputil"
+	"github.com/darren-west/app/utils/jwt"
 
 	"github.com/darren-west/app/user-service/models"
 	"github.com/darren-west/app/user-service/repository"
@@ -18,19 +24,63 @@ func handleError(err error) httputil.Error {
 	if repository.IsErrUserNotFound(err) {
 		return httputil.NewError(http.StatusNotFound).WithError(err)
 	}
+	if repository.IsErrDuplicateUser(err) {
+		return httputil.NewError(http.StatusConflict).WithError(err)
+	}
 	return httputil.NewError(http.StatusInternalServerError).WithError(err)
 }
 
-func NewHandler(us UserRepository, r *httprouter.Router) http.Handler {
+// NewHandler registers the user routes on the router. Every route requires a bearer token that the token reader
+// accepts. Users can create, read and update their own record, other users records need the scope for the route.
+func NewHandler(us UserRepository, tokens httputil.TokenReader, r *httprouter.Router) http.Handler {
 	h := Handler{UserRepository: us, UserValidator: models.UserValidator{}}
-	r.GET("/users/:id", UseErrorHandle(h.GetUser))
-	r.GET("/users", UseErrorHandle(h.ListUsers))
-	r.DELETE("/users/:id", UseErrorHandle(h.DeleteUser))
-	r.PUT("/users/:id", UseErrorHandle(h.UpdateUser))
-	r.POST("/users", UseErrorHandle(h.CreateUser))
+	auth := func(policy httputil.Policy, f ErrorHandle) httprouter.Handle {
+		return httputil.Authenticate(tokens, httputil.Authorize(policy, UseErrorHandle(f)))
+	}
+	r.GET("/users/:id", auth(httputil.AnyOf(ownUser, httputil.Scopes(models.ScopeReadUsers)), h.GetUser))
+	r.GET("/users", auth(httputil.Scopes(models.ScopeReadUsers), h.ListUsers))
+	r.DELETE("/users/:id", auth(httputil.Scopes(models.ScopeDeleteUsers), h.DeleteUser))
+	r.PUT("/users/:id", auth(httputil.AnyOf(ownUser, httputil.Scopes(models.ScopeWriteUsers)), h.UpdateUser))
+	// the user being created is in the body, so the handler checks it.
+	r.POST("/users", auth(anyone, h.CreateUser))
 	return ensureContentType(r)
 }
 
+// ownUser allows the user the route is for.
+func ownUser(claims *jwt.Claims, r *http.Request, ps httprouter.Params) bool {
+	return isCaller(claims, ps.ByName("id"))
+}
+
+// anyone allows every authenticated caller.
+func anyone(*jwt.Claims, *http.Request, httprouter.Params) bool {
+	return true
+}
+
+func isCaller(claims *jwt.Claims, id string) bool {
+	return claims.Subject != "" && claims.Subject == id
+}
+
+// callerClaims returns the claims of the caller, put on the context by the authentication of the routes. Handlers
+// called without it get empty claims, which have no scopes.
+func callerClaims(r *http.Request) *jwt.Claims {
+	if claims, ok := httputil.ClaimsFromContext(r.Context()); ok {
+		return claims
+	}
+	return &jwt.Claims{}
+}
+
+func sameRoles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func ensureContentType(h http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if (r.Method == http.MethodPost || r.Method == http.MethodPut) && r.Header.Get("Content-Type") != "application/json" {
@@ -47,7 +97,7 @@ type Handler struct {
 }
 
 func (h Handler) GetUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) httputil.Error {
-	user, err := h.UserRepository.FindUser(repository.NewMatcher().WithID(ps.ByName("id")))
+	user, err := h.UserRepository.FindUser(r.Context(), repository.Eq(repository.FieldID, ps.ByName("id")))
 	if err != nil {
 		return handleError(err)
 	}
@@ -57,17 +107,74 @@ func (h Handler) GetUser(w http.ResponseWriter, r *http.Request, ps httprouter.P
 	return nil
 }
 
+// ListUsers lists a page of the users that match the query parameters, see models.QueryEmail for the parameters.
 func (h Handler) ListUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) httputil.Error {
-	users, err := h.UserRepository.ListUsers(repository.EmptyMatcher)
+	query, page, err := listQuery(r.URL.Query())
+	if err != nil {
+		return httputil.NewError(http.StatusBadRequest).WithError(err)
+	}
+	users, next, err := h.UserRepository.ListUsers(r.Context(), query, page)
+	if repository.IsErrInvalidQuery(err) || repository.IsErrInvalidPage(err) {
+		return httputil.NewError(http.StatusBadRequest).WithError(err)
+	}
 	if err != nil {
 		return httputil.NewError(http.StatusInternalServerError).WithError(err)
 	}
+	if users == nil {
+		users = []models.UserInfo{}
+	}
+	if next != "" {
+		w.Header().Set(models.NextPageHeader, next)
+	}
 	if err = encodeJSON(w, &users, isPretty(r)); err != nil {
 		return httputil.NewError(http.StatusInternalServerError).WithError(err)
 	}
 	return nil
 }
 
+// queryFields are the query parameters that filter and sort by a field, with the field.
+var queryFields = map[string]repository.Field{
+	"id":                  repository.FieldID,
+	models.QueryEmail:     repository.FieldEmail,
+	models.QueryFirstName: repository.FieldFirstName,
+	models.QueryLastName:  repository.FieldLastName,
+}
+
+// listQuery returns the query and page for the list query parameters.
+func listQuery(values url.Values) (q repository.Query, page repository.Page, err error) {
+	var conditions []repository.Query
+	for _, param := range []string{models.QueryEmail, models.QueryFirstName, models.QueryLastName} {
+		value := values.Get(param)
+		switch {
+		case value == "":
+		case strings.HasSuffix(value, "*"):
+			conditions = append(conditions, repository.Prefix(queryFields[param], strings.TrimSuffix(value, "*")))
+		default:
+			conditions = append(conditions, repository.Eq(queryFields[param], value))
+		}
+	}
+	q = repository.And(conditions...)
+	if sort := values.Get(models.QuerySort); sort != "" {
+		page.Descending = strings.HasPrefix(sort, "-")
+		field, ok := queryFields[strings.TrimPrefix(sort, "-")]
+		if !ok {
+			return q, page, fmt.Errorf("invalid sort %s", sort)
+		}
+		page.SortField = field
+	}
+	page.Limit = models.DefaultPageLimit
+	if limit := values.Get(models.QueryLimit); limit != "" {
+		if page.Limit, err = strconv.Atoi(limit); err != nil || page.Limit < 1 {
+			return q, page, fmt.Errorf("invalid limit %s", limit)
+		}
+		if page.Limit > models.MaxPageLimit {
+			page.Limit = models.MaxPageLimit
+		}
+	}
+	page.Cursor = values.Get(models.QueryNext)
+	return
+}
+
 func isPretty(r *http.Request) (pretty bool) {
 	_, pretty = r.URL.Query()["pretty"]
 	return
@@ -82,8 +189,8 @@ func encodeJSON(w io.Writer, i interface{}, pretty bool) error {
 }
 
 func (h Handler) DeleteUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) httputil.Error {
-	if err := h.UserRepository.RemoveUser(repository.NewMatcher().WithID(ps.ByName("id"))); err != nil {
-		return httputil.NewError(http.StatusInternalServerError).WithError(err)
+	if err := h.UserRepository.RemoveUser(r.Context(), repository.Eq(repository.FieldID, ps.ByName("id"))); err != nil {
+		return handleError(err)
 	}
 	return nil
 }
@@ -99,7 +206,16 @@ func (h Handler) UpdateUser(w http.ResponseWriter, r *http.Request, ps httproute
 	if err := h.UserValidator.IsValid(user); err != nil {
 		return httputil.NewError(http.StatusBadRequest).WithError(err)
 	}
-	if err := h.UserRepository.UpdateUser(user); err != nil {
+	if !callerClaims(r).HasScope(models.ScopeWriteRoles) {
+		existing, err := h.UserRepository.FindUser(r.Context(), repository.Eq(repository.FieldID, user.ID))
+		if err != nil {
+			return handleError(err)
+		}
+		if !sameRoles(existing.Roles, user.Roles) {
+			return httputil.NewError(http.StatusForbidden).WithMessage("changing roles requires the %s scope", models.ScopeWriteRoles)
+		}
+	}
+	if err := h.UserRepository.UpdateUser(r.Context(), user); err != nil {
 		return handleError(err)
 	}
 	return nil
@@ -113,8 +229,15 @@ func (h Handler) CreateUser(w http.ResponseWriter, r *http.Request, ps httproute
 	if err := h.UserValidator.IsValid(user); err != nil {
 		return httputil.NewError(http.StatusBadRequest).WithError(err)
 	}
-	if err := h.UserRepository.CreateUser(user); err != nil {
-		return httputil.NewError(http.StatusInternalServerError).WithError(err)
+	claims := callerClaims(r)
+	if !isCaller(claims, user.ID) && !claims.HasScope(models.ScopeWriteUsers) {
+		return httputil.NewError(http.StatusForbidden).WithMessage("forbidden")
+	}
+	if len(user.Roles) != 0 && !claims.HasScope(models.ScopeWriteRoles) {
+		return httputil.NewError(http.StatusForbidden).WithMessage("changing roles requires the %s scope", models.ScopeWriteRoles)
+	}
+	if err := h.UserRepository.CreateUser(r.Context(), user); err != nil {
+		return handleError(err)
 	}
 	w.WriteHeader(http.StatusCreated)
 	return nil
@@ -135,9 +258,9 @@ func UseErrorHandle(f ErrorHandle) httprouter.Handle {
 //go:generate mockgen -destination ./mocks/mock_service.go -package mocks github.com/darren-west/app/user-service/controller UserRepository
 
 type UserRepository interface {
-	FindUser(repository.Matcher) (models.UserInfo, error)
-	ListUsers(repository.Matcher) ([]models.UserInfo, error)
-	RemoveUser(repository.Matcher) error
-	UpdateUser(models.UserInfo) error
-	CreateUser(models.UserInfo) error
+	FindUser(context.Context, repository.Query) (models.UserInfo, error)
+	ListUsers(context.Context, repository.Query, repository.Page) ([]models.UserInfo, string, error)
+	RemoveUser(context.Context, repository.Query) error
+	UpdateUser(context.Context, models.UserInfo) error
+	CreateUser(context.Context, models.UserInfo) error
 }
@@ -2,17 +2,21 @@ package controller_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/darren-west/app/user-service/controller"
 	"github.com/darren-west/app/user-service/controller/mocks"
 	"github.com/darren-west/app/user-service/models"
 	"github.com/darren-west/app/user-service/repository"
+	"github.com/darren-west/app/utils/httputil"
+	"github.com/darren-west/app/utils/jwt"
 	"github.com/golang/mock/gomock"
 	"github.com/julienschmidt/httprouter"
 	"github.com/stretchr/testify/suite"
@@ -27,15 +31,32 @@ type HandlerSuite struct {
 	MockUserRepository *mocks.MockUserRepository
 	http.Handler
 	*httprouter.Router
+	Subject string
+	Scope   string
 }
 
 func (hs *HandlerSuite) SetupTest() {
 	hs.MockUserRepository = mocks.NewMockUserRepository(gomock.NewController(hs.T()))
-	hs.Handler = controller.NewHandler(hs.MockUserRepository, httprouter.New())
+	hs.Handler = controller.NewHandler(hs.MockUserRepository, subjectReader{}, httprouter.New())
+	hs.Subject = "12345"
+	hs.Scope = ""
+}
+
+// subjectReader accepts any token, the token is the subject and scope separated by a semicolon.
+type subjectReader struct{}
+
+func (subjectReader) Read(token jwt.Token) (*jwt.Claims, error) {
+	parts := strings.SplitN(token.String(), ";", 2)
+	claims := &jwt.Claims{Subject: parts[0]}
+	if len(parts) == 2 {
+		claims.Scope = parts[1]
+	}
+	return claims, nil
 }
 
 func (hs *HandlerSuite) TestGetUser() {
-	hs.MockUserRepository.EXPECT().FindUser(repository.NewMatcher().WithID("1234")).Return(models.UserInfo{ID: "1234", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}, nil)
+	hs.Subject = "1234"
+	hs.MockUserRepository.EXPECT().FindUser(gomock.Any(), repository.Eq(repository.FieldID, "1234")).Return(models.UserInfo{ID: "1234", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}, nil)
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodGet, "/users/1234", nil)
@@ -46,7 +67,8 @@ func (hs *HandlerSuite) TestGetUser() {
 }
 
 func (hs *HandlerSuite) TestGetUserPretty() {
-	hs.MockUserRepository.EXPECT().FindUser(repository.NewMatcher().WithID("1234")).Return(models.UserInfo{ID: "1234", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}, nil)
+	hs.Subject = "1234"
+	hs.MockUserRepository.EXPECT().FindUser(gomock.Any(), repository.Eq(repository.FieldID, "1234")).Return(models.UserInfo{ID: "1234", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}, nil)
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodGet, "/users/1234?pretty", nil)
@@ -57,7 +79,8 @@ func (hs *HandlerSuite) TestGetUserPretty() {
 }
 
 func (hs *HandlerSuite) TestGetUserNotFound() {
-	hs.MockUserRepository.EXPECT().FindUser(repository.NewMatcher().WithID("1234")).Return(models.UserInfo{}, errors.New("user not found"))
+	hs.Subject = "1234"
+	hs.MockUserRepository.EXPECT().FindUser(gomock.Any(), repository.Eq(repository.FieldID, "1234")).Return(models.UserInfo{}, errors.New("user not found"))
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodGet, "/users/1234", nil)
@@ -68,7 +91,8 @@ func (hs *HandlerSuite) TestGetUserNotFound() {
 }
 
 func (hs *HandlerSuite) TestGetUserError() {
-	hs.MockUserRepository.EXPECT().FindUser(repository.NewMatcher().WithID("1234")).Return(models.UserInfo{}, errors.New("boom"))
+	hs.Subject = "1234"
+	hs.MockUserRepository.EXPECT().FindUser(gomock.Any(), repository.Eq(repository.FieldID, "1234")).Return(models.UserInfo{}, errors.New("boom"))
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodGet, "/users/1234", nil)
@@ -79,11 +103,12 @@ func (hs *HandlerSuite) TestGetUserError() {
 }
 
 func (hs *HandlerSuite) TestListUsers() {
+	hs.Scope = models.ScopeReadUsers
 	testUsers := []models.UserInfo{
 		models.UserInfo{ID: "123", FirstName: "foo", LastName: "bar", Email: "foo@email.com"},
 		models.UserInfo{ID: "1234", FirstName: "bar", LastName: "foo", Email: "bar@email.com"},
 	}
-	hs.MockUserRepository.EXPECT().ListUsers(repository.EmptyMatcher).Return(testUsers, nil)
+	hs.MockUserRepository.EXPECT().ListUsers(gomock.Any(), repository.All(), firstPage).Return(testUsers, "", nil)
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodGet, "/users", nil)
@@ -94,11 +119,12 @@ func (hs *HandlerSuite) TestListUsers() {
 }
 
 func (hs *HandlerSuite) TestListUsersPretty() {
+	hs.Scope = models.ScopeReadUsers
 	testUsers := []models.UserInfo{
 		models.UserInfo{ID: "123", FirstName: "foo", LastName: "bar", Email: "foo@email.com"},
 		models.UserInfo{ID: "1234", FirstName: "bar", LastName: "foo", Email: "bar@email.com"},
 	}
-	hs.MockUserRepository.EXPECT().ListUsers(repository.EmptyMatcher).Return(testUsers, nil)
+	hs.MockUserRepository.EXPECT().ListUsers(gomock.Any(), repository.All(), firstPage).Return(testUsers, "", nil)
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodGet, "/users?pretty", nil)
@@ -109,7 +135,8 @@ func (hs *HandlerSuite) TestListUsersPretty() {
 }
 
 func (hs *HandlerSuite) TestListUsersError() {
-	hs.MockUserRepository.EXPECT().ListUsers(repository.EmptyMatcher).Return(nil, errors.New("boom"))
+	hs.Scope = models.ScopeReadUsers
+	hs.MockUserRepository.EXPECT().ListUsers(gomock.Any(), repository.All(), firstPage).Return(nil, "", errors.New("boom"))
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodGet, "/users", nil)
@@ -120,7 +147,8 @@ func (hs *HandlerSuite) TestListUsersError() {
 }
 
 func (hs *HandlerSuite) TestListUsersEmpty() {
-	hs.MockUserRepository.EXPECT().ListUsers(repository.EmptyMatcher).Return([]models.UserInfo{}, nil)
+	hs.Scope = models.ScopeReadUsers
+	hs.MockUserRepository.EXPECT().ListUsers(gomock.Any(), repository.All(), firstPage).Return([]models.UserInfo{}, "", nil)
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodGet, "/users", nil)
@@ -130,8 +158,87 @@ func (hs *HandlerSuite) TestListUsersEmpty() {
 	hs.Assert().Equal("[]\n", recoder.Body.String())
 }
 
+var firstPage = repository.Page{Limit: models.DefaultPageLimit}
+
+func (hs *HandlerSuite) TestListUsersQuery() {
+	hs.Scope = models.ScopeReadUsers
+	query := repository.And(
+		repository.Eq(repository.FieldEmail, "foo@email.com"),
+		repository.Prefix(repository.FieldLastName, "Sm"),
+	)
+	page := repository.Page{SortField: repository.FieldLastName, Descending: true, Limit: 10, Cursor: "cursor"}
+	testUsers := []models.UserInfo{{ID: "123", FirstName: "foo", LastName: "Smith", Email: "foo@email.com"}}
+	hs.MockUserRepository.EXPECT().ListUsers(gomock.Any(), query, page).Return(testUsers, "next-cursor", nil)
+
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodGet, "/users?email=foo@email.com&lastName=Sm*&sort=-lastName&limit=10&next=cursor", nil)
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusOK, recoder.Code)
+	hs.Assert().Equal("next-cursor", recoder.Header().Get(models.NextPageHeader))
+	hs.Assert().Equal("[{\"ID\":\"123\",\"FirstName\":\"foo\",\"LastName\":\"Smith\",\"Email\":\"foo@email.com\"}]\n", recoder.Body.String())
+}
+
+func (hs *HandlerSuite) TestListUsersLastPage() {
+	hs.Scope = models.ScopeReadUsers
+	hs.MockUserRepository.EXPECT().ListUsers(gomock.Any(), repository.All(), repository.Page{Limit: models.MaxPageLimit}).Return(nil, "", nil)
+
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodGet, "/users?limit=100000", nil)
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusOK, recoder.Code)
+	hs.Assert().Empty(recoder.Header().Get(models.NextPageHeader))
+	hs.Assert().Equal("[]\n", recoder.Body.String())
+}
+
+func (hs *HandlerSuite) TestListUsersInvalidQuery() {
+	hs.Scope = models.ScopeReadUsers
+	tests := map[string]string{
+		"/users?sort=age":   "invalid sort age\n",
+		"/users?sort=-":     "invalid sort -\n",
+		"/users?limit=0":    "invalid limit 0\n",
+		"/users?limit=many": "invalid limit many\n",
+	}
+	for path, expected := range tests {
+		recoder := httptest.NewRecorder()
+		hs.Handler.ServeHTTP(recoder, hs.NewRequest(http.MethodGet, path, nil))
+		hs.Assert().Equal(http.StatusBadRequest, recoder.Code, path)
+		hs.Assert().Equal(expected, recoder.Body.String(), path)
+	}
+}
+
+func (hs *HandlerSuite) TestListUsersInvalidCursor() {
+	hs.Scope = models.ScopeReadUsers
+	_, _, pageErr := repository.MongoUserRepository{}.ListUsers(context.Background(), repository.All(), repository.Page{Cursor: "!"})
+	hs.Require().True(repository.IsErrInvalidPage(pageErr))
+	page := firstPage
+	page.Cursor = "!"
+	hs.MockUserRepository.EXPECT().ListUsers(gomock.Any(), repository.All(), page).Return(nil, "", pageErr)
+
+	recoder := httptest.NewRecorder()
+	hs.Handler.ServeHTTP(recoder, hs.NewRequest(http.MethodGet, "/users?next=!", nil))
+
+	hs.Assert().Equal(http.StatusBadRequest, recoder.Code)
+	hs.Assert().Equal("invalid page: cursor is malformed\n", recoder.Body.String())
+}
+
+func (hs *HandlerSuite) TestListUsersInvalidRepositoryQuery() {
+	hs.Scope = models.ScopeReadUsers
+	queryErr := repository.Eq("Age", "1").Validate()
+	hs.Require().True(repository.IsErrInvalidQuery(queryErr))
+	hs.MockUserRepository.EXPECT().ListUsers(gomock.Any(), repository.All(), firstPage).Return(nil, "", queryErr)
+
+	recoder := httptest.NewRecorder()
+	hs.Handler.ServeHTTP(recoder, hs.NewRequest(http.MethodGet, "/users", nil))
+
+	hs.Assert().Equal(http.StatusBadRequest, recoder.Code)
+	hs.Assert().Equal("invalid query: unknown field Age\n", recoder.Body.String())
+}
+
 func (hs *HandlerSuite) TestDeleteUser() {
-	hs.MockUserRepository.EXPECT().RemoveUser(repository.NewMatcher().WithID("12345")).Return(nil)
+	hs.Scope = models.ScopeDeleteUsers
+	hs.MockUserRepository.EXPECT().RemoveUser(gomock.Any(), repository.Eq(repository.FieldID, "12345")).Return(nil)
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodDelete, "/users/12345", nil)
@@ -141,8 +248,21 @@ func (hs *HandlerSuite) TestDeleteUser() {
 	hs.Assert().Equal("", recoder.Body.String())
 }
 
+func (hs *HandlerSuite) TestDeleteUserNotFound() {
+	hs.Scope = models.ScopeDeleteUsers
+	hs.MockUserRepository.EXPECT().RemoveUser(gomock.Any(), repository.Eq(repository.FieldID, "12345")).Return(errors.New("user not found"))
+
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodDelete, "/users/12345", nil)
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusNotFound, recoder.Code)
+	hs.Assert().Equal("user not found\n", recoder.Body.String())
+}
+
 func (hs *HandlerSuite) TestDeleteUserError() {
-	hs.MockUserRepository.EXPECT().RemoveUser(repository.NewMatcher().WithID("12345")).Return(errors.New("boom"))
+	hs.Scope = models.ScopeDeleteUsers
+	hs.MockUserRepository.EXPECT().RemoveUser(gomock.Any(), repository.Eq(repository.FieldID, "12345")).Return(errors.New("boom"))
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodDelete, "/users/12345", nil)
@@ -154,7 +274,8 @@ func (hs *HandlerSuite) TestDeleteUserError() {
 
 func (hs *HandlerSuite) TestUpdateUser() {
 	user := models.UserInfo{ID: "12345", FirstName: "foo", LastName: "bar", Email: "email@email.com"}
-	hs.MockUserRepository.EXPECT().UpdateUser(user).Return(nil)
+	hs.MockUserRepository.EXPECT().FindUser(gomock.Any(), repository.Eq(repository.FieldID, "12345")).Return(user, nil)
+	hs.MockUserRepository.EXPECT().UpdateUser(gomock.Any(), user).Return(nil)
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodPut, "/users/12345", hs.EncodeUser(user))
@@ -166,7 +287,8 @@ func (hs *HandlerSuite) TestUpdateUser() {
 
 func (hs *HandlerSuite) TestUpdateUserError() {
 	user := models.UserInfo{ID: "12345", FirstName: "foo", LastName: "bar", Email: "email@email.com"}
-	hs.MockUserRepository.EXPECT().UpdateUser(user).Return(errors.New("big explosion"))
+	hs.MockUserRepository.EXPECT().FindUser(gomock.Any(), repository.Eq(repository.FieldID, "12345")).Return(user, nil)
+	hs.MockUserRepository.EXPECT().UpdateUser(gomock.Any(), user).Return(errors.New("big explosion"))
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodPut, "/users/12345", hs.EncodeUser(user))
@@ -189,7 +311,7 @@ func (hs *HandlerSuite) TestUpdateUserInvalid() {
 
 func (hs *HandlerSuite) TestUpdateUserNotFound() {
 	user := models.UserInfo{ID: "12345", FirstName: "foo", LastName: "bar", Email: "email@email.com"}
-	hs.MockUserRepository.EXPECT().UpdateUser(user).Return(errors.New("user not found"))
+	hs.MockUserRepository.EXPECT().FindUser(gomock.Any(), repository.Eq(repository.FieldID, "12345")).Return(models.UserInfo{}, errors.New("user not found"))
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodPut, "/users/12345", hs.EncodeUser(user))
@@ -200,6 +322,7 @@ func (hs *HandlerSuite) TestUpdateUserNotFound() {
 }
 
 func (hs *HandlerSuite) TestUpdateUserNotMatchingID() {
+	hs.Subject = "123458"
 	user := models.UserInfo{ID: "12345", FirstName: "foo", LastName: "bar", Email: "email@email.com"}
 
 	recoder := httptest.NewRecorder()
@@ -212,7 +335,7 @@ func (hs *HandlerSuite) TestUpdateUserNotMatchingID() {
 
 func (hs *HandlerSuite) TestCreateUser() {
 	user := models.UserInfo{ID: "12345", FirstName: "foo", LastName: "bar", Email: "email@email.com"}
-	hs.MockUserRepository.EXPECT().CreateUser(user).Return(nil)
+	hs.MockUserRepository.EXPECT().CreateUser(gomock.Any(), user).Return(nil)
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodPost, "/users", hs.EncodeUser(user))
@@ -224,7 +347,7 @@ func (hs *HandlerSuite) TestCreateUser() {
 
 func (hs *HandlerSuite) TestCreateUserError() {
 	user := models.UserInfo{ID: "12345", FirstName: "foo", LastName: "bar", Email: "email@email.com"}
-	hs.MockUserRepository.EXPECT().CreateUser(user).Return(errors.New("look away"))
+	hs.MockUserRepository.EXPECT().CreateUser(gomock.Any(), user).Return(errors.New("look away"))
 
 	recoder := httptest.NewRecorder()
 	request := hs.NewRequest(http.MethodPost, "/users", hs.EncodeUser(user))
@@ -234,6 +357,18 @@ func (hs *HandlerSuite) TestCreateUserError() {
 	hs.Assert().Equal("look away\n", recoder.Body.String())
 }
 
+func (hs *HandlerSuite) TestCreateUserDuplicate() {
+	user := models.UserInfo{ID: "12345", FirstName: "foo", LastName: "bar", Email: "email@email.com"}
+	hs.MockUserRepository.EXPECT().CreateUser(gomock.Any(), user).Return(errors.New("duplicate user"))
+
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodPost, "/users", hs.EncodeUser(user))
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusConflict, recoder.Code)
+	hs.Assert().Equal("duplicate user\n", recoder.Body.String())
+}
+
 func (hs *HandlerSuite) TestCreateUserInvalid() {
 	user := models.UserInfo{ID: "", FirstName: "foo", LastName: "bar", Email: "email@email.com"}
 
@@ -245,9 +380,136 @@ func (hs *HandlerSuite) TestCreateUserInvalid() {
 	hs.Assert().Equal("invalid user, missing id\n", recoder.Body.String())
 }
 
+func (hs *HandlerSuite) TestListUsersForbidden() {
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodGet, "/users", nil)
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusForbidden, recoder.Code)
+}
+
+func (hs *HandlerSuite) TestDeleteUserForbidden() {
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodDelete, "/users/12345", nil)
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusForbidden, recoder.Code)
+}
+
+func (hs *HandlerSuite) TestGetOtherUserForbidden() {
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodGet, "/users/1234", nil)
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusForbidden, recoder.Code)
+	hs.Assert().Equal("forbidden\n", recoder.Body.String())
+}
+
+func (hs *HandlerSuite) TestUpdateOtherUserForbidden() {
+	user := models.UserInfo{ID: "1234", FirstName: "foo", LastName: "bar", Email: "email@email.com"}
+
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodPut, "/users/1234", hs.EncodeUser(user))
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusForbidden, recoder.Code)
+}
+
+func (hs *HandlerSuite) TestCreateOtherUserForbidden() {
+	user := models.UserInfo{ID: "1234", FirstName: "foo", LastName: "bar", Email: "email@email.com"}
+
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodPost, "/users", hs.EncodeUser(user))
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusForbidden, recoder.Code)
+}
+
+func (hs *HandlerSuite) TestGetOtherUserWithScope() {
+	hs.Scope = models.ScopeReadUsers
+	hs.MockUserRepository.EXPECT().FindUser(gomock.Any(), repository.Eq(repository.FieldID, "1234")).Return(models.UserInfo{ID: "1234", FirstName: "foo", LastName: "bar", Email: "foo@email.com", Roles: []string{models.RoleAdmin}}, nil)
+
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodGet, "/users/1234", nil)
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusOK, recoder.Code)
+	hs.Assert().Equal("{\"ID\":\"1234\",\"FirstName\":\"foo\",\"LastName\":\"bar\",\"Email\":\"foo@email.com\",\"Roles\":[\"admin\"]}\n", recoder.Body.String())
+}
+
+func (hs *HandlerSuite) TestUpdateOtherUserWithScope() {
+	hs.Scope = models.ScopeWriteUsers
+	user := models.UserInfo{ID: "1234", FirstName: "foo", LastName: "bar", Email: "email@email.com"}
+	hs.MockUserRepository.EXPECT().FindUser(gomock.Any(), repository.Eq(repository.FieldID, "1234")).Return(user, nil)
+	hs.MockUserRepository.EXPECT().UpdateUser(gomock.Any(), user).Return(nil)
+
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodPut, "/users/1234", hs.EncodeUser(user))
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusOK, recoder.Code)
+}
+
+func (hs *HandlerSuite) TestUpdateUserRolesForbidden() {
+	user := models.UserInfo{ID: "12345", FirstName: "foo", LastName: "bar", Email: "email@email.com", Roles: []string{models.RoleAdmin}}
+	hs.MockUserRepository.EXPECT().FindUser(gomock.Any(), repository.Eq(repository.FieldID, "12345")).Return(models.UserInfo{ID: "12345"}, nil)
+
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodPut, "/users/12345", hs.EncodeUser(user))
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusForbidden, recoder.Code)
+	hs.Assert().Equal("changing roles requires the users:roles scope\n", recoder.Body.String())
+}
+
+func (hs *HandlerSuite) TestUpdateUserRolesWithScope() {
+	hs.Scope = models.ScopeWriteUsers + " " + models.ScopeWriteRoles
+	user := models.UserInfo{ID: "1234", FirstName: "foo", LastName: "bar", Email: "email@email.com", Roles: []string{models.RoleAdmin}}
+	hs.MockUserRepository.EXPECT().UpdateUser(gomock.Any(), user).Return(nil)
+
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodPut, "/users/1234", hs.EncodeUser(user))
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusOK, recoder.Code)
+}
+
+func (hs *HandlerSuite) TestCreateOtherUserWithScope() {
+	hs.Scope = models.ScopeWriteUsers
+	user := models.UserInfo{ID: "1234", FirstName: "foo", LastName: "bar", Email: "email@email.com"}
+	hs.MockUserRepository.EXPECT().CreateUser(gomock.Any(), user).Return(nil)
+
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodPost, "/users", hs.EncodeUser(user))
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusCreated, recoder.Code)
+}
+
+func (hs *HandlerSuite) TestCreateUserWithRolesForbidden() {
+	user := models.UserInfo{ID: "12345", FirstName: "foo", LastName: "bar", Email: "email@email.com", Roles: []string{models.RoleAdmin}}
+
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodPost, "/users", hs.EncodeUser(user))
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusForbidden, recoder.Code)
+	hs.Assert().Equal("changing roles requires the users:roles scope\n", recoder.Body.String())
+}
+
+func (hs *HandlerSuite) TestUnauthenticated() {
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodGet, "/users/12345", nil)
+	request.Header.Del("Authorization")
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusUnauthorized, recoder.Code)
+}
+
 func (hs *HandlerSuite) NewRequest(method string, path string, r io.Reader) *http.Request {
 	req := httptest.NewRequest(method, path, r)
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+hs.Subject+";"+hs.Scope)
 	return req
 }
 
@@ -256,3 +518,18 @@ func (hs *HandlerSuite) EncodeUser(user models.UserInfo) (buf *bytes.Buffer) {
 	hs.Require().NoError(json.NewEncoder(buf).Encode(&user))
 	return
 }
+
+func (hs *HandlerSuite) TestRevokedToken() {
+	introspection := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"active":false}`))
+	}))
+	defer introspection.Close()
+	hs.Handler = controller.NewHandler(hs.MockUserRepository, httputil.IntrospectionReader{Reader: subjectReader{}, URL: introspection.URL}, httprouter.New())
+
+	recoder := httptest.NewRecorder()
+	request := hs.NewRequest(http.MethodGet, "/users/12345", nil)
+	hs.Handler.ServeHTTP(recoder, request)
+
+	hs.Assert().Equal(http.StatusUnauthorized, recoder.Code)
+	hs.Assert().Equal("bearer token invalid: token is not active\n", recoder.Body.String())
+}
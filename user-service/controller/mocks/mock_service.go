@@ -5,6 +5,7 @@
 package mocks
 
 import (
+	context "context"
 	models "github.com/darren-west/app/user-service/models"
 	repository "github.com/darren-west/app/user-service/repository"
 	gomock "github.com/golang/mock/gomock"
@@ -35,63 +36,64 @@ func (m *MockUserRepository) EXPECT() *MockUserRepositoryMockRecorder {
 }
 
 // CreateUser mocks base method
-func (m *MockUserRepository) CreateUser(arg0 models.UserInfo) error {
-	ret := m.ctrl.Call(m, "CreateUser", arg0)
+func (m *MockUserRepository) CreateUser(arg0 context.Context, arg1 models.UserInfo) error {
+	ret := m.ctrl.Call(m, "CreateUser", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // CreateUser indicates an expected call of CreateUser
-func (mr *MockUserRepositoryMockRecorder) CreateUser(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockUserRepository)(nil).CreateUser), arg0)
+func (mr *MockUserRepositoryMockRecorder) CreateUser(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockUserRepository)(nil).CreateUser), arg0, arg1)
 }
 
 // FindUser mocks base method
-func (m *MockUserRepository) FindUser(arg0 repository.Matcher) (models.UserInfo, error) {
-	ret := m.ctrl.Call(m, "FindUser", arg0)
+func (m *MockUserRepository) FindUser(arg0 context.Context, arg1 repository.Query) (models.UserInfo, error) {
+	ret := m.ctrl.Call(m, "FindUser", arg0, arg1)
 	ret0, _ := ret[0].(models.UserInfo)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // FindUser indicates an expected call of FindUser
-func (mr *MockUserRepositoryMockRecorder) FindUser(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindUser", reflect.TypeOf((*MockUserRepository)(nil).FindUser), arg0)
+func (mr *MockUserRepositoryMockRecorder) FindUser(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindUser", reflect.TypeOf((*MockUserRepository)(nil).FindUser), arg0, arg1)
 }
 
 // ListUsers mocks base method
-func (m *MockUserRepository) ListUsers(arg0 repository.Matcher) ([]models.UserInfo, error) {
-	ret := m.ctrl.Call(m, "ListUsers", arg0)
+func (m *MockUserRepository) ListUsers(arg0 context.Context, arg1 repository.Query, arg2 repository.Page) ([]models.UserInfo, string, error) {
+	ret := m.ctrl.Call(m, "ListUsers", arg0, arg1, arg2)
 	ret0, _ := ret[0].([]models.UserInfo)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
 // ListUsers indicates an expected call of ListUsers
-func (mr *MockUserRepositoryMockRecorder) ListUsers(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUsers", reflect.TypeOf((*MockUserRepository)(nil).ListUsers), arg0)
+func (mr *MockUserRepositoryMockRecorder) ListUsers(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUsers", reflect.TypeOf((*MockUserRepository)(nil).ListUsers), arg0, arg1, arg2)
 }
 
 // RemoveUser mocks base method
-func (m *MockUserRepository) RemoveUser(arg0 repository.Matcher) error {
-	ret := m.ctrl.Call(m, "RemoveUser", arg0)
+func (m *MockUserRepository) RemoveUser(arg0 context.Context, arg1 repository.Query) error {
+	ret := m.ctrl.Call(m, "RemoveUser", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // RemoveUser indicates an expected call of RemoveUser
-func (mr *MockUserRepositoryMockRecorder) RemoveUser(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveUser", reflect.TypeOf((*MockUserRepository)(nil).RemoveUser), arg0)
+func (mr *MockUserRepositoryMockRecorder) RemoveUser(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveUser", reflect.TypeOf((*MockUserRepository)(nil).RemoveUser), arg0, arg1)
 }
 
 // UpdateUser mocks base method
-func (m *MockUserRepository) UpdateUser(arg0 models.UserInfo) error {
-	ret := m.ctrl.Call(m, "UpdateUser", arg0)
+func (m *MockUserRepository) UpdateUser(arg0 context.Context, arg1 models.UserInfo) error {
+	ret := m.ctrl.Call(m, "UpdateUser", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateUser indicates an expected call of UpdateUser
-func (mr *MockUserRepositoryMockRecorder) UpdateUser(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUser", reflect.TypeOf((*MockUserRepository)(nil).UpdateUser), arg0)
+func (mr *MockUserRepositoryMockRecorder) UpdateUser(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUser", reflect.TypeOf((*MockUserRepository)(nil).UpdateUser), arg0, arg1)
 }
@@ -1,5 +1,7 @@
 module github.com/darren-west/app/user-service
 
+go 1.11
+
 require (
 	github.com/Microsoft/go-winio v0.4.11 // indirect
 	github.com/darren-west/app/utils v0.0.0-20181115152030-d28b3081ca4c
@@ -12,14 +14,16 @@ require (
 	github.com/hashicorp/errwrap v1.0.0
 	github.com/julienschmidt/httprouter v1.2.0
 	github.com/kr/pretty v0.1.0 // indirect
+	github.com/lib/pq v1.0.0
+	github.com/mattn/go-sqlite3 v1.10.0
 	github.com/pkg/errors v0.8.0 // indirect
 	github.com/sirupsen/logrus v1.2.0
 	github.com/stevvooe/resumable v0.0.0-20180830230917-22b14a53ba50 // indirect
 	github.com/stretchr/testify v1.2.2
-	golang.org/x/net v0.0.0-20181108082009-03003ca0c849 // indirect
+	go.mongodb.org/mongo-driver v1.17.10
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
-	gopkg.in/mgo.v2 v2.0.0-20180705113604-9856a29383ce
 	gopkg.in/resty.v1 v1.10.2
-
 	gopkg.in/yaml.v2 v2.2.1 // indirect
 )
+
+replace github.com/darren-west/app/utils => ../utils
@@ -1,30 +1,99 @@
 package main
 
 import (
+	"flag"
 	"net/http"
 
+	"github.com/darren-west/app/utils/fileutil"
 	"github.com/darren-west/app/utils/httputil"
+	"github.com/darren-west/app/utils/jwt"
 
+	"github.com/darren-west/app/user-service/config"
 	"github.com/darren-west/app/user-service/controller"
 	"github.com/darren-west/app/user-service/repository"
+	utilconfig "github.com/darren-west/app/utils/config"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	configFlag = flag.String("config", "", "--config the path to the configuration file, the defaults, environment variables and flags are used without one")
+	flags      *utilconfig.Flags
+)
+
+func init() {
+	var err error
+	if flags, err = utilconfig.NewFlags(flag.CommandLine, &config.Options{}); err != nil {
+		logrus.Fatal(err)
+	}
+	flag.Parse()
+}
+
 func main() {
-	repo, err := repository.NewMongoUserRepository(
-		repository.WithConnectionString("mongodb://localhost:27017"),
-		repository.WithDatabaseName("dev"),
-		repository.WithCollectionName("users"),
-	)
+	reader, err := config.NewReader(fileutil.FileReader{})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	config, err := reader.WithFlags(flags).Read(*configFlag)
 	if err != nil {
 		logrus.Fatal(err)
 	}
+	level, _ := logrus.ParseLevel(config.Log.Level)
+	logrus.SetLevel(level)
+	logrus.SetFormatter(config.Log.Formatter())
 
-	logrus.SetLevel(logrus.DebugLevel)
+	repo, err := newRepository(config)
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
 	router := httprouter.New()
-	http.ListenAndServe(":80",
-		httputil.WithHandlerLogging(logrus.StandardLogger(), controller.NewHandler(repo, router)),
+	handler := httputil.WithHandlerLogging(logrus.StandardLogger(), controller.NewHandler(repo, newTokenReader(config.Tokens), router))
+
+	logrus.WithField("address", config.BindAddress).Info("Starting user service.")
+	if config.TLS.Enabled() {
+		err = http.ListenAndServeTLS(config.BindAddress, config.TLS.CertPath, config.TLS.KeyPath, handler)
+	} else {
+		err = http.ListenAndServe(config.BindAddress, handler)
+	}
+	logrus.Fatal(err)
+}
+
+// newTokenReader returns the reader bearer tokens are verified with. Tokens are introspected with the auth service
+// when the introspection url is set, otherwise revoked tokens are accepted until they expire.
+func newTokenReader(options config.TokenOptions) httputil.TokenReader {
+	reader := jwt.NewReader(
+		jwt.ReaderBuilder.WithJWKSURL(options.JWKSURL),
+		jwt.ReaderBuilder.WithIssuer(options.Issuer),
+		jwt.ReaderBuilder.WithAudience(options.Audience),
+	)
+	if options.IntrospectionURL == "" {
+		logrus.Warn("Tokens are not introspected, revoked tokens are accepted until they expire.")
+		return reader
+	}
+	return httputil.IntrospectionReader{
+		Reader:       reader,
+		URL:          options.IntrospectionURL,
+		ClientID:     options.ClientID,
+		ClientSecret: options.ClientSecret,
+	}
+}
+
+// newRepository returns the repository users are stored in.
+func newRepository(options config.Options) (controller.UserRepository, error) {
+	switch options.Repository {
+	case config.RepositoryMemory:
+		logrus.Warn("Users are stored in memory, they are lost when the service stops.")
+		return repository.NewMemoryUserRepository(), nil
+	case config.RepositorySQL:
+		return repository.NewSQLUserRepository(options.SQL.Driver, options.SQL.DataSource)
+	}
+	return repository.NewMongoUserRepository(
+		repository.WithConnectionString(options.Mongo.ConnectionString),
+		repository.WithDatabaseName(options.Mongo.DatabaseName),
+		repository.WithCollectionName(options.Mongo.CollectionName),
+		repository.WithPoolSize(options.Mongo.MinPoolSize, options.Mongo.MaxPoolSize),
+		repository.WithReadConcern(options.Mongo.ReadConcern),
+		repository.WithWriteConcern(options.Mongo.WriteConcern),
 	)
 }
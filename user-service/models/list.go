@@ -0,0 +1,23 @@
+package models
+
+// The query parameters of GET /users. The email and name filters match the value exactly, or by prefix when the
+// value ends with *. The sort is the name of the field to sort by, id, firstName, lastName or email, prefixed
+// with - to sort in descending order. The next parameter is the token from the NextPageHeader of the previous page.
+const (
+	QueryEmail     = "email"
+	QueryFirstName = "firstName"
+	QueryLastName  = "lastName"
+	QuerySort      = "sort"
+	QueryLimit     = "limit"
+	QueryNext      = "next"
+)
+
+// NextPageHeader is the response header with the token of the next page of users. It is not set on the last page.
+const NextPageHeader = "Next-Page"
+
+// The number of users listed on a page when no limit is given, and the most that can be listed on one page.
+// Larger limits are reduced to the maximum.
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
@@ -0,0 +1,14 @@
+package models
+
+// Scopes that grant access to users other than the caller. They are given to tokens by auth-service based on
+// the roles of the user.
+const (
+	ScopeReadUsers   = "users:read"
+	ScopeWriteUsers  = "users:write"
+	ScopeDeleteUsers = "users:delete"
+	// ScopeWriteRoles allows changing the roles of users, without it the roles of a user can't be changed.
+	ScopeWriteRoles = "users:roles"
+)
+
+// RoleAdmin is the role of users that manage other users.
+const RoleAdmin = "admin"
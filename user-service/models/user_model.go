@@ -2,13 +2,19 @@ package models
 
 import (
 	"fmt"
+	"time"
 )
 
 type UserInfo struct {
-	ID        string `json:"ID"`
-	FirstName string `json:"FirstName"`
-	LastName  string `json:"LastName"`
-	Email     string `json:"Email"`
+	ID        string   `json:"ID"`
+	FirstName string   `json:"FirstName"`
+	LastName  string   `json:"LastName"`
+	Email     string   `json:"Email"`
+	Roles     []string `json:"Roles,omitempty"`
+	// CreatedAt and UpdatedAt are set by the repository when the user is stored, the values sent by clients are
+	// ignored.
+	CreatedAt *time.Time `json:"CreatedAt,omitempty" bson:",omitempty"`
+	UpdatedAt *time.Time `json:"UpdatedAt,omitempty" bson:",omitempty"`
 }
 
 type UserValidator struct{}
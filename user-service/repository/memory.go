@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darren-west/app/user-service/models"
+)
+
+// MemoryUserRepository stores users in memory, for running the service locally and in tests without mongo. It is
+// safe for concurrent use, and is empty when the process starts. Create one with NewMemoryUserRepository.
+type MemoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[string]models.UserInfo
+}
+
+// NewMemoryUserRepository returns an empty in memory repository.
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: make(map[string]models.UserInfo)}
+}
+
+// FindUser returns the user that matches, the one with the lowest id when more than one does.
+func (r *MemoryUserRepository) FindUser(_ context.Context, q Query) (user models.UserInfo, err error) {
+	if err = q.Validate(); err != nil {
+		return
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	users := r.find(q)
+	if len(users) == 0 {
+		return user, errors.New("user not found")
+	}
+	return copyUser(users[0]), nil
+}
+
+// ListUsers returns the page of users that match. The next cursor is empty when there are no more users, see
+// Page for how to get the next page.
+func (r *MemoryUserRepository) ListUsers(_ context.Context, q Query, page Page) (users []models.UserInfo, next string, err error) {
+	if err = q.Validate(); err != nil {
+		return
+	}
+	query, fields, err := page.query(q)
+	if err != nil {
+		return
+	}
+	r.mu.RLock()
+	matched := r.find(query)
+	r.mu.RUnlock()
+	sort.SliceStable(matched, func(i, j int) bool {
+		for _, field := range fields {
+			a, b := sortFields[field](matched[i]), sortFields[field](matched[j])
+			if a != b {
+				return (a < b) != page.Descending
+			}
+		}
+		return false
+	})
+	if page.Limit > 0 && len(matched) > page.Limit+1 {
+		matched = matched[:page.Limit+1]
+	}
+	for _, user := range matched {
+		users = append(users, copyUser(user))
+	}
+	return page.next(users)
+}
+
+// CreateUser stores the user, with its created and updated times set to now. The id and email of the user must not
+// be used by another user.
+func (r *MemoryUserRepository) CreateUser(_ context.Context, user models.UserInfo) error {
+	now := timestamp()
+	user.CreatedAt, user.UpdatedAt = &now, &now
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[user.ID]; ok || r.emailUsed(user) {
+		return errors.New("duplicate user")
+	}
+	r.users[user.ID] = copyUser(user)
+	return nil
+}
+
+// RemoveUser removes the user that matches, the one with the lowest id when more than one does.
+func (r *MemoryUserRepository) RemoveUser(_ context.Context, q Query) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	users := r.find(q)
+	if len(users) == 0 {
+		return errors.New("user not found")
+	}
+	delete(r.users, users[0].ID)
+	return nil
+}
+
+// UpdateUser replaces the user with the same id, keeping the time it was created and setting its updated time to
+// now. The email of the user must not be used by another user.
+func (r *MemoryUserRepository) UpdateUser(_ context.Context, user models.UserInfo) error {
+	now := timestamp()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	existing, ok := r.users[user.ID]
+	if !ok {
+		return errors.New("user not found")
+	}
+	if r.emailUsed(user) {
+		return errors.New("duplicate user")
+	}
+	user.CreatedAt, user.UpdatedAt = existing.CreatedAt, &now
+	r.users[user.ID] = copyUser(user)
+	return nil
+}
+
+// emailUsed returns true if another user has the email of the user. The lock must be held.
+func (r *MemoryUserRepository) emailUsed(user models.UserInfo) bool {
+	for id, other := range r.users {
+		if id != user.ID && other.Email == user.Email {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the users that match, sorted by id. The lock must be held.
+func (r *MemoryUserRepository) find(q Query) []models.UserInfo {
+	var users []models.UserInfo
+	for _, user := range r.users {
+		if memoryMatch(q, user) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// copyUser returns a copy of the user that shares nothing with it, so users stored can't be changed by callers.
+func copyUser(user models.UserInfo) models.UserInfo {
+	if user.Roles != nil {
+		user.Roles = append([]string{}, user.Roles...)
+	}
+	if user.CreatedAt != nil {
+		createdAt := *user.CreatedAt
+		user.CreatedAt = &createdAt
+	}
+	if user.UpdatedAt != nil {
+		updatedAt := *user.UpdatedAt
+		user.UpdatedAt = &updatedAt
+	}
+	return user
+}
+
+// memoryMatch returns true if the user matches the query, it matches the same users as the query translated for
+// mongo. Conditions on the roles match if any role matches.
+func memoryMatch(q Query, user models.UserInfo) bool {
+	switch q.op {
+	case opAnd:
+		for _, query := range q.queries {
+			if !memoryMatch(query, user) {
+				return false
+			}
+		}
+		return true
+	case opOr:
+		for _, query := range q.queries {
+			if memoryMatch(query, user) {
+				return true
+			}
+		}
+		return false
+	}
+	value := reflect.ValueOf(user).FieldByName(string(q.field)).Interface()
+	switch value := value.(type) {
+	case []string:
+		for _, v := range value {
+			if matchValue(q, v) {
+				return true
+			}
+		}
+		return false
+	case *time.Time:
+		return value != nil && matchValue(q, *value)
+	default:
+		return matchValue(q, value)
+	}
+}
+
+// matchValue returns true if the value of a field matches the condition.
+func matchValue(q Query, value interface{}) bool {
+	switch q.op {
+	case opEq, opIn:
+		for _, v := range q.values {
+			if equal(v, value) {
+				return true
+			}
+		}
+		return false
+	case opPrefix:
+		return strings.HasPrefix(value.(string), q.values[0].(string))
+	case opRange:
+		t := value.(time.Time)
+		return (q.from.IsZero() || !t.Before(q.from)) && (q.to.IsZero() || t.Before(q.to))
+	case opAfter:
+		return value.(string) > q.values[0].(string)
+	case opBefore:
+		return value.(string) < q.values[0].(string)
+	}
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	if t, ok := a.(time.Time); ok {
+		u, ok := b.(time.Time)
+		return ok && t.Equal(u)
+	}
+	return a == b
+}
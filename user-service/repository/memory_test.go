@@ -0,0 +1,62 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/darren-west/app/user-service/controller"
+	"github.com/darren-west/app/user-service/models"
+	"github.com/darren-west/app/user-service/repository"
+	"github.com/darren-west/app/user-service/repository/testutils/conformance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// ctx is the context the repositories are called with in the tests.
+var ctx = context.Background()
+
+func TestMemoryUserRepository(t *testing.T) {
+	suite.Run(t, &conformance.Suite{NewRepository: func() (controller.UserRepository, error) {
+		return repository.NewMemoryUserRepository(), nil
+	}})
+}
+
+func TestMemoryUserRepositoryCopiesUsers(t *testing.T) {
+	repo := repository.NewMemoryUserRepository()
+	user := models.UserInfo{ID: "1", Roles: []string{models.RoleAdmin}}
+	require.NoError(t, repo.CreateUser(ctx, user))
+	user.Roles[0] = "changed"
+
+	found, err := repo.FindUser(ctx, repository.Eq(repository.FieldID, "1"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.RoleAdmin}, found.Roles)
+	found.Roles[0] = "changed"
+
+	found, err = repo.FindUser(ctx, repository.Eq(repository.FieldID, "1"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.RoleAdmin}, found.Roles)
+}
+
+func TestMemoryUserRepositoryConcurrent(t *testing.T) {
+	repo := repository.NewMemoryUserRepository()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := models.UserInfo{ID: fmt.Sprintf("%02d", i), Email: fmt.Sprintf("%02d@email.com", i)}
+			assert.NoError(t, repo.CreateUser(ctx, user))
+			assert.NoError(t, repo.UpdateUser(ctx, user))
+			_, _, err := repo.ListUsers(ctx, repository.All(), repository.Page{Limit: 5})
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	users, _, err := repo.ListUsers(ctx, repository.All(), repository.Page{})
+	require.NoError(t, err)
+	assert.Len(t, users, 20)
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/darren-west/app/user-service/models"
+)
+
+// Page selects a page of the users listed. Users are sorted by the sort field, and then by id so the order is
+// stable. The first page is listed without a cursor, the next page is listed with the cursor returned with the
+// previous page. The sort field must not change between pages. All the users are listed when the limit is 0.
+type Page struct {
+	SortField  Field
+	Descending bool
+	Limit      int
+	Cursor     string
+}
+
+// sortFields are the fields users can be sorted by, with the value of the field of a user.
+var sortFields = map[Field]func(models.UserInfo) string{
+	FieldID:        func(u models.UserInfo) string { return u.ID },
+	FieldFirstName: func(u models.UserInfo) string { return u.FirstName },
+	FieldLastName:  func(u models.UserInfo) string { return u.LastName },
+	FieldEmail:     func(u models.UserInfo) string { return u.Email },
+}
+
+// cursor is the position after the last user of a page, it is encoded to the opaque cursor string.
+type cursor struct {
+	Field      Field  `json:"f"`
+	Descending bool   `json:"d,omitempty"`
+	Value      string `json:"v,omitempty"`
+	ID         string `json:"i"`
+}
+
+type pageError struct {
+	error
+}
+
+// IsErrInvalidPage returns true if the page can't be listed, because its sort field is unknown or its cursor is
+// invalid.
+func IsErrInvalidPage(err error) bool {
+	_, ok := err.(pageError)
+	return ok
+}
+
+func (p Page) sortField() Field {
+	if p.SortField == "" {
+		return FieldID
+	}
+	return p.SortField
+}
+
+// query returns the query for the users on the page that match, and the fields to sort them by. The fields are
+// sorted in descending order when the page is.
+func (p Page) query(q Query) (Query, []Field, error) {
+	field := p.sortField()
+	if _, ok := sortFields[field]; !ok {
+		return Query{}, nil, pageError{fmt.Errorf("invalid page: unknown sort field %s", field)}
+	}
+	sort := []Field{field, FieldID}
+	if field == FieldID {
+		sort = sort[:1]
+	}
+	if p.Cursor == "" {
+		return q, sort, nil
+	}
+	c, err := decodeCursor(p.Cursor)
+	if err != nil {
+		return Query{}, nil, err
+	}
+	if c.Field != field || c.Descending != p.Descending {
+		return Query{}, nil, pageError{fmt.Errorf("invalid page: cursor is for another sort order")}
+	}
+	past := after
+	if p.Descending {
+		past = before
+	}
+	rest := past(FieldID, c.ID)
+	if field != FieldID {
+		rest = Or(past(field, c.Value), And(Eq(field, c.Value), past(FieldID, c.ID)))
+	}
+	return And(q, rest), sort, nil
+}
+
+// next trims the extra user listed to find out if there is another page, and returns the cursor to it.
+func (p Page) next(users []models.UserInfo) ([]models.UserInfo, string, error) {
+	if p.Limit <= 0 || len(users) <= p.Limit {
+		return users, "", nil
+	}
+	users = users[:p.Limit]
+	last := users[len(users)-1]
+	field := p.sortField()
+	next, err := encodeCursor(cursor{Field: field, Descending: p.Descending, Value: sortFields[field](last), ID: last.ID})
+	return users, next, err
+}
+
+func encodeCursor(c cursor) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(s string) (c cursor, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil || c.Field == "" {
+		return c, pageError{fmt.Errorf("invalid page: cursor is malformed")}
+	}
+	return c, nil
+}
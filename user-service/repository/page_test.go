@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/darren-west/app/user-service/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPageQuery(t *testing.T) {
+	q := Prefix(FieldLastName, "Sm")
+
+	query, sort, err := Page{SortField: FieldLastName, Limit: 2}.query(q)
+	require.NoError(t, err)
+	assert.Equal(t, q, query)
+	assert.Equal(t, []Field{FieldLastName, FieldID}, sort)
+
+	users, next, err := Page{SortField: FieldLastName, Limit: 2}.next([]models.UserInfo{
+		{ID: "1", LastName: "Smith"}, {ID: "2", LastName: "Smyth"}, {ID: "3", LastName: "Smythe"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, users, 2)
+	require.NotEmpty(t, next)
+
+	query, sort, err = Page{SortField: FieldLastName, Limit: 2, Cursor: next}.query(q)
+	require.NoError(t, err)
+	assert.Equal(t, []Field{FieldLastName, FieldID}, sort)
+	assert.Equal(t, And(q, Or(
+		after(FieldLastName, "Smyth"),
+		And(Eq(FieldLastName, "Smyth"), after(FieldID, "2")),
+	)), query)
+}
+
+func TestPageQueryDescendingByID(t *testing.T) {
+	page := Page{Descending: true, Limit: 1}
+	_, sort, err := page.query(All())
+	require.NoError(t, err)
+	assert.Equal(t, []Field{FieldID}, sort)
+
+	_, next, err := page.next([]models.UserInfo{{ID: "2"}, {ID: "1"}})
+	require.NoError(t, err)
+	page.Cursor = next
+	query, _, err := page.query(All())
+	require.NoError(t, err)
+	assert.Equal(t, And(All(), before(FieldID, "2")), query)
+}
+
+func TestPageLastPage(t *testing.T) {
+	users := []models.UserInfo{{ID: "1"}, {ID: "2"}}
+	for _, page := range []Page{{Limit: 2}, {Limit: 0}} {
+		listed, next, err := page.next(users)
+		require.NoError(t, err)
+		assert.Equal(t, users, listed)
+		assert.Empty(t, next)
+	}
+}
+
+func TestPageInvalid(t *testing.T) {
+	_, next, err := Page{SortField: FieldEmail, Limit: 1}.next([]models.UserInfo{{ID: "1"}, {ID: "2"}})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		page        Page
+		expectedErr string
+	}{
+		"sort field":      {page: Page{SortField: "age"}, expectedErr: "invalid page: unknown sort field age"},
+		"malformed":       {page: Page{Cursor: "not a cursor"}, expectedErr: "invalid page: cursor is malformed"},
+		"other field":     {page: Page{SortField: FieldLastName, Cursor: next}, expectedErr: "invalid page: cursor is for another sort order"},
+		"other direction": {page: Page{SortField: FieldEmail, Descending: true, Cursor: next}, expectedErr: "invalid page: cursor is for another sort order"},
+	}
+	for name, test := range tests {
+		_, _, err := test.page.query(All())
+		assert.EqualError(t, err, test.expectedErr, name)
+		assert.True(t, IsErrInvalidPage(err), name)
+	}
+}
@@ -0,0 +1,45 @@
+// +build integration
+
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/darren-west/app/user-service/controller"
+	"github.com/darren-west/app/user-service/repository"
+	"github.com/darren-west/app/user-service/repository/testutils/conformance"
+	"github.com/darren-west/app/user-service/repository/testutils/container"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const postgresDataSource = "postgres://postgres@127.0.0.1:5432/postgres?sslmode=disable"
+
+func TestSQLUserRepositoryPostgres(t *testing.T) {
+	manager, err := container.NewManager(
+		container.WithPortMapping(container.PortMapping{}.With("5432/tcp", "5432/tcp")),
+		container.WithImage("postgres:10"),
+		container.WithWriter(ioutil.Discard),
+	)
+	require.NoError(t, err)
+	require.NoError(t, manager.Start(context.Background()))
+	defer manager.Stop(context.Background())
+
+	db, err := sql.Open("postgres", postgresDataSource)
+	require.NoError(t, err)
+	defer db.Close()
+	for start := time.Now(); db.Ping() != nil; time.Sleep(time.Second) {
+		require.True(t, time.Since(start) < time.Minute, "postgres did not start")
+	}
+
+	suite.Run(t, &conformance.Suite{NewRepository: func() (controller.UserRepository, error) {
+		if _, err := db.Exec("DROP TABLE IF EXISTS user_roles, users, schema_migrations"); err != nil {
+			return nil, err
+		}
+		return repository.NewSQLUserRepository("postgres", postgresDataSource)
+	}})
+}
@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/darren-west/app/user-service/models"
+)
+
+// Field is a field of models.UserInfo that users are queried and sorted by.
+type Field string
+
+// The fields of models.UserInfo that can be queried.
+const (
+	FieldID        Field = "ID"
+	FieldFirstName Field = "FirstName"
+	FieldLastName  Field = "LastName"
+	FieldEmail     Field = "Email"
+	FieldRoles     Field = "Roles"
+	FieldCreatedAt Field = "CreatedAt"
+	FieldUpdatedAt Field = "UpdatedAt"
+)
+
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	stringsField
+	timeField
+)
+
+// fields are the kinds of the fields of models.UserInfo, which decide the conditions that can be used on them.
+var fields = func() map[Field]fieldKind {
+	kinds := map[reflect.Type]fieldKind{
+		reflect.TypeOf(""):                stringField,
+		reflect.TypeOf([]string{}):        stringsField,
+		reflect.TypeOf((*time.Time)(nil)): timeField,
+	}
+	fields := map[Field]fieldKind{}
+	t := reflect.TypeOf(models.UserInfo{})
+	for i := 0; i < t.NumField(); i++ {
+		if kind, ok := kinds[t.Field(i).Type]; ok {
+			fields[Field(t.Field(i).Name)] = kind
+		}
+	}
+	return fields
+}()
+
+type operator int
+
+const (
+	opAnd operator = iota
+	opOr
+	opEq
+	opIn
+	opPrefix
+	opRange
+	// opAfter and opBefore compare strings, they are only used to page through users.
+	opAfter
+	opBefore
+)
+
+// Query selects users. Queries are built from conditions on fields, Eq, In, Prefix and Between, combined with
+// And and Or. Conditions on the roles field match users with any role matching. Queries are validated against
+// the fields of models.UserInfo before they are run, a query that can't match the field it is on is an error
+// rather than silently matching nothing.
+type Query struct {
+	op       operator
+	field    Field
+	values   []interface{}
+	from, to time.Time
+	queries  []Query
+}
+
+// All matches every user.
+func All() Query {
+	return Query{op: opAnd}
+}
+
+// Eq matches users whose field is equal to the value.
+func Eq(field Field, value interface{}) Query {
+	return Query{op: opEq, field: field, values: []interface{}{value}}
+}
+
+// In matches users whose field is equal to one of the values.
+func In(field Field, values ...interface{}) Query {
+	return Query{op: opIn, field: field, values: values}
+}
+
+// Prefix matches users whose field starts with the prefix.
+func Prefix(field Field, prefix string) Query {
+	return Query{op: opPrefix, field: field, values: []interface{}{prefix}}
+}
+
+// Between matches users whose time field is at or after from and before to. A zero from or to leaves that end of
+// the range open.
+func Between(field Field, from, to time.Time) Query {
+	return Query{op: opRange, field: field, from: from, to: to}
+}
+
+// And matches users that match all of the queries.
+func And(queries ...Query) Query {
+	return Query{op: opAnd, queries: queries}
+}
+
+// Or matches users that match any of the queries. It matches nothing without queries.
+func Or(queries ...Query) Query {
+	return Query{op: opOr, queries: queries}
+}
+
+func after(field Field, value string) Query {
+	return Query{op: opAfter, field: field, values: []interface{}{value}}
+}
+
+func before(field Field, value string) Query {
+	return Query{op: opBefore, field: field, values: []interface{}{value}}
+}
+
+type queryError struct {
+	error
+}
+
+// IsErrInvalidQuery returns true if the error is because the query is invalid.
+func IsErrInvalidQuery(err error) bool {
+	_, ok := err.(queryError)
+	return ok
+}
+
+// Validate returns an error if a condition is on an unknown field, or can't be used on its field.
+func (q Query) Validate() error {
+	if q.op == opAnd || q.op == opOr {
+		for _, query := range q.queries {
+			if err := query.Validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	kind, ok := fields[q.field]
+	if !ok {
+		return queryError{fmt.Errorf("invalid query: unknown field %s", q.field)}
+	}
+	switch q.op {
+	case opRange:
+		if kind != timeField {
+			return queryError{fmt.Errorf("invalid query: field %s is not a time", q.field)}
+		}
+		return nil
+	case opPrefix, opAfter, opBefore:
+		if kind == timeField {
+			return queryError{fmt.Errorf("invalid query: field %s is not a string", q.field)}
+		}
+	}
+	for _, value := range q.values {
+		var valid bool
+		switch value.(type) {
+		case string:
+			valid = kind != timeField
+		case time.Time:
+			valid = kind == timeField
+		}
+		if !valid {
+			return queryError{fmt.Errorf("invalid query: value %v of type %T can't be compared to field %s", value, value, q.field)}
+		}
+	}
+	return nil
+}
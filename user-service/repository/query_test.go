@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestQueryValid(t *testing.T) {
+	now := time.Now()
+	queries := map[string]Query{
+		"all":      All(),
+		"eq":       Eq(FieldFirstName, "foo"),
+		"in":       In(FieldID, "1", "2"),
+		"prefix":   Prefix(FieldEmail, "foo@"),
+		"role":     Eq(FieldRoles, "admin"),
+		"time":     Eq(FieldUpdatedAt, now),
+		"between":  Between(FieldCreatedAt, now.Add(-time.Hour), now),
+		"combined": Or(And(Eq(FieldLastName, "bar"), Prefix(FieldFirstName, "f")), Eq(FieldID, "1")),
+	}
+	for name, q := range queries {
+		assert.NoError(t, q.Validate(), name)
+	}
+}
+
+func TestQueryInvalid(t *testing.T) {
+	tests := map[string]struct {
+		query       Query
+		expectedErr string
+	}{
+		"unknown field":    {query: Eq("Name", "foo"), expectedErr: "invalid query: unknown field Name"},
+		"mongo field name": {query: Eq("firstname", "foo"), expectedErr: "invalid query: unknown field firstname"},
+		"value type":       {query: Eq(FieldID, 1), expectedErr: "invalid query: value 1 of type int can't be compared to field ID"},
+		"time value":       {query: In(FieldCreatedAt, "yesterday"), expectedErr: "invalid query: value yesterday of type string can't be compared to field CreatedAt"},
+		"time prefix":      {query: Prefix(FieldCreatedAt, "2018"), expectedErr: "invalid query: field CreatedAt is not a string"},
+		"string range":     {query: Between(FieldEmail, time.Time{}, time.Now()), expectedErr: "invalid query: field Email is not a time"},
+		"nested":           {query: And(Eq(FieldID, "1"), Or(Eq("Age", "1"))), expectedErr: "invalid query: unknown field Age"},
+	}
+	for name, test := range tests {
+		err := test.query.Validate()
+		assert.EqualError(t, err, test.expectedErr, name)
+		assert.True(t, IsErrInvalidQuery(err), name)
+	}
+}
+
+func TestMongoQuery(t *testing.T) {
+	from := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		query    Query
+		expected bson.M
+	}{
+		"all":     {query: All(), expected: bson.M{}},
+		"none":    {query: Or(), expected: bson.M{"id": bson.M{"$in": []interface{}{}}}},
+		"eq":      {query: Eq(FieldFirstName, "foo"), expected: bson.M{"firstname": "foo"}},
+		"in":      {query: In(FieldID, "1", "2"), expected: bson.M{"id": bson.M{"$in": []interface{}{"1", "2"}}}},
+		"prefix":  {query: Prefix(FieldEmail, "foo."), expected: bson.M{"email": primitive.Regex{Pattern: `^foo\.`}}},
+		"between": {query: Between(FieldCreatedAt, from, time.Time{}), expected: bson.M{"createdat": bson.M{"$exists": true, "$gte": from}}},
+		"and or": {
+			query: And(Eq(FieldLastName, "bar"), Or(after(FieldID, "1"), before(FieldID, "0"))),
+			expected: bson.M{"$and": []bson.M{
+				{"lastname": "bar"},
+				{"$or": []bson.M{{"id": bson.M{"$gt": "1"}}, {"id": bson.M{"$lt": "0"}}}},
+			}},
+		},
+	}
+	for name, test := range tests {
+		assert.Equal(t, test.expected, mongoQuery(test.query), name)
+	}
+	assert.Equal(t, bson.D{{Key: "lastname", Value: -1}, {Key: "id", Value: -1}}, mongoSort([]Field{FieldLastName, FieldID}, true))
+}
+
+func TestSQLWhere(t *testing.T) {
+	from := time.Date(2018, 1, 1, 0, 0, 0, 0, time.FixedZone("CET", 3600))
+	tests := map[string]struct {
+		query        Query
+		expected     string
+		expectedArgs []interface{}
+	}{
+		"all":     {query: All(), expected: "1 = 1"},
+		"none":    {query: Or(), expected: "1 = 0"},
+		"in none": {query: In(FieldID), expected: "1 = 0"},
+		"eq":      {query: Eq(FieldFirstName, "foo"), expected: "first_name = $1", expectedArgs: []interface{}{"foo"}},
+		"in":      {query: In(FieldID, "1", "2"), expected: "id IN ($1, $2)", expectedArgs: []interface{}{"1", "2"}},
+		"prefix":  {query: Prefix(FieldEmail, "fö%"), expected: "substr(email, 1, 3) = $1", expectedArgs: []interface{}{"fö%"}},
+		"role": {
+			query:        Eq(FieldRoles, "admin"),
+			expected:     "EXISTS (SELECT 1 FROM user_roles WHERE user_roles.user_id = users.id AND user_roles.role = $1)",
+			expectedArgs: []interface{}{"admin"},
+		},
+		"between": {
+			query:        Between(FieldCreatedAt, from, time.Time{}),
+			expected:     "created_at IS NOT NULL AND created_at >= $1",
+			expectedArgs: []interface{}{from.UTC()},
+		},
+		"and or": {
+			query:        And(Eq(FieldLastName, "bar"), Or(after(FieldID, "1"), before(FieldID, "0"))),
+			expected:     "(last_name = $1) AND ((id > $2) OR (id < $3))",
+			expectedArgs: []interface{}{"bar", "1", "0"},
+		},
+	}
+	for name, test := range tests {
+		b := sqlBuilder{dialect: dialects["postgres"]}
+		assert.Equal(t, test.expected, b.where(test.query), name)
+		assert.Equal(t, test.expectedArgs, b.args, name)
+	}
+	assert.Equal(t, "last_name DESC, id DESC", sqlSort([]Field{FieldLastName, FieldID}, true))
+}
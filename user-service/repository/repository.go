@@ -1,97 +1,126 @@
 package repository
 
 import (
+	"context"
 	"errors"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/darren-west/app/user-service/models"
-	"gopkg.in/mgo.v2"
+	"github.com/darren-west/app/utils/mongoutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/hashicorp/errwrap"
 )
 
-var EmptyMatcher = NewMatcher()
-
-type Matcher map[string]interface{}
-
-func (m Matcher) WithID(id string) Matcher {
-	m["id"] = id
-	return m
-}
-
-func (m Matcher) WithFirstName(name string) Matcher {
-	m["name"] = name
-	return m
-}
-
-func NewMatcher() Matcher {
-	return Matcher(make(map[string]interface{}))
-}
-
 type Options struct {
 	ConnectionString string
 	DatabaseName     string
 	CollectionName   string
+	MinPoolSize      uint64
+	MaxPoolSize      uint64
+	ReadConcern      string
+	WriteConcern     string
 }
 
 type MongoUserRepository struct {
-	options Options
-	session *mgo.Session
+	options    Options
+	collection *mongo.Collection
 }
 
+// IsErrDuplicateUser returns true if the user could not be created or updated because its id or email is used by
+// another user.
 func IsErrDuplicateUser(err error) bool {
-	return mgo.IsDup(err)
+	return errwrap.Contains(err, "duplicate user")
 }
 
 func IsErrUserNotFound(err error) bool {
 	return errwrap.Contains(err, "user not found")
 }
 
-func (r MongoUserRepository) FindUser(m Matcher) (user models.UserInfo, err error) {
-	err = r.run(func(c *mgo.Collection) error {
-		return c.Find(m).One(&user)
-	})
-	if err == mgo.ErrNotFound {
+// byID sorts users by id, so the same user is found when more than one matches.
+var byID = bson.D{{Key: mongoField(FieldID), Value: 1}}
+
+// FindUser returns the user that matches, the one with the lowest id when more than one does.
+func (r MongoUserRepository) FindUser(ctx context.Context, q Query) (user models.UserInfo, err error) {
+	if err = q.Validate(); err != nil {
+		return
+	}
+	err = r.collection.FindOne(ctx, mongoQuery(q), options.FindOne().SetSort(byID)).Decode(&user)
+	if err == mongo.ErrNoDocuments {
 		err = errwrap.Wrap(errors.New("user not found"), err)
 		return
 	}
 	return
 }
 
-func (r MongoUserRepository) ListUsers(m Matcher) (users []models.UserInfo, err error) {
-	err = r.run(func(c *mgo.Collection) error {
-		return c.Find(m).All(&users)
-	})
-	return
+// ListUsers returns the page of users that match. The next cursor is empty when there are no more users, see
+// Page for how to get the next page.
+func (r MongoUserRepository) ListUsers(ctx context.Context, q Query, page Page) (users []models.UserInfo, next string, err error) {
+	if err = q.Validate(); err != nil {
+		return
+	}
+	query, sort, err := page.query(q)
+	if err != nil {
+		return
+	}
+	opts := options.Find().SetSort(mongoSort(sort, page.Descending))
+	if page.Limit > 0 {
+		opts.SetLimit(int64(page.Limit + 1))
+	}
+	cursor, err := r.collection.Find(ctx, mongoQuery(query), opts)
+	if err != nil {
+		return
+	}
+	if err = cursor.All(ctx, &users); err != nil {
+		return
+	}
+	return page.next(users)
 }
 
-func (r MongoUserRepository) CreateUser(user models.UserInfo) (err error) {
-	err = r.run(func(c *mgo.Collection) error {
-		return c.Insert(&user)
-	})
+// CreateUser stores the user, with its created and updated times set to now.
+func (r MongoUserRepository) CreateUser(ctx context.Context, user models.UserInfo) (err error) {
+	now := timestamp()
+	user.CreatedAt, user.UpdatedAt = &now, &now
+	_, err = r.collection.InsertOne(ctx, &user)
+	if mongo.IsDuplicateKeyError(err) {
+		err = errwrap.Wrap(errors.New("duplicate user"), err)
+	}
 	return
 }
 
-func (r MongoUserRepository) RemoveUser(m Matcher) (err error) {
-	err = r.run(func(c *mgo.Collection) error {
-		return c.Remove(m)
-	})
-	if err == mgo.ErrNotFound {
+// RemoveUser removes the user that matches, the one with the lowest id when more than one does.
+func (r MongoUserRepository) RemoveUser(ctx context.Context, q Query) (err error) {
+	if err = q.Validate(); err != nil {
+		return
+	}
+	err = r.collection.FindOneAndDelete(ctx, mongoQuery(q), options.FindOneAndDelete().SetSort(byID)).Err()
+	if err == mongo.ErrNoDocuments {
 		err = errwrap.Wrap(errors.New("user not found"), err)
 		return
 	}
 	return
 }
 
-func (r MongoUserRepository) UpdateUser(user models.UserInfo) (err error) {
-	m := NewMatcher().WithID(user.ID)
-	err = r.run(func(c *mgo.Collection) error {
-		return c.Update(m, &user)
-	})
-	if err == mgo.ErrNotFound {
-		err = errwrap.Wrap(errors.New("user not found"), err)
+// UpdateUser replaces the user with the same id, keeping the time it was created and setting its updated time to
+// now.
+func (r MongoUserRepository) UpdateUser(ctx context.Context, user models.UserInfo) (err error) {
+	now := timestamp()
+	user.CreatedAt, user.UpdatedAt = nil, &now
+	result, err := r.collection.UpdateOne(ctx, mongoQuery(Eq(FieldID, user.ID)), bson.M{"$set": &user})
+	if mongo.IsDuplicateKeyError(err) {
+		err = errwrap.Wrap(errors.New("duplicate user"), err)
+	}
+	if err != nil {
 		return
 	}
+	if result.MatchedCount == 0 {
+		err = errors.New("user not found")
+	}
 	return
 }
 
@@ -99,28 +128,97 @@ func (r MongoUserRepository) Options() Options {
 	return r.options
 }
 
-func (r MongoUserRepository) run(f func(c *mgo.Collection) error) error {
-	session := r.session.Clone()
-	defer session.Close()
-	return f(session.DB(r.options.DatabaseName).C(r.options.CollectionName))
+// timestamp returns the time now, to the millisecond as that is how times are stored.
+func timestamp() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// mongoField returns the name of the field in the collection, fields are stored with their lowercased name.
+func mongoField(field Field) string {
+	return strings.ToLower(string(field))
 }
 
+// mongoQuery translates the query to a query document.
+func mongoQuery(q Query) bson.M {
+	field := mongoField(q.field)
+	switch q.op {
+	case opAnd, opOr:
+		if len(q.queries) == 0 && q.op == opAnd {
+			return bson.M{}
+		}
+		if len(q.queries) == 0 {
+			return bson.M{mongoField(FieldID): bson.M{"$in": []interface{}{}}}
+		}
+		queries := make([]bson.M, len(q.queries))
+		for i, query := range q.queries {
+			queries[i] = mongoQuery(query)
+		}
+		if q.op == opAnd {
+			return bson.M{"$and": queries}
+		}
+		return bson.M{"$or": queries}
+	case opIn:
+		return bson.M{field: bson.M{"$in": append([]interface{}{}, q.values...)}}
+	case opPrefix:
+		return bson.M{field: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(q.values[0].(string))}}
+	case opRange:
+		r := bson.M{"$exists": true}
+		if !q.from.IsZero() {
+			r["$gte"] = q.from
+		}
+		if !q.to.IsZero() {
+			r["$lt"] = q.to
+		}
+		return bson.M{field: r}
+	case opAfter:
+		return bson.M{field: bson.M{"$gt": q.values[0]}}
+	case opBefore:
+		return bson.M{field: bson.M{"$lt": q.values[0]}}
+	default:
+		return bson.M{field: q.values[0]}
+	}
+}
+
+// mongoSort returns the sort document for the fields.
+func mongoSort(fields []Field, descending bool) bson.D {
+	order := 1
+	if descending {
+		order = -1
+	}
+	sort := make(bson.D, len(fields))
+	for i, field := range fields {
+		sort[i] = bson.E{Key: mongoField(field), Value: order}
+	}
+	return sort
+}
+
+// NewMongoUserRepository connects to mongo and ensures the ids and emails of users are unique. It fails if mongo can't be
+// reached within 30 seconds.
 func NewMongoUserRepository(opts ...Option) (repo MongoUserRepository, err error) {
 	repo = MongoUserRepository{
 		options: Options{},
 	}
 	for _, opt := range opts {
-		opt(&repo.options)
+		if err = opt(&repo.options); err != nil {
+			return
+		}
 	}
-	session, err := mgo.DialWithTimeout(repo.options.ConnectionString, time.Second*30)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	client, err := mongoutil.Connect(ctx, mongoutil.Options{
+		ConnectionString: repo.options.ConnectionString,
+		MinPoolSize:      repo.options.MinPoolSize,
+		MaxPoolSize:      repo.options.MaxPoolSize,
+		ReadConcern:      repo.options.ReadConcern,
+		WriteConcern:     repo.options.WriteConcern,
+	})
 	if err != nil {
 		return
 	}
-	repo.session = session
-	session.SetMode(mgo.Monotonic, true)
-	err = session.DB(repo.options.DatabaseName).C(repo.options.CollectionName).EnsureIndex(mgo.Index{
-		Key:    []string{"id"},
-		Unique: true,
+	repo.collection = client.Database(repo.options.DatabaseName).Collection(repo.options.CollectionName)
+	_, err = repo.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: mongoField(FieldID), Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: mongoField(FieldEmail), Value: 1}}, Options: options.Index().SetUnique(true)},
 	})
 
 	return
@@ -148,3 +246,28 @@ func WithCollectionName(c string) Option {
 		return
 	}
 }
+
+// WithPoolSize sets the least and most connections kept to each mongo server, the driver defaults are used when
+// they are 0.
+func WithPoolSize(min, max uint64) Option {
+	return func(o *Options) (err error) {
+		o.MinPoolSize, o.MaxPoolSize = min, max
+		return
+	}
+}
+
+// WithReadConcern sets the read concern level, see mongoutil.ReadConcernMajority for the levels.
+func WithReadConcern(level string) Option {
+	return func(o *Options) (err error) {
+		o.ReadConcern = level
+		return
+	}
+}
+
+// WithWriteConcern sets the number of servers that must acknowledge writes, or majority.
+func WithWriteConcern(w string) Option {
+	return func(o *Options) (err error) {
+		o.WriteConcern = w
+		return
+	}
+}
@@ -8,14 +8,17 @@ import (
 	"io/ioutil"
 	"testing"
 
-	"gopkg.in/mgo.v2/bson"
-
-	"gopkg.in/mgo.v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"github.com/darren-west/app/user-service/controller"
 	"github.com/darren-west/app/user-service/models"
+	"github.com/darren-west/app/user-service/repository/testutils/conformance"
 	"github.com/darren-west/app/user-service/repository/testutils/container"
 
 	"github.com/darren-west/app/user-service/repository"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -23,11 +26,32 @@ func TestRepositorySuite(t *testing.T) {
 	suite.Run(t, &RepositorySuite{})
 }
 
+func TestMongoUserRepository(t *testing.T) {
+	manager, err := container.NewManager(
+		container.WithPortMapping(container.PortMapping{}.With("27017/tcp", "27017/tcp")),
+		container.WithImage("mongo:latest"),
+		container.WithWriter(ioutil.Discard),
+	)
+	require.NoError(t, err)
+	require.NoError(t, manager.Start(context.Background()))
+	defer manager.Stop(context.Background())
+
+	databases := 0
+	suite.Run(t, &conformance.Suite{NewRepository: func() (controller.UserRepository, error) {
+		databases++
+		return repository.NewMongoUserRepository(
+			repository.WithConnectionString("mongodb://127.0.0.1:27017"),
+			repository.WithDatabaseName(fmt.Sprintf("conformance%d", databases)),
+			repository.WithCollectionName("users"),
+		)
+	}})
+}
+
 type RepositorySuite struct {
 	suite.Suite
 	repo    repository.MongoUserRepository
 	manager *container.Manager
-	session *mgo.Session
+	client  *mongo.Client
 }
 
 func (rs *RepositorySuite) SetupTest() {
@@ -46,107 +70,152 @@ func (rs *RepositorySuite) SetupTest() {
 	)
 	rs.Require().NoError(err)
 	rs.repo = repo
-	rs.session, err = mgo.Dial("mongodb://127.0.0.1:27017")
+	rs.client, err = mongo.Connect(ctx, options.Client().ApplyURI("mongodb://127.0.0.1:27017"))
 	rs.Require().NoError(err)
 }
 
 func (rs *RepositorySuite) TearDownTest() {
-	rs.session.Close()
+	rs.client.Disconnect(ctx)
 	rs.Require().NoError(rs.manager.Stop(context.Background()))
 }
 
 func (rs *RepositorySuite) TestFindUser() {
 	expectedUser := models.UserInfo{ID: "1234", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}
-	rs.collection().Insert(&expectedUser)
+	_, err := rs.collection().InsertOne(ctx, &expectedUser)
+	rs.Require().NoError(err)
 
-	user, err := rs.repo.FindUser(repository.NewMatcher().WithID("1234"))
+	user, err := rs.repo.FindUser(ctx, repository.Eq(repository.FieldID, "1234"))
 	rs.Require().NoError(err)
 	rs.Assert().Equal(expectedUser, user)
 }
 
 func (rs *RepositorySuite) TestFindUserNotFound() {
-	user, err := rs.repo.FindUser(repository.NewMatcher().WithID("1234"))
+	user, err := rs.repo.FindUser(ctx, repository.Eq(repository.FieldID, "1234"))
 	rs.Assert().True(repository.IsErrUserNotFound(err))
 	rs.Assert().Zero(user)
 }
 
-func (rs *RepositorySuite) collection() *mgo.Collection {
-	return rs.session.DB(rs.repo.Options().DatabaseName).C(rs.repo.Options().CollectionName)
+func (rs *RepositorySuite) collection() *mongo.Collection {
+	return rs.client.Database(rs.repo.Options().DatabaseName).Collection(rs.repo.Options().CollectionName)
 }
 
 func (rs *RepositorySuite) TestCreateUser() {
 	expectedUser := models.UserInfo{ID: "123", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}
-	rs.Require().NoError(rs.repo.CreateUser(expectedUser))
+	rs.Require().NoError(rs.repo.CreateUser(ctx, expectedUser))
 
 	user := models.UserInfo{}
-	rs.Require().NoError(rs.collection().Find(bson.M{"id": "123"}).One(&user))
+	rs.Require().NoError(rs.collection().FindOne(ctx, bson.M{"id": "123"}).Decode(&user))
+	rs.Require().NotNil(user.CreatedAt)
+	rs.Assert().Equal(user.CreatedAt, user.UpdatedAt)
+	user.CreatedAt, user.UpdatedAt = nil, nil
 	rs.Assert().Equal(expectedUser, user)
 }
 
 func (rs *RepositorySuite) TestRemoveUser() {
 	expectedUser := models.UserInfo{ID: "1234", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}
-	rs.Require().NoError(rs.collection().Insert(&expectedUser))
+	_, err := rs.collection().InsertOne(ctx, &expectedUser)
+	rs.Require().NoError(err)
 
-	rs.Require().NoError(rs.repo.RemoveUser(repository.NewMatcher().WithID("1234")))
+	rs.Require().NoError(rs.repo.RemoveUser(ctx, repository.Eq(repository.FieldID, "1234")))
 
-	count, err := rs.collection().Find(bson.M{"id": "1234"}).Count()
+	count, err := rs.collection().CountDocuments(ctx, bson.M{"id": "1234"})
 	rs.Require().NoError(err)
-	rs.Assert().Equal(count, 0)
+	rs.Assert().Equal(int64(0), count)
 }
 
 func (rs *RepositorySuite) TestUpdateUser() {
 	user := models.UserInfo{ID: "12345", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}
-	rs.Require().NoError(rs.collection().Insert(user))
+	rs.Require().NoError(rs.repo.CreateUser(ctx, user))
+	created, err := rs.repo.FindUser(ctx, repository.Eq(repository.FieldID, "12345"))
+	rs.Require().NoError(err)
 
 	user.FirstName = "bar"
 	user.LastName = "foo"
 	user.Email = "foo@email.com"
-	rs.Assert().NoError(rs.repo.UpdateUser(user))
-	rs.Assert().Equal(models.UserInfo{ID: "12345", FirstName: "bar", LastName: "foo", Email: "foo@email.com"}, user)
+	rs.Assert().NoError(rs.repo.UpdateUser(ctx, user))
+
+	updated, err := rs.repo.FindUser(ctx, repository.Eq(repository.FieldID, "12345"))
+	rs.Require().NoError(err)
+	rs.Assert().Equal(created.CreatedAt, updated.CreatedAt, "the created time should be kept")
+	rs.Assert().False(updated.UpdatedAt.Before(*created.UpdatedAt))
+	updated.CreatedAt, updated.UpdatedAt = nil, nil
+	rs.Assert().Equal(models.UserInfo{ID: "12345", FirstName: "bar", LastName: "foo", Email: "foo@email.com"}, updated)
 }
 
 func (rs *RepositorySuite) TestUpdateUserNotFound() {
 	user := models.UserInfo{ID: "12345", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}
-	rs.Assert().True(repository.IsErrUserNotFound(rs.repo.UpdateUser(user)))
+	rs.Assert().True(repository.IsErrUserNotFound(rs.repo.UpdateUser(ctx, user)))
 }
 
 func (rs *RepositorySuite) TestRemoveUserNotFound() {
-	err := rs.repo.RemoveUser(repository.NewMatcher().WithID("1234"))
+	err := rs.repo.RemoveUser(ctx, repository.Eq(repository.FieldID, "1234"))
 	rs.Assert().True(repository.IsErrUserNotFound(err))
 }
 
 func (rs *RepositorySuite) TestListUser() {
 	for i := 0; i < 100; i++ {
-		rs.Require().NoError(rs.collection().Insert(&models.UserInfo{ID: fmt.Sprintf("%d", i), FirstName: "foo", LastName: "bar", Email: "foo@email.com"}))
+		_, err := rs.collection().InsertOne(ctx, &models.UserInfo{ID: fmt.Sprintf("%d", i), FirstName: "foo", LastName: "bar", Email: "foo@email.com"})
+		rs.Require().NoError(err)
 	}
 
-	users, err := rs.repo.ListUsers(repository.EmptyMatcher)
+	users, next, err := rs.repo.ListUsers(ctx, repository.All(), repository.Page{})
 	rs.Require().NoError(err)
 	rs.Len(users, 100)
+	rs.Empty(next)
+}
+
+func (rs *RepositorySuite) TestListUserPages() {
+	for i := 0; i < 25; i++ {
+		_, err := rs.collection().InsertOne(ctx, &models.UserInfo{ID: fmt.Sprintf("%02d", i), FirstName: "foo", LastName: fmt.Sprintf("bar%d", i%3), Email: "foo@email.com"})
+		rs.Require().NoError(err)
+	}
+
+	var ids []string
+	page := repository.Page{SortField: repository.FieldLastName, Descending: true, Limit: 10}
+	for pages := 1; ; pages++ {
+		users, next, err := rs.repo.ListUsers(ctx, repository.Prefix(repository.FieldLastName, "bar"), page)
+		rs.Require().NoError(err)
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+		if next == "" {
+			rs.Equal(3, pages)
+			break
+		}
+		page.Cursor = next
+	}
+	rs.Len(ids, 25)
+	rs.Equal([]string{"23", "20", "17"}, ids[:3], "users should be sorted by last name descending, then id descending")
 }
 
-func (rs *RepositorySuite) TestListUserMatcher() {
+func (rs *RepositorySuite) TestListUserQuery() {
 	for i := 0; i < 50; i++ {
-		rs.Require().NoError(rs.collection().Insert(&models.UserInfo{ID: fmt.Sprintf("%d", i), FirstName: "foo", LastName: "bar", Email: "foo@email.com"}))
+		_, err := rs.collection().InsertOne(ctx, &models.UserInfo{ID: fmt.Sprintf("%d", i), FirstName: "foo", LastName: "bar", Email: "foo@email.com"})
+		rs.Require().NoError(err)
 	}
 
-	users, err := rs.repo.ListUsers(repository.NewMatcher().WithID("1"))
+	users, _, err := rs.repo.ListUsers(ctx, repository.Eq(repository.FieldID, "1"), repository.Page{})
 	rs.Require().NoError(err)
 	rs.Len(users, 1)
 }
 
 func (rs *RepositorySuite) TestListUsersNone() {
-	users, err := rs.repo.ListUsers(repository.EmptyMatcher)
+	users, _, err := rs.repo.ListUsers(ctx, repository.All(), repository.Page{})
 	rs.Assert().NoError(err)
 	rs.Assert().Len(users, 0)
 }
 
 func (rs *RepositorySuite) TestIndexCreated() {
-	indexs, err := rs.collection().Indexes()
+	cursor, err := rs.collection().Indexes().List(ctx)
 	rs.Require().NoError(err)
+	var indexs []struct {
+		Key    bson.D `bson:"key"`
+		Unique bool   `bson:"unique"`
+	}
+	rs.Require().NoError(cursor.All(ctx, &indexs))
 
 	rs.Require().Len(indexs, 2)
 	rs.Assert().Len(indexs[1].Key, 1)
-	rs.Assert().Equal("id", indexs[1].Key[0])
+	rs.Assert().Equal("id", indexs[1].Key[0].Key)
 	rs.Assert().Equal(true, indexs[1].Unique)
 }
@@ -0,0 +1,427 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/darren-west/app/user-service/models"
+	"github.com/hashicorp/errwrap"
+	"github.com/lib/pq"
+)
+
+// dialect is how the sql of a database differs, for each driver the repository supports.
+type dialect struct {
+	// placeholder returns the placeholder of the nth argument of a statement, counting from 1.
+	placeholder func(n int) string
+	// timeType is the column type times are stored as.
+	timeType string
+	// lock is run at the start of each migration so migrations are not run at the same time by many services.
+	lock string
+	// isDuplicate returns true if the error is because a unique constraint was violated.
+	isDuplicate func(error) bool
+}
+
+// dialects are the dialects of the drivers, by driver name. SQLite is only supported when built with cgo.
+var dialects = map[string]dialect{
+	"postgres": {
+		placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+		timeType:    "TIMESTAMP WITH TIME ZONE",
+		lock:        "SELECT pg_advisory_xact_lock(7425164)",
+		isDuplicate: func(err error) bool {
+			e, ok := err.(*pq.Error)
+			return ok && e.Code == "23505"
+		},
+	},
+}
+
+// migrations create and change the tables users are stored in, they are run in order when the repository is
+// created and each one is only run once. Never change a migration, add another one.
+var migrations = []func(d dialect) []string{
+	func(d dialect) []string {
+		return []string{
+			`CREATE TABLE users (
+				id TEXT PRIMARY KEY,
+				first_name TEXT NOT NULL,
+				last_name TEXT NOT NULL,
+				email TEXT NOT NULL UNIQUE,
+				created_at ` + d.timeType + `,
+				updated_at ` + d.timeType + `
+			)`,
+			`CREATE TABLE user_roles (
+				user_id TEXT NOT NULL REFERENCES users (id),
+				position INTEGER NOT NULL,
+				role TEXT NOT NULL,
+				PRIMARY KEY (user_id, position)
+			)`,
+			`CREATE INDEX user_roles_role ON user_roles (role)`,
+		}
+	},
+}
+
+// sqlColumns are the columns of the users table for each field. The roles are in the user_roles table.
+var sqlColumns = map[Field]string{
+	FieldID:        "id",
+	FieldFirstName: "first_name",
+	FieldLastName:  "last_name",
+	FieldEmail:     "email",
+	FieldCreatedAt: "created_at",
+	FieldUpdatedAt: "updated_at",
+}
+
+const selectUsers = "SELECT id, first_name, last_name, email, created_at, updated_at FROM users"
+
+// SQLUserRepository stores users in a sql database, PostgreSQL or SQLite. IDs and emails are unique, creating or
+// updating a user with the id or email of another user is a duplicate user error. Create one with
+// NewSQLUserRepository.
+type SQLUserRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// NewSQLUserRepository opens the database with the driver, postgres or sqlite3, and migrates it to the latest
+// schema. The data source is the connection string of the driver.
+func NewSQLUserRepository(driver, dataSource string) (*SQLUserRepository, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sql driver %s", driver)
+	}
+	db, err := sql.Open(driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	r := &SQLUserRepository{db: db, dialect: d}
+	if err = r.migrate(); err != nil {
+		db.Close()
+		return nil, errwrap.Wrapf("failed to migrate database: {{err}}", err)
+	}
+	return r, nil
+}
+
+// Close closes the database.
+func (r *SQLUserRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLUserRepository) migrate() error {
+	if _, err := r.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	for i, migration := range migrations {
+		version := i + 1
+		err := r.tx(ctx, func(tx *sql.Tx) error {
+			if r.dialect.lock != "" {
+				if _, err := tx.Exec(r.dialect.lock); err != nil {
+					return err
+				}
+			}
+			var current int
+			if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+				return err
+			}
+			if current >= version {
+				return nil
+			}
+			for _, statement := range migration(r.dialect) {
+				if _, err := tx.Exec(statement); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ("+r.dialect.placeholder(1)+")", version)
+			return err
+		})
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("migration %d failed: {{err}}", version), err)
+		}
+	}
+	return nil
+}
+
+// FindUser returns the user that matches, the one with the lowest id when more than one does.
+func (r *SQLUserRepository) FindUser(ctx context.Context, q Query) (user models.UserInfo, err error) {
+	if err = q.Validate(); err != nil {
+		return
+	}
+	users, err := r.query(ctx, r.db, q, []Field{FieldID}, false, 1)
+	if err != nil {
+		return
+	}
+	if len(users) == 0 {
+		return user, errors.New("user not found")
+	}
+	return users[0], nil
+}
+
+// ListUsers returns the page of users that match. The next cursor is empty when there are no more users, see
+// Page for how to get the next page.
+func (r *SQLUserRepository) ListUsers(ctx context.Context, q Query, page Page) (users []models.UserInfo, next string, err error) {
+	if err = q.Validate(); err != nil {
+		return
+	}
+	query, fields, err := page.query(q)
+	if err != nil {
+		return
+	}
+	limit := 0
+	if page.Limit > 0 {
+		limit = page.Limit + 1
+	}
+	if users, err = r.query(ctx, r.db, query, fields, page.Descending, limit); err != nil {
+		return
+	}
+	return page.next(users)
+}
+
+// CreateUser stores the user, with its created and updated times set to now.
+func (r *SQLUserRepository) CreateUser(ctx context.Context, user models.UserInfo) error {
+	now := timestamp()
+	err := r.tx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, r.statement("INSERT INTO users (id, first_name, last_name, email, created_at, updated_at) VALUES (%s, %s, %s, %s, %s, %s)"),
+			user.ID, user.FirstName, user.LastName, user.Email, now, now)
+		if err != nil {
+			return err
+		}
+		return r.insertRoles(ctx, tx, user)
+	})
+	return r.mapError(err)
+}
+
+// RemoveUser removes the user that matches, the one with the lowest id when more than one does.
+func (r *SQLUserRepository) RemoveUser(ctx context.Context, q Query) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	return r.tx(ctx, func(tx *sql.Tx) error {
+		users, err := r.query(ctx, tx, q, []Field{FieldID}, false, 1)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return errors.New("user not found")
+		}
+		if _, err = tx.ExecContext(ctx, r.statement("DELETE FROM user_roles WHERE user_id = %s"), users[0].ID); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, r.statement("DELETE FROM users WHERE id = %s"), users[0].ID)
+		return err
+	})
+}
+
+// UpdateUser replaces the user with the same id, keeping the time it was created and setting its updated time to
+// now.
+func (r *SQLUserRepository) UpdateUser(ctx context.Context, user models.UserInfo) error {
+	now := timestamp()
+	err := r.tx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, r.statement("UPDATE users SET first_name = %s, last_name = %s, email = %s, updated_at = %s WHERE id = %s"),
+			user.FirstName, user.LastName, user.Email, now, user.ID)
+		if err != nil {
+			return err
+		}
+		if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+			if err == nil {
+				err = errors.New("user not found")
+			}
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, r.statement("DELETE FROM user_roles WHERE user_id = %s"), user.ID); err != nil {
+			return err
+		}
+		return r.insertRoles(ctx, tx, user)
+	})
+	return r.mapError(err)
+}
+
+func (r *SQLUserRepository) insertRoles(ctx context.Context, tx *sql.Tx, user models.UserInfo) error {
+	for i, role := range user.Roles {
+		if _, err := tx.ExecContext(ctx, r.statement("INSERT INTO user_roles (user_id, position, role) VALUES (%s, %s, %s)"), user.ID, i, role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapError wraps unique constraint violations, so they are duplicate user errors.
+func (r *SQLUserRepository) mapError(err error) error {
+	if err != nil && r.dialect.isDuplicate(err) {
+		return errwrap.Wrap(errors.New("duplicate user"), err)
+	}
+	return err
+}
+
+// statement returns the statement with each %s replaced by the placeholder of the next argument.
+func (r *SQLUserRepository) statement(format string) string {
+	placeholders := make([]interface{}, strings.Count(format, "%s"))
+	for i := range placeholders {
+		placeholders[i] = r.dialect.placeholder(i + 1)
+	}
+	return fmt.Sprintf(format, placeholders...)
+}
+
+func (r *SQLUserRepository) tx(ctx context.Context, f func(*sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// query returns the users that match, sorted by the fields, with their roles. All the users are returned when the
+// limit is 0.
+func (r *SQLUserRepository) query(ctx context.Context, db queryer, q Query, sort []Field, descending bool, limit int) ([]models.UserInfo, error) {
+	b := sqlBuilder{dialect: r.dialect}
+	statement := selectUsers + " WHERE " + b.where(q) + " ORDER BY " + sqlSort(sort, descending)
+	if limit > 0 {
+		statement += " LIMIT " + b.arg(limit)
+	}
+	rows, err := db.QueryContext(ctx, statement, b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []models.UserInfo
+	for rows.Next() {
+		var user models.UserInfo
+		if err = rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			return nil, err
+		}
+		user.CreatedAt, user.UpdatedAt = utc(user.CreatedAt), utc(user.UpdatedAt)
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, r.roles(ctx, db, users)
+}
+
+// roles sets the roles of the users.
+func (r *SQLUserRepository) roles(ctx context.Context, db queryer, users []models.UserInfo) error {
+	if len(users) == 0 {
+		return nil
+	}
+	b := sqlBuilder{dialect: r.dialect}
+	index := make(map[string]int, len(users))
+	placeholders := make([]string, len(users))
+	for i, user := range users {
+		index[user.ID] = i
+		placeholders[i] = b.arg(user.ID)
+	}
+	rows, err := db.QueryContext(ctx, "SELECT user_id, role FROM user_roles WHERE user_id IN ("+strings.Join(placeholders, ", ")+") ORDER BY user_id, position", b.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, role string
+		if err = rows.Scan(&id, &role); err != nil {
+			return err
+		}
+		users[index[id]].Roles = append(users[index[id]].Roles, role)
+	}
+	return rows.Err()
+}
+
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+func sqlSort(fields []Field, descending bool) string {
+	sort := make([]string, len(fields))
+	for i, field := range fields {
+		sort[i] = sqlColumns[field]
+		if descending {
+			sort[i] += " DESC"
+		}
+	}
+	return strings.Join(sort, ", ")
+}
+
+// sqlBuilder translates queries to sql conditions, collecting the arguments of the conditions.
+type sqlBuilder struct {
+	dialect dialect
+	args    []interface{}
+}
+
+// arg adds the argument and returns its placeholder.
+func (b *sqlBuilder) arg(value interface{}) string {
+	if t, ok := value.(time.Time); ok {
+		value = t.UTC()
+	}
+	b.args = append(b.args, value)
+	return b.dialect.placeholder(len(b.args))
+}
+
+// where returns the condition users must match for the query. Conditions on the roles match users with any role
+// matching.
+func (b *sqlBuilder) where(q Query) string {
+	switch q.op {
+	case opAnd, opOr:
+		if len(q.queries) == 0 && q.op == opAnd {
+			return "1 = 1"
+		}
+		if len(q.queries) == 0 {
+			return "1 = 0"
+		}
+		conditions := make([]string, len(q.queries))
+		for i, query := range q.queries {
+			conditions[i] = "(" + b.where(query) + ")"
+		}
+		if q.op == opAnd {
+			return strings.Join(conditions, " AND ")
+		}
+		return strings.Join(conditions, " OR ")
+	}
+	if q.field == FieldRoles {
+		return "EXISTS (SELECT 1 FROM user_roles WHERE user_roles.user_id = users.id AND " + b.condition("user_roles.role", q) + ")"
+	}
+	return b.condition(sqlColumns[q.field], q)
+}
+
+func (b *sqlBuilder) condition(column string, q Query) string {
+	switch q.op {
+	case opIn:
+		if len(q.values) == 0 {
+			return "1 = 0"
+		}
+		placeholders := make([]string, len(q.values))
+		for i, value := range q.values {
+			placeholders[i] = b.arg(value)
+		}
+		return column + " IN (" + strings.Join(placeholders, ", ") + ")"
+	case opPrefix:
+		// substr rather than LIKE, LIKE is not case sensitive in SQLite and the prefix would need escaping.
+		prefix := q.values[0].(string)
+		return fmt.Sprintf("substr(%s, 1, %d) = %s", column, utf8.RuneCountInString(prefix), b.arg(prefix))
+	case opRange:
+		conditions := []string{column + " IS NOT NULL"}
+		if !q.from.IsZero() {
+			conditions = append(conditions, column+" >= "+b.arg(q.from))
+		}
+		if !q.to.IsZero() {
+			conditions = append(conditions, column+" < "+b.arg(q.to))
+		}
+		return strings.Join(conditions, " AND ")
+	case opAfter:
+		return column + " > " + b.arg(q.values[0])
+	case opBefore:
+		return column + " < " + b.arg(q.values[0])
+	default:
+		return column + " = " + b.arg(q.values[0])
+	}
+}
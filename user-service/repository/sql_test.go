@@ -0,0 +1,58 @@
+// +build cgo
+
+package repository_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/darren-west/app/user-service/controller"
+	"github.com/darren-west/app/user-service/models"
+	"github.com/darren-west/app/user-service/repository"
+	"github.com/darren-west/app/user-service/repository/testutils/conformance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "users")
+	require.NoError(t, err)
+	return dir
+}
+
+func TestSQLUserRepositorySQLite(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	databases := 0
+	suite.Run(t, &conformance.Suite{NewRepository: func() (controller.UserRepository, error) {
+		databases++
+		return repository.NewSQLUserRepository("sqlite3", filepath.Join(dir, fmt.Sprintf("users%d.db", databases)))
+	}})
+}
+
+func TestSQLUserRepositoryMigratesOnce(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.db")
+	repo, err := repository.NewSQLUserRepository("sqlite3", path)
+	require.NoError(t, err)
+	require.NoError(t, repo.CreateUser(ctx, models.UserInfo{ID: "1", Email: "foo@email.com", Roles: []string{models.RoleAdmin}}))
+	require.NoError(t, repo.Close())
+
+	repo, err = repository.NewSQLUserRepository("sqlite3", path)
+	require.NoError(t, err, "migrations that have been run should not be run again")
+	defer repo.Close()
+	user, err := repo.FindUser(ctx, repository.Eq(repository.FieldID, "1"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.RoleAdmin}, user.Roles)
+}
+
+func TestSQLUserRepositoryUnsupportedDriver(t *testing.T) {
+	_, err := repository.NewSQLUserRepository("oracle", "")
+	assert.EqualError(t, err, "unsupported sql driver oracle")
+}
@@ -0,0 +1,20 @@
+// +build cgo
+
+package repository
+
+import (
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQLite needs cgo, so it is only supported by builds with cgo enabled. It is for running the service locally and in
+// tests, the service is built without cgo for deployment.
+func init() {
+	dialects["sqlite3"] = dialect{
+		placeholder: func(int) string { return "?" },
+		timeType:    "TIMESTAMP",
+		isDuplicate: func(err error) bool {
+			e, ok := err.(sqlite3.Error)
+			return ok && (e.ExtendedCode == sqlite3.ErrConstraintUnique || e.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+		},
+	}
+}
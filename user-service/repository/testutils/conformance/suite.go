@@ -0,0 +1,230 @@
+// Package conformance is the test suite every user repository must pass, so the service behaves the same
+// whichever repository it is configured with. Run it from the tests of a repository:
+//
+//	suite.Run(t, &conformance.Suite{NewRepository: func() (controller.UserRepository, error) {
+//		return repository.NewMemoryUserRepository(), nil
+//	}})
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/darren-west/app/user-service/controller"
+	"github.com/darren-west/app/user-service/models"
+	"github.com/darren-west/app/user-service/repository"
+	"github.com/stretchr/testify/suite"
+)
+
+// ctx is the context the repository is called with.
+var ctx = context.Background()
+
+// Suite tests a user repository.
+type Suite struct {
+	suite.Suite
+	// NewRepository returns the repository to test, it is called before each test and must return an empty one.
+	NewRepository func() (controller.UserRepository, error)
+
+	repo controller.UserRepository
+}
+
+func (s *Suite) SetupTest() {
+	repo, err := s.NewRepository()
+	s.Require().NoError(err)
+	s.repo = repo
+}
+
+func (s *Suite) TestCreateUser() {
+	start := time.Now().Add(-time.Second)
+	expectedUser := models.UserInfo{ID: "123", FirstName: "foo", LastName: "bar", Email: "foo@email.com", Roles: []string{models.RoleAdmin}}
+	s.Require().NoError(s.repo.CreateUser(ctx, expectedUser))
+
+	user, err := s.repo.FindUser(ctx, repository.Eq(repository.FieldID, "123"))
+	s.Require().NoError(err)
+	s.Require().NotNil(user.CreatedAt)
+	s.Assert().True(user.CreatedAt.After(start))
+	s.Assert().Equal(user.CreatedAt, user.UpdatedAt)
+	user.CreatedAt, user.UpdatedAt = nil, nil
+	s.Assert().Equal(expectedUser, user)
+}
+
+func (s *Suite) TestCreateUserDuplicate() {
+	user := models.UserInfo{ID: "123", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}
+	s.Require().NoError(s.repo.CreateUser(ctx, user))
+
+	user.Email = "bar@email.com"
+	err := s.repo.CreateUser(ctx, user)
+	s.Assert().True(repository.IsErrDuplicateUser(err), "expected duplicate user error, got %v", err)
+
+	found, err := s.repo.FindUser(ctx, repository.Eq(repository.FieldID, "123"))
+	s.Require().NoError(err)
+	s.Assert().Equal("foo@email.com", found.Email, "the duplicate user should not replace the user")
+}
+
+func (s *Suite) TestCreateUserDuplicateEmail() {
+	s.createUsers(models.UserInfo{ID: "1", Email: "foo@email.com"})
+
+	err := s.repo.CreateUser(ctx, models.UserInfo{ID: "2", Email: "foo@email.com"})
+	s.Assert().True(repository.IsErrDuplicateUser(err), "expected duplicate user error, got %v", err)
+	_, err = s.repo.FindUser(ctx, repository.Eq(repository.FieldID, "2"))
+	s.Assert().True(repository.IsErrUserNotFound(err), "the duplicate user should not be created, got %v", err)
+}
+
+func (s *Suite) TestCreateUserEmailReleased() {
+	s.createUsers(models.UserInfo{ID: "1", Email: "foo@email.com"}, models.UserInfo{ID: "2", Email: "bar@email.com"})
+	s.Require().NoError(s.repo.RemoveUser(ctx, repository.Eq(repository.FieldID, "1")))
+	s.Require().NoError(s.repo.UpdateUser(ctx, models.UserInfo{ID: "2", Email: "fizz@email.com"}))
+
+	s.Assert().NoError(s.repo.CreateUser(ctx, models.UserInfo{ID: "3", Email: "foo@email.com"}), "the email of a removed user should be free")
+	s.Assert().NoError(s.repo.CreateUser(ctx, models.UserInfo{ID: "4", Email: "bar@email.com"}), "the old email of an updated user should be free")
+}
+
+func (s *Suite) TestFindUserNotFound() {
+	user, err := s.repo.FindUser(ctx, repository.Eq(repository.FieldID, "1234"))
+	s.Assert().True(repository.IsErrUserNotFound(err), "expected user not found error, got %v", err)
+	s.Assert().Zero(user)
+}
+
+func (s *Suite) TestUpdateUser() {
+	user := models.UserInfo{ID: "12345", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}
+	s.Require().NoError(s.repo.CreateUser(ctx, user))
+	created, err := s.repo.FindUser(ctx, repository.Eq(repository.FieldID, "12345"))
+	s.Require().NoError(err)
+
+	user.FirstName = "bar"
+	user.LastName = "foo"
+	user.Roles = []string{models.RoleAdmin}
+	s.Require().NoError(s.repo.UpdateUser(ctx, user))
+
+	updated, err := s.repo.FindUser(ctx, repository.Eq(repository.FieldID, "12345"))
+	s.Require().NoError(err)
+	s.Assert().Equal(created.CreatedAt, updated.CreatedAt, "the created time should be kept")
+	s.Require().NotNil(updated.UpdatedAt)
+	s.Assert().False(updated.UpdatedAt.Before(*created.UpdatedAt))
+	updated.CreatedAt, updated.UpdatedAt = nil, nil
+	s.Assert().Equal(user, updated)
+}
+
+func (s *Suite) TestUpdateUserDuplicateEmail() {
+	s.createUsers(models.UserInfo{ID: "1", Email: "foo@email.com"}, models.UserInfo{ID: "2", Email: "bar@email.com"})
+
+	err := s.repo.UpdateUser(ctx, models.UserInfo{ID: "2", Email: "foo@email.com"})
+	s.Assert().True(repository.IsErrDuplicateUser(err), "expected duplicate user error, got %v", err)
+
+	user, err := s.repo.FindUser(ctx, repository.Eq(repository.FieldID, "2"))
+	s.Require().NoError(err)
+	s.Assert().Equal("bar@email.com", user.Email, "the failed update should not change the user")
+}
+
+func (s *Suite) TestUpdateUserNotFound() {
+	user := models.UserInfo{ID: "12345", FirstName: "foo", LastName: "bar", Email: "foo@email.com"}
+	err := s.repo.UpdateUser(ctx, user)
+	s.Assert().True(repository.IsErrUserNotFound(err), "expected user not found error, got %v", err)
+}
+
+func (s *Suite) TestRemoveUser() {
+	s.createUsers(models.UserInfo{ID: "1", Email: "1@email.com"}, models.UserInfo{ID: "2", Email: "2@email.com"})
+
+	s.Require().NoError(s.repo.RemoveUser(ctx, repository.Eq(repository.FieldID, "1")))
+
+	_, err := s.repo.FindUser(ctx, repository.Eq(repository.FieldID, "1"))
+	s.Assert().True(repository.IsErrUserNotFound(err), "expected user not found error, got %v", err)
+	_, err = s.repo.FindUser(ctx, repository.Eq(repository.FieldID, "2"))
+	s.Assert().NoError(err)
+}
+
+func (s *Suite) TestRemoveUserNotFound() {
+	err := s.repo.RemoveUser(ctx, repository.Eq(repository.FieldID, "1234"))
+	s.Assert().True(repository.IsErrUserNotFound(err), "expected user not found error, got %v", err)
+}
+
+func (s *Suite) TestListUsersNone() {
+	users, next, err := s.repo.ListUsers(ctx, repository.All(), repository.Page{})
+	s.Require().NoError(err)
+	s.Assert().Len(users, 0)
+	s.Assert().Empty(next)
+}
+
+func (s *Suite) TestListUsersQuery() {
+	start := time.Now().Add(-time.Second)
+	s.createUsers(
+		models.UserInfo{ID: "1", FirstName: "foo", LastName: "bar", Email: "foo@email.com", Roles: []string{models.RoleAdmin}},
+		models.UserInfo{ID: "2", FirstName: "fizz", LastName: "buzz", Email: "fizz@email.com"},
+		models.UserInfo{ID: "3", FirstName: "bar", LastName: "foo", Email: "bar@email.com"},
+	)
+
+	tests := map[string]struct {
+		query       repository.Query
+		expectedIDs []string
+	}{
+		"all":         {query: repository.All(), expectedIDs: []string{"1", "2", "3"}},
+		"first name":  {query: repository.Eq(repository.FieldFirstName, "foo"), expectedIDs: []string{"1"}},
+		"in":          {query: repository.In(repository.FieldEmail, "fizz@email.com", "bar@email.com"), expectedIDs: []string{"2", "3"}},
+		"in none":     {query: repository.In(repository.FieldEmail), expectedIDs: nil},
+		"prefix":      {query: repository.Prefix(repository.FieldFirstName, "f"), expectedIDs: []string{"1", "2"}},
+		"regexp":      {query: repository.Prefix(repository.FieldEmail, ".*"), expectedIDs: nil},
+		"role":        {query: repository.Eq(repository.FieldRoles, models.RoleAdmin), expectedIDs: []string{"1"}},
+		"created":     {query: repository.Between(repository.FieldCreatedAt, start, time.Time{}), expectedIDs: []string{"1", "2", "3"}},
+		"not created": {query: repository.Between(repository.FieldUpdatedAt, time.Time{}, start), expectedIDs: nil},
+		"and":         {query: repository.And(repository.Prefix(repository.FieldFirstName, "f"), repository.Eq(repository.FieldLastName, "buzz")), expectedIDs: []string{"2"}},
+		"or": {query: repository.Or(
+			repository.Eq(repository.FieldID, "1"),
+			repository.And(repository.Prefix(repository.FieldFirstName, "b"), repository.Eq(repository.FieldLastName, "foo")),
+		), expectedIDs: []string{"1", "3"}},
+		"or none": {query: repository.Or(), expectedIDs: nil},
+	}
+	for name, test := range tests {
+		users, _, err := s.repo.ListUsers(ctx, test.query, repository.Page{})
+		s.Require().NoError(err, name)
+		s.Equal(test.expectedIDs, ids(users), name)
+	}
+}
+
+func (s *Suite) TestListUsersInvalid() {
+	_, _, err := s.repo.ListUsers(ctx, repository.Eq("Age", "1"), repository.Page{})
+	s.Assert().True(repository.IsErrInvalidQuery(err), "expected invalid query error, got %v", err)
+
+	_, _, err = s.repo.ListUsers(ctx, repository.All(), repository.Page{Cursor: "!"})
+	s.Assert().True(repository.IsErrInvalidPage(err), "expected invalid page error, got %v", err)
+}
+
+func (s *Suite) TestListUsersPages() {
+	for i := 0; i < 25; i++ {
+		s.createUsers(models.UserInfo{ID: fmt.Sprintf("%02d", i), FirstName: "foo", LastName: fmt.Sprintf("bar%d", i%3), Email: fmt.Sprintf("foo%02d@email.com", i)})
+	}
+
+	for _, descending := range []bool{false, true} {
+		var listed []models.UserInfo
+		page := repository.Page{SortField: repository.FieldLastName, Descending: descending, Limit: 10}
+		for pages := 1; ; pages++ {
+			users, next, err := s.repo.ListUsers(ctx, repository.Prefix(repository.FieldLastName, "bar"), page)
+			s.Require().NoError(err)
+			listed = append(listed, users...)
+			if next == "" {
+				s.Equal(3, pages)
+				break
+			}
+			page.Cursor = next
+		}
+		s.Require().Len(listed, 25)
+		expected := []string{"00", "03", "06"}
+		if descending {
+			expected = []string{"23", "20", "17"}
+		}
+		s.Equal(expected, ids(listed[:3]), "users should be sorted by last name, then id")
+	}
+}
+
+func (s *Suite) createUsers(users ...models.UserInfo) {
+	for _, user := range users {
+		s.Require().NoError(s.repo.CreateUser(ctx, user))
+	}
+}
+
+func ids(users []models.UserInfo) (ids []string) {
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return
+}
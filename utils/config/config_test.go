@@ -0,0 +1,183 @@
+package config_test
+
+import (
+	"encoding/json"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/darren-west/app/utils/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type options struct {
+	BindAddress string
+	Debug       bool
+	Retries     int
+	Lifetime    config.Duration
+	Timeout     time.Duration
+	Hosts       []string
+	Secret      string `env:"SECRET_KEY"`
+	Skipped     string `env:"-" flag:"-"`
+	TLS         tlsOptions
+	unexported  string
+}
+
+type tlsOptions struct {
+	ClientCAPath string
+}
+
+func lookup(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestEnvApply(t *testing.T) {
+	opts := options{BindAddress: ":80", Hosts: []string{"a"}}
+	err := config.Env{Prefix: "APP", Lookup: lookup(map[string]string{
+		"APP_DEBUG":              "true",
+		"APP_RETRIES":            "3",
+		"APP_LIFETIME":           "15m",
+		"APP_TIMEOUT":            "2s",
+		"APP_HOSTS":              "b, c",
+		"APP_SECRET_KEY":         "secret",
+		"APP_SKIPPED":            "foo",
+		"APP_TLS_CLIENT_CA_PATH": "ca.pem",
+	})}.Apply(&opts)
+	require.NoError(t, err)
+	assert.Equal(t, options{
+		BindAddress: ":80",
+		Debug:       true,
+		Retries:     3,
+		Lifetime:    config.Duration(time.Minute * 15),
+		Timeout:     time.Second * 2,
+		Hosts:       []string{"b", "c"},
+		Secret:      "secret",
+		TLS:         tlsOptions{ClientCAPath: "ca.pem"},
+	}, opts)
+}
+
+func TestEnvApplyInvalid(t *testing.T) {
+	opts := options{}
+	err := config.Env{Prefix: "APP", Lookup: lookup(map[string]string{"APP_RETRIES": "many"})}.Apply(&opts)
+	assert.EqualError(t, err, `invalid environment variable APP_RETRIES: strconv.ParseInt: parsing "many": invalid syntax`)
+
+	assert.Error(t, config.Env{}.Apply(opts))
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"BindAddress":         "BIND_ADDRESS",
+		"AccessTokenLifetime": "ACCESS_TOKEN_LIFETIME",
+		"ClientCAPath":        "CLIENT_CA_PATH",
+		"TLS":                 "TLS",
+		"ClientID":            "CLIENT_ID",
+	}
+	for name, expected := range tests {
+		assert.Equal(t, expected, config.EnvName(name))
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	var v struct{ A, B config.Duration }
+	require.NoError(t, json.Unmarshal([]byte(`{"A":"1h30m","B":1000}`), &v))
+	assert.Equal(t, time.Hour+time.Minute*30, v.A.Duration())
+	assert.Equal(t, time.Microsecond, v.B.Duration())
+
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	assert.Equal(t, `{"A":"1h30m0s","B":"1µs"}`, string(data))
+
+	assert.EqualError(t, json.Unmarshal([]byte(`{"A":"soon"}`), &v), `invalid duration "soon": time: invalid duration "soon"`)
+}
+
+func TestFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags, err := config.NewFlags(fs, &options{})
+	require.NoError(t, err)
+	require.NoError(t, fs.Parse([]string{
+		"--debug",
+		"--retries", "3",
+		"--lifetime", "15m",
+		"--hosts", "b,c",
+		"--tls-client-ca-path", "ca.pem",
+	}))
+	assert.Nil(t, fs.Lookup("skipped"))
+
+	opts := options{BindAddress: ":80", Secret: "secret"}
+	require.NoError(t, flags.Apply(&opts))
+	assert.Equal(t, options{
+		BindAddress: ":80",
+		Debug:       true,
+		Retries:     3,
+		Lifetime:    config.Duration(time.Minute * 15),
+		Hosts:       []string{"b", "c"},
+		Secret:      "secret",
+		TLS:         tlsOptions{ClientCAPath: "ca.pem"},
+	}, opts, "only the flags that are set should override the config")
+}
+
+func TestFlagsInvalid(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags, err := config.NewFlags(fs, &options{})
+	require.NoError(t, err)
+	require.NoError(t, fs.Parse([]string{"--timeout", "soon"}))
+
+	assert.EqualError(t, flags.Apply(&options{}), `invalid flag --timeout: time: invalid duration "soon"`)
+}
+
+func TestUnmarshal(t *testing.T) {
+	expected := options{BindAddress: ":8080", Lifetime: config.Duration(time.Minute), Hosts: []string{"a", "b"}, TLS: tlsOptions{ClientCAPath: "ca.crt"}}
+	files := map[string]string{
+		"config.json": `{"BindAddress": ":8080", "Lifetime": "1m", "Hosts": ["a", "b"], "TLS": {"ClientCAPath": "ca.crt"}}`,
+		"config.yaml": "bindAddress: :8080\nlifetime: 1m\nhosts: [a, b]\ntls:\n  clientCAPath: ca.crt\n",
+		"config.YML":  "BindAddress: \":8080\"\nLifetime: 1m\nHosts:\n- a\n- b\nTLS: {ClientCAPath: ca.crt}\n",
+	}
+	for path, data := range files {
+		var opts options
+		require.NoError(t, config.Unmarshal(path, []byte(data), &opts), path)
+		assert.Equal(t, expected, opts, path)
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	var opts options
+	assert.Error(t, config.Unmarshal("config.json", []byte("bindAddress: :80"), &opts))
+	assert.EqualError(t, config.Unmarshal("config.yaml", []byte("TLS: {1: ca.crt}"), &opts), "invalid key 1, expecting a string")
+}
+
+func TestEnvUnmarshal(t *testing.T) {
+	env := config.Env{Prefix: "APP", Lookup: lookup(map[string]string{"SECRET": "s3cret", "EMPTY": "", "HOST": "localhost"})}
+	tests := map[string]string{
+		"config.json": `{"BindAddress": "${HOST}:80", "Secret": "${SECRET}${EMPTY}", "Skipped": "$SECRET", "Retries": 3, "Hosts": ["${HOST}"]}`,
+		"config.yaml": "bindAddress: ${HOST}:80\nsecret: ${SECRET}${EMPTY}\nskipped: $SECRET\nretries: 3\nhosts: [\"${HOST}\"]\n",
+	}
+	for path, data := range tests {
+		var opts options
+		require.NoError(t, env.Unmarshal(path, []byte(data), &opts), path)
+		assert.Equal(t, options{BindAddress: "localhost:80", Secret: "s3cret", Skipped: "$SECRET", Retries: 3, Hosts: []string{"localhost"}}, opts, path)
+	}
+
+	var opts options
+	err := env.Unmarshal("config.json", []byte(`{"Secret": "${SECRET}", "BindAddress": "${KEY}", "Hosts": ["${OTHER}", "${KEY}"]}`), &opts)
+	assert.EqualError(t, err, "environment variables not set: KEY, OTHER")
+}
+
+func TestEnvUnmarshalSpecialCharacters(t *testing.T) {
+	secret := "s3\"cr\\et\n\", \"Skipped\": \"injected"
+	env := config.Env{Lookup: lookup(map[string]string{"SECRET": secret})}
+	tests := map[string]string{
+		"config.json": `{"Secret": "${SECRET}"}`,
+		"config.yaml": "secret: \"${SECRET}\"\n",
+		"config.yml":  "secret: ${SECRET}\n",
+	}
+	for path, data := range tests {
+		var opts options
+		require.NoError(t, env.Unmarshal(path, []byte(data), &opts), path)
+		assert.Equal(t, secret, opts.Secret, path)
+		assert.Empty(t, opts.Skipped, "values should not be able to add fields to the file")
+	}
+}
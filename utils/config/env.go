@@ -0,0 +1,153 @@
+// Package config has the pieces shared by the services for reading their configuration.
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Env overrides config fields with environment variables. The variable of a field is the prefix and the path
+// of the field in upper snake case, joined by underscores. The AccessTokenLifetime field of the Tokens field has
+// the variable AUTH_TOKENS_ACCESS_TOKEN_LIFETIME with the prefix AUTH. A field can set its own name with an env
+// tag, or be skipped with `env:"-"`.
+type Env struct {
+	Prefix string
+	// Lookup looks up environment variables, os.LookupEnv is used when it is nil.
+	Lookup func(key string) (string, bool)
+}
+
+// Apply sets the fields of the struct pointed to by v that have an environment variable set. Strings, bools,
+// numbers, durations, comma separated string slices and types implementing encoding.TextUnmarshaler are
+// supported. Slices of structs and maps are not traversed.
+func (e Env) Apply(v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("invalid input: input type %s, expecting ptr to struct", val.Kind())
+	}
+	lookup := e.Lookup
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	return applyEnv(val.Elem(), e.Prefix, lookup)
+}
+
+func applyEnv(val reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := EnvName(field.Name)
+		if tag, ok := field.Tag.Lookup("env"); ok {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		fv := val.Field(i)
+		if value, ok := lookup(name); ok {
+			if err := setValue(fv, value); err != nil {
+				return fmt.Errorf("invalid environment variable %s: %s", name, err)
+			}
+			continue
+		}
+		if fv.Kind() == reflect.Struct && !isTextUnmarshaler(fv) {
+			if err := applyEnv(fv, name, lookup); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func isTextUnmarshaler(v reflect.Value) bool {
+	_, ok := v.Addr().Interface().(encoding.TextUnmarshaler)
+	return ok
+}
+
+// setValue sets the field to the value parsed from the string.
+func setValue(v reflect.Value, s string) (err error) {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		values := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = reflect.Append(values, reflect.ValueOf(item).Convert(v.Type().Elem()))
+			}
+		}
+		v.Set(values)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// EnvName returns the name in upper snake case, AccessTokenLifetime becomes ACCESS_TOKEN_LIFETIME and
+// ClientCAPath becomes CLIENT_CA_PATH.
+func EnvName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Unmarshal decodes the contents of the config file at the path into v. Files with a .yaml or .yml extension are
+// decoded as yaml, any other file as json. Yaml is converted to json before it is decoded, so the field names
+// and value formats are the same for both.
+func Unmarshal(path string, data []byte, v interface{}) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		doc, err := decode(path, data)
+		if err != nil {
+			return err
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decode decodes the contents of the config file at the path into a document of maps, slices and values.
+func decode(path string, data []byte) (doc interface{}, err error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err = yaml.Unmarshal(data, &doc); err != nil {
+			return
+		}
+		return jsonValue(doc)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&doc)
+	return
+}
+
+// jsonValue converts the maps decoded by yaml, which have interface keys, to maps with string keys.
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %v, expecting a string", key)
+			}
+			value, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = value
+		}
+		return m, nil
+	case []interface{}:
+		for i, value := range v {
+			value, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = value
+		}
+	}
+	return v, nil
+}
+
+var variablePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Unmarshal decodes the config file at the path into v like Unmarshal, replacing each ${VAR} in the string values
+// of the file with the value of the environment variable VAR, so secrets can be kept out of config files. The
+// prefix is not added to the variable names. The file is decoded before the variables are replaced, so values can
+// contain any characters without breaking the format of the file. An error is returned for variables that are not
+// set.
+func (e Env) Unmarshal(path string, data []byte, v interface{}) error {
+	doc, err := decode(path, data)
+	if err != nil {
+		return err
+	}
+	lookup := e.Lookup
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	missing := map[string]bool{}
+	doc = expand(doc, lookup, missing)
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("environment variables not set: %s", strings.Join(names, ", "))
+	}
+	if data, err = json.Marshal(doc); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// expand replaces the variables in the strings of the document, adding the names of variables that are not set
+// to missing.
+func expand(doc interface{}, lookup func(string) (string, bool), missing map[string]bool) interface{} {
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		for key, value := range doc {
+			doc[key] = expand(value, lookup, missing)
+		}
+	case []interface{}:
+		for i, value := range doc {
+			doc[i] = expand(value, lookup, missing)
+		}
+	case string:
+		return variablePattern.ReplaceAllStringFunc(doc, func(match string) string {
+			name := variablePattern.FindStringSubmatch(match)[1]
+			value, ok := lookup(name)
+			if !ok {
+				missing[name] = true
+			}
+			return value
+		})
+	}
+	return doc
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Flags overrides config fields with command line flags. A flag is defined for every field, named by the path
+// of the field in lower kebab case joined by dashes. The AccessTokenLifetime field of the Tokens field has the
+// flag --tokens-access-token-lifetime. A field can set its own name with a flag tag, or be skipped with
+// `flag:"-"`. Only flags that are set on the command line override the config.
+type Flags struct {
+	values map[string]*flagValue
+}
+
+// NewFlags defines a flag in the flag set for each field of the struct pointed to by v. The flags must be
+// parsed before they are applied.
+func NewFlags(fs *flag.FlagSet, v interface{}) (*Flags, error) {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("invalid input: input type %s, expecting ptr to struct", val.Kind())
+	}
+	f := &Flags{values: map[string]*flagValue{}}
+	err := walkFlags(val.Elem(), "", "", func(name, path string, fv reflect.Value) error {
+		if !isSupported(fv) {
+			return nil
+		}
+		value := &flagValue{isBool: fv.Kind() == reflect.Bool}
+		f.values[name] = value
+		fs.Var(value, name, fmt.Sprintf("--%s overrides %s in the config", name, path))
+		return nil
+	})
+	return f, err
+}
+
+// Apply sets the fields of the struct pointed to by v that have their flag set. The struct must be the same type
+// the flags were defined with.
+func (f *Flags) Apply(v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("invalid input: input type %s, expecting ptr to struct", val.Kind())
+	}
+	return walkFlags(val.Elem(), "", "", func(name, _ string, fv reflect.Value) error {
+		value, ok := f.values[name]
+		if !ok || !value.set {
+			return nil
+		}
+		if err := setValue(fv, value.value); err != nil {
+			return fmt.Errorf("invalid flag --%s: %s", name, err)
+		}
+		return nil
+	})
+}
+
+// walkFlags calls the function with the flag name and path of every field that isn't a nested struct.
+func walkFlags(val reflect.Value, prefix, path string, fn func(name, path string, fv reflect.Value) error) error {
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := FlagName(field.Name)
+		if tag, ok := field.Tag.Lookup("flag"); ok {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+		if prefix != "" {
+			name = prefix + "-" + name
+		}
+		fieldPath := field.Name
+		if path != "" {
+			fieldPath = path + "." + field.Name
+		}
+		fv := val.Field(i)
+		var err error
+		if fv.Kind() == reflect.Struct && !isTextUnmarshaler(fv) {
+			err = walkFlags(fv, name, fieldPath, fn)
+		} else {
+			err = fn(name, fieldPath, fv)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FlagName returns the name in lower kebab case, AccessTokenLifetime becomes access-token-lifetime.
+func FlagName(name string) string {
+	return strings.ToLower(strings.Replace(EnvName(name), "_", "-", -1))
+}
+
+func isSupported(v reflect.Value) bool {
+	if isTextUnmarshaler(v) || v.Type() == durationType {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return v.Type().Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// flagValue keeps the value of a flag until it is applied to the config.
+type flagValue struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.value
+}
+
+func (v *flagValue) Set(value string) error {
+	v.value, v.set = value, true
+	return nil
+}
+
+// IsBoolFlag lets bool fields be set with just the flag name.
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
@@ -1,10 +1,18 @@
 module github.com/darren-west/app/utils
 
+go 1.11
+
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/gorilla/sessions v1.1.3 // indirect
-	github.com/kidstuff/mongostore v0.0.0-20180412085134-db2a8b4fac1f
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/golang/mock v1.1.1
+	github.com/google/uuid v1.0.0
+	github.com/gorilla/securecookie v1.1.1
+	github.com/gorilla/sessions v1.1.3
+	github.com/julienschmidt/httprouter v1.2.0
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/sirupsen/logrus v1.2.0
 	github.com/stretchr/testify v1.2.2
-	gopkg.in/mgo.v2 v2.0.0-20180705113604-9856a29383ce
+	go.mongodb.org/mongo-driver v1.17.10
+	gopkg.in/yaml.v2 v2.2.1
 )
@@ -0,0 +1,77 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/julienschmidt/httprouter"
+)
+
+type contextKey int
+
+const (
+	claimsKey contextKey = iota
+	bearerTokenKey
+)
+
+//go:generate mockgen -destination ./mocks/mock_token_reader.go -package mocks github.com/darren-west/app/utils/httputil TokenReader
+
+// TokenReader verifies a token and returns its claims. It is implemented by jwt.Reader.
+type TokenReader interface {
+	Read(jwt.Token) (*jwt.Claims, error)
+}
+
+// Authenticate requires a valid bearer token in the authorization header. The tokens claims are put on the
+// request context, and can be read with ClaimsFromContext. Requests without a valid token are rejected with 401.
+func Authenticate(reader TokenReader, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		token, ok := BearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			NewError(http.StatusUnauthorized).WithMessage("bearer token missing").Write(w)
+			return
+		}
+		claims, err := reader.Read(jwt.NewToken(token))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			NewError(http.StatusUnauthorized).WithMessage("bearer token invalid: %s", err).Write(w)
+			return
+		}
+		h(w, r.WithContext(ContextWithClaims(r.Context(), claims)), ps)
+	}
+}
+
+// BearerToken returns the bearer token in the requests authorization header.
+func BearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(header[len("Bearer "):])
+	return token, token != ""
+}
+
+// ContextWithClaims returns a copy of the context holding the claims of the authenticated caller.
+func ContextWithClaims(ctx context.Context, claims *jwt.Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// ClaimsFromContext returns the claims of the authenticated caller put on the context by Authenticate.
+func ClaimsFromContext(ctx context.Context) (*jwt.Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*jwt.Claims)
+	return claims, ok
+}
+
+// ContextWithBearerToken returns a copy of the context holding a bearer token for clients to send with requests
+// made with the context.
+func ContextWithBearerToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, bearerTokenKey, token)
+}
+
+// BearerTokenFromContext returns the bearer token put on the context by ContextWithBearerToken.
+func BearerTokenFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(bearerTokenKey).(string)
+	return token, ok && token != ""
+}
@@ -0,0 +1,103 @@
+package httputil_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/darren-west/app/utils/httputil"
+	"github.com/darren-west/app/utils/httputil/mocks"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/golang/mock/gomock"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	reader := mocks.NewMockTokenReader(ctrl)
+	reader.EXPECT().Read(jwt.NewToken("valid")).Return(&jwt.Claims{Subject: "1234"}, nil).AnyTimes()
+	reader.EXPECT().Read(jwt.NewToken("expired")).Return(nil, jwt.ErrTokenExpired)
+
+	h := httputil.Authenticate(reader, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		claims, ok := httputil.ClaimsFromContext(r.Context())
+		assert.True(t, ok)
+		w.Write([]byte(claims.Subject))
+	})
+
+	tests := map[string]struct {
+		header       string
+		expectedCode int
+		expectedBody string
+	}{
+		"valid":         {header: "Bearer valid", expectedCode: http.StatusOK, expectedBody: "1234"},
+		"lowercase":     {header: "bearer valid", expectedCode: http.StatusOK, expectedBody: "1234"},
+		"missing":       {header: "", expectedCode: http.StatusUnauthorized, expectedBody: "bearer token missing\n"},
+		"wrong scheme":  {header: "Basic Zm9vOmJhcg==", expectedCode: http.StatusUnauthorized, expectedBody: "bearer token missing\n"},
+		"invalid token": {header: "Bearer expired", expectedCode: http.StatusUnauthorized, expectedBody: "bearer token invalid: token is expired\n"},
+		"empty bearer":  {header: "Bearer ", expectedCode: http.StatusUnauthorized, expectedBody: "bearer token missing\n"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.header != "" {
+				request.Header.Set("Authorization", test.header)
+			}
+			h(recorder, request, nil)
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			assert.Equal(t, test.expectedBody, recorder.Body.String())
+			if test.expectedCode == http.StatusUnauthorized {
+				assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+}
+
+func TestBearerTokenContext(t *testing.T) {
+	_, ok := httputil.BearerTokenFromContext(context.Background())
+	assert.False(t, ok)
+
+	token, ok := httputil.BearerTokenFromContext(httputil.ContextWithBearerToken(context.Background(), "foo"))
+	assert.True(t, ok)
+	assert.Equal(t, "foo", token)
+}
+
+func TestClaimsFromContextMissing(t *testing.T) {
+	_, ok := httputil.ClaimsFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestAuthorize(t *testing.T) {
+	owner := func(claims *jwt.Claims, _ *http.Request, ps httprouter.Params) bool {
+		return claims.Subject == ps.ByName("id")
+	}
+	h := httputil.Authorize(httputil.AnyOf(owner, httputil.Scopes("users:read", "users:write")),
+		func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			w.Write([]byte("ok"))
+		})
+
+	tests := map[string]struct {
+		claims       *jwt.Claims
+		expectedCode int
+		expectedBody string
+	}{
+		"owner":          {claims: &jwt.Claims{Subject: "1234"}, expectedCode: http.StatusOK, expectedBody: "ok"},
+		"all scopes":     {claims: &jwt.Claims{Subject: "5678", Scope: "users:write users:read"}, expectedCode: http.StatusOK, expectedBody: "ok"},
+		"missing scope":  {claims: &jwt.Claims{Subject: "5678", Scope: "users:read"}, expectedCode: http.StatusForbidden, expectedBody: "forbidden\n"},
+		"no scopes":      {claims: &jwt.Claims{Subject: "5678"}, expectedCode: http.StatusForbidden, expectedBody: "forbidden\n"},
+		"not authorized": {expectedCode: http.StatusUnauthorized, expectedBody: "bearer token missing\n"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1234", nil)
+			if test.claims != nil {
+				request = request.WithContext(httputil.ContextWithClaims(request.Context(), test.claims))
+			}
+			h(recorder, request, httprouter.Params{{Key: "id", Value: "1234"}})
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			assert.Equal(t, test.expectedBody, recorder.Body.String())
+		})
+	}
+}
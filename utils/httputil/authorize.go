@@ -0,0 +1,54 @@
+package httputil
+
+import (
+	"net/http"
+
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Policy decides if the authenticated caller is allowed to make the request.
+type Policy func(claims *jwt.Claims, r *http.Request, ps httprouter.Params) bool
+
+// Scopes returns a policy that allows callers whose token has every one of the scopes.
+func Scopes(scopes ...string) Policy {
+	return func(claims *jwt.Claims, _ *http.Request, _ httprouter.Params) bool {
+		for _, scope := range scopes {
+			if !claims.HasScope(scope) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AnyOf returns a policy that allows callers allowed by any of the policies.
+func AnyOf(policies ...Policy) Policy {
+	return func(claims *jwt.Claims, r *http.Request, ps httprouter.Params) bool {
+		for _, policy := range policies {
+			if policy(claims, r, ps) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Authorize only passes requests on to the handler when the policy allows the caller. It must be wrapped by
+// Authenticate so the callers claims are on the request context. Callers that are not allowed are rejected
+// with 403.
+func Authorize(policy Policy, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		claims, ok := ClaimsFromContext(r.Context())
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			NewError(http.StatusUnauthorized).WithMessage("bearer token missing").Write(w)
+			return
+		}
+		if !policy(claims, r, ps) {
+			NewError(http.StatusForbidden).WithMessage("forbidden").Write(w)
+			return
+		}
+		h(w, r, ps)
+	}
+}
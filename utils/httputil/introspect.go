@@ -0,0 +1,76 @@
+package httputil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/darren-west/app/utils/jwt"
+)
+
+// ErrTokenInactive is returned by an IntrospectionReader when the issuer reports the token is no longer active.
+var ErrTokenInactive = errors.New("token is not active")
+
+// introspectionTimeout is how long a token introspection can take.
+const introspectionTimeout = 10 * time.Second
+
+// IntrospectionReader verifies tokens with the reader and then asks the issuer whether they are still active
+// (RFC 7662), so resource servers reject tokens that were revoked before they expire. The resource server
+// authenticates to the introspection endpoint as a client with its id and secret.
+type IntrospectionReader struct {
+	Reader       TokenReader
+	URL          string
+	ClientID     string
+	ClientSecret string
+	// Client is the http client introspection requests are made with, a client with a timeout is used when nil.
+	Client *http.Client
+}
+
+// Read verifies the token and returns its claims if the issuer reports it is active.
+func (r IntrospectionReader) Read(token jwt.Token) (*jwt.Claims, error) {
+	claims, err := r.Reader.Read(token)
+	if err != nil {
+		return nil, err
+	}
+	active, err := r.introspect(token.String())
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrTokenInactive
+	}
+	return claims, nil
+}
+
+func (r IntrospectionReader) introspect(token string) (bool, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest(http.MethodPost, r.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, fmt.Errorf("failed to introspect token: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(r.ClientID, r.ClientSecret)
+	client := r.Client
+	if client == nil {
+		client = &http.Client{Timeout: introspectionTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to introspect token: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("failed to introspect token: status %d", resp.StatusCode)
+	}
+	introspection := struct {
+		Active bool `json:"active"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&introspection); err != nil {
+		return false, fmt.Errorf("failed to decode introspection: %s", err)
+	}
+	return introspection.Active, nil
+}
@@ -0,0 +1,64 @@
+package httputil_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/darren-west/app/utils/httputil"
+	"github.com/darren-west/app/utils/httputil/mocks"
+	"github.com/darren-west/app/utils/jwt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospectionReader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "user-service" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.PostFormValue("token") {
+		case "revoked":
+			fmt.Fprint(w, `{"active":false}`)
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			fmt.Fprint(w, `{"active":true}`)
+		}
+	}))
+	defer server.Close()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	reader := mocks.NewMockTokenReader(ctrl)
+	reader.EXPECT().Read(gomock.Any()).Return(&jwt.Claims{Subject: "1234"}, nil).AnyTimes()
+
+	introspection := httputil.IntrospectionReader{Reader: reader, URL: server.URL, ClientID: "user-service", ClientSecret: "secret"}
+
+	claims, err := introspection.Read(jwt.NewToken("valid"))
+	require.NoError(t, err)
+	assert.Equal(t, "1234", claims.Subject)
+
+	_, err = introspection.Read(jwt.NewToken("revoked"))
+	assert.Equal(t, httputil.ErrTokenInactive, err)
+
+	_, err = introspection.Read(jwt.NewToken("broken"))
+	assert.EqualError(t, err, "failed to introspect token: status 500")
+
+	introspection.ClientSecret = "wrong"
+	_, err = introspection.Read(jwt.NewToken("valid"))
+	assert.EqualError(t, err, "failed to introspect token: status 401")
+}
+
+func TestIntrospectionReaderInvalidToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	reader := mocks.NewMockTokenReader(ctrl)
+	reader.EXPECT().Read(jwt.NewToken("expired")).Return(nil, jwt.ErrTokenExpired)
+
+	_, err := httputil.IntrospectionReader{Reader: reader, URL: "http://localhost:0"}.Read(jwt.NewToken("expired"))
+	assert.Equal(t, jwt.ErrTokenExpired, err, "tokens that can't be verified should not be introspected")
+}
@@ -0,0 +1,60 @@
+package httputil
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenSource returns access tokens for clients to authorize their requests with.
+type TokenSource interface {
+	Token(ctx context.Context) (AccessToken, error)
+}
+
+// TokenSourceFunc is a function that can be used as a TokenSource.
+type TokenSourceFunc func(ctx context.Context) (AccessToken, error)
+
+// Token returns the token returned by the function.
+func (f TokenSourceFunc) Token(ctx context.Context) (AccessToken, error) {
+	return f(ctx)
+}
+
+// AccessToken is an access token and the time it expires. A token with a zero ExpiresAt does not expire.
+type AccessToken struct {
+	Value     string
+	ExpiresAt time.Time
+}
+
+func (t AccessToken) valid(now time.Time, early time.Duration) bool {
+	return t.Value != "" && (t.ExpiresAt.IsZero() || now.Add(early).Before(t.ExpiresAt))
+}
+
+// ReuseTokenSource returns a token source that reuses the token from the source until it is about to expire.
+// A new token is fetched the early duration before the token expires, so tokens don't expire while requests
+// are in flight. The token source is safe to use from multiple goroutines.
+func ReuseTokenSource(source TokenSource, early time.Duration) TokenSource {
+	return &reuseTokenSource{source: source, early: early, now: time.Now}
+}
+
+type reuseTokenSource struct {
+	source TokenSource
+	early  time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	token AccessToken
+}
+
+func (s *reuseTokenSource) Token(ctx context.Context) (AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.valid(s.now(), s.early) {
+		return s.token, nil
+	}
+	token, err := s.source.Token(ctx)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	s.token = token
+	return token, nil
+}
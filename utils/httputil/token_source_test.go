@@ -0,0 +1,64 @@
+package httputil_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/darren-west/app/utils/httputil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReuseTokenSource(t *testing.T) {
+	calls := 0
+	lifetime := time.Hour
+	source := httputil.ReuseTokenSource(httputil.TokenSourceFunc(func(context.Context) (httputil.AccessToken, error) {
+		calls++
+		return httputil.AccessToken{Value: fmt.Sprintf("token-%d", calls), ExpiresAt: time.Now().Add(lifetime)}, nil
+	}), time.Minute)
+
+	token, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token.Value)
+
+	token, err = source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token.Value, "the token should be reused until it is about to expire")
+
+	lifetime = time.Second
+	source = httputil.ReuseTokenSource(httputil.TokenSourceFunc(func(context.Context) (httputil.AccessToken, error) {
+		calls++
+		return httputil.AccessToken{Value: fmt.Sprintf("token-%d", calls), ExpiresAt: time.Now().Add(lifetime)}, nil
+	}), time.Minute)
+	first, err := source.Token(context.Background())
+	require.NoError(t, err)
+	second, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Value, second.Value, "tokens expiring within the early duration should be fetched again")
+}
+
+func TestReuseTokenSourceError(t *testing.T) {
+	source := httputil.ReuseTokenSource(httputil.TokenSourceFunc(func(context.Context) (httputil.AccessToken, error) {
+		return httputil.AccessToken{}, errors.New("boom")
+	}), time.Minute)
+
+	_, err := source.Token(context.Background())
+	assert.EqualError(t, err, "boom")
+}
+
+func TestReuseTokenSourceNoExpiry(t *testing.T) {
+	calls := 0
+	source := httputil.ReuseTokenSource(httputil.TokenSourceFunc(func(context.Context) (httputil.AccessToken, error) {
+		calls++
+		return httputil.AccessToken{Value: "token"}, nil
+	}), time.Minute)
+
+	for i := 0; i < 3; i++ {
+		_, err := source.Token(context.Background())
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, calls)
+}
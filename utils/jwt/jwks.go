@@ -0,0 +1,315 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JSONWebKey is a public key in the JSON Web Key format (RFC 7517). Only RSA and EC keys are supported.
+type JSONWebKey struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// PublicKey decodes the public key.
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %s", k.KeyID, err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %s", k.KeyID, err)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := curves[k.Curve]
+		if !ok {
+			return nil, fmt.Errorf("invalid key %s: unsupported curve %s", k.KeyID, k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %s", k.KeyID, err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %s", k.KeyID, err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("invalid key %s: unsupported key type %s", k.KeyID, k.KeyType)
+	}
+}
+
+// NewJSONWebKey encodes the public key as a JSON web key with the key id given.
+func NewJSONWebKey(kid string, key crypto.PublicKey) (jwk JSONWebKey, err error) {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		jwk = JSONWebKey{
+			KeyID:   kid,
+			KeyType: "RSA",
+			Use:     "sig",
+			N:       encodeBigInt(pub.N),
+			E:       encodeBigInt(big.NewInt(int64(pub.E))),
+		}
+	case *ecdsa.PublicKey:
+		jwk = JSONWebKey{
+			KeyID:   kid,
+			KeyType: "EC",
+			Use:     "sig",
+			Curve:   pub.Curve.Params().Name,
+			X:       encodeBigInt(pub.X),
+			Y:       encodeBigInt(pub.Y),
+		}
+	default:
+		err = fmt.Errorf("unsupported public key type %T", key)
+	}
+	return
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("missing value")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// JSONWebKeySet is a set of JSON web keys, as published on a jwks endpoint.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// Key returns the key with the key id given.
+func (s JSONWebKeySet) Key(kid string) (JSONWebKey, bool) {
+	for _, k := range s.Keys {
+		if k.KeyID == kid {
+			return k, true
+		}
+	}
+	return JSONWebKey{}, false
+}
+
+// PublicKey returns the public key with the key id given, so a key set read from a file can be used as a KeySource.
+func (s JSONWebKeySet) PublicKey(kid string) (crypto.PublicKey, error) {
+	key, ok := s.Key(kid)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key.PublicKey()
+}
+
+// ErrKeyNotFound is returned by a KeySource when there is no key with the key id.
+var ErrKeyNotFound = errors.New("key not found")
+
+// KeySource looks up public keys by their key id.
+type KeySource interface {
+	PublicKey(kid string) (crypto.PublicKey, error)
+}
+
+// RemoteKeySetBuilder is used to set non default options on a RemoteKeySet.
+var RemoteKeySetBuilder = remoteKeySetBuilder{}
+
+type remoteKeySetBuilder struct{}
+
+// WithHTTPClient sets the http client used to fetch the key set. Fetches are bounded by the fetch timeout whatever
+// the timeout of the client.
+func (remoteKeySetBuilder) WithHTTPClient(client *http.Client) RemoteKeySetOption {
+	return func(s *RemoteKeySet) {
+		s.client = client
+	}
+}
+
+// WithCacheDuration sets how long a fetched key set is used before it is fetched again.
+func (remoteKeySetBuilder) WithCacheDuration(d time.Duration) RemoteKeySetOption {
+	return func(s *RemoteKeySet) {
+		s.cacheDuration = d
+	}
+}
+
+// WithFetchTimeout sets how long a fetch of the key set can take before it fails.
+func (remoteKeySetBuilder) WithFetchTimeout(d time.Duration) RemoteKeySetOption {
+	return func(s *RemoteKeySet) {
+		s.fetchTimeout = d
+	}
+}
+
+// WithMinRefreshInterval sets the minimum time between fetches triggered by an unknown key id, and between fetches
+// after a fetch fails.
+func (remoteKeySetBuilder) WithMinRefreshInterval(d time.Duration) RemoteKeySetOption {
+	return func(s *RemoteKeySet) {
+		s.minRefreshInterval = d
+	}
+}
+
+// RemoteKeySetOption is used to set options on the RemoteKeySet.
+type RemoteKeySetOption func(*RemoteKeySet)
+
+// defaultFetchTimeout is how long a fetch of a remote key set can take by default.
+const defaultFetchTimeout = 10 * time.Second
+
+// NewRemoteKeySet returns a key source that fetches keys from the jwks url given.
+func NewRemoteKeySet(url string, opts ...RemoteKeySetOption) *RemoteKeySet {
+	s := &RemoteKeySet{
+		url:                url,
+		client:             &http.Client{Timeout: defaultFetchTimeout},
+		cacheDuration:      time.Hour,
+		minRefreshInterval: time.Minute,
+		fetchTimeout:       defaultFetchTimeout,
+		now:                time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// RemoteKeySet is a KeySource backed by a remote jwks url. The key set is cached and is fetched
+// again when the cache expires or when a key id is not in the cached set, so rotated keys are picked up.
+// Only one fetch runs at a time and it runs without holding the lock, so keys that are cached can still be
+// looked up while the key set is being fetched. When a fetch fails the cached keys are still used, and the key set
+// is not fetched again until the min refresh interval has passed, so an unavailable jwks url is not fetched on every
+// lookup.
+type RemoteKeySet struct {
+	url                string
+	client             *http.Client
+	cacheDuration      time.Duration
+	minRefreshInterval time.Duration
+	fetchTimeout       time.Duration
+	now                func() time.Time
+
+	mu        sync.Mutex
+	keys      JSONWebKeySet
+	fetchedAt time.Time
+	fetching  *keySetFetch
+	// attemptedAt is when the key set was last fetched, fetchErr is the error if that fetch failed.
+	attemptedAt time.Time
+	fetchErr    error
+}
+
+// keySetFetch is a fetch of the key set in progress, done is closed when it finishes.
+type keySetFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// PublicKey returns the public key with the key id, fetching the key set if needed. When another lookup is
+// already fetching the key set the cached key is returned if there is one, otherwise it waits for the fetch.
+func (s *RemoteKeySet) PublicKey(kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	now := s.now()
+	expired := s.fetchedAt.IsZero() || now.Sub(s.fetchedAt) > s.cacheDuration
+	key, ok := s.keys.Key(kid)
+	if !ok && now.Sub(s.fetchedAt) > s.minRefreshInterval {
+		expired = true
+	}
+	if expired && s.fetchErr != nil && now.Sub(s.attemptedAt) <= s.minRefreshInterval {
+		err := s.fetchErr
+		s.mu.Unlock()
+		if ok {
+			return key.PublicKey()
+		}
+		return nil, err
+	}
+	if !expired {
+		s.mu.Unlock()
+		return publicKey(key, ok)
+	}
+	f := s.fetching
+	if f != nil {
+		s.mu.Unlock()
+		if ok {
+			return key.PublicKey()
+		}
+		<-f.done
+	} else {
+		f = &keySetFetch{done: make(chan struct{})}
+		s.fetching = f
+		s.mu.Unlock()
+		s.refresh(f)
+	}
+	s.mu.Lock()
+	key, ok = s.keys.Key(kid)
+	s.mu.Unlock()
+	if f.err != nil && !ok {
+		return nil, f.err
+	}
+	return publicKey(key, ok)
+}
+
+func publicKey(key JSONWebKey, ok bool) (crypto.PublicKey, error) {
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key.PublicKey()
+}
+
+// refresh fetches the key set and swaps it for the cached one, then finishes the fetch. The cached key set is kept
+// when the fetch fails.
+func (s *RemoteKeySet) refresh(f *keySetFetch) {
+	keys, err := s.fetch()
+	s.mu.Lock()
+	s.attemptedAt, s.fetchErr = s.now(), err
+	if err == nil {
+		s.keys = keys
+		s.fetchedAt = s.attemptedAt
+	}
+	f.err = err
+	s.fetching = nil
+	s.mu.Unlock()
+	close(f.done)
+}
+
+func (s *RemoteKeySet) fetch() (keys JSONWebKeySet, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return keys, fmt.Errorf("failed to fetch key set: %s", err)
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return keys, fmt.Errorf("failed to fetch key set: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return keys, fmt.Errorf("failed to fetch key set: status %d", resp.StatusCode)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return keys, fmt.Errorf("failed to decode key set: %s", err)
+	}
+	return
+}
@@ -0,0 +1,170 @@
+package jwt_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/darren-west/app/utils/fileutil"
+	"github.com/darren-west/app/utils/jwt"
+)
+
+func TestJSONWebKeyRoundTrip(t *testing.T) {
+	data, err := fileutil.FileReader{}.Read("testdata/app.rsa.pub")
+	require.NoError(t, err)
+	pub, err := jwtgo.ParseRSAPublicKeyFromPEM(data)
+	require.NoError(t, err)
+
+	jwk, err := jwt.NewJSONWebKey("1", pub)
+	require.NoError(t, err)
+	assert.Equal(t, "RSA", jwk.KeyType)
+	assert.Equal(t, "AQAB", jwk.E)
+
+	decoded, err := jwk.PublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, pub, decoded)
+}
+
+func TestJSONWebKeyUnsupportedType(t *testing.T) {
+	_, err := jwt.JSONWebKey{KeyID: "1", KeyType: "oct"}.PublicKey()
+	assert.EqualError(t, err, "invalid key 1: unsupported key type oct")
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	data, err := fileutil.FileReader{}.Read("testdata/app.rsa.pub")
+	require.NoError(t, err)
+	pub, err := jwtgo.ParseRSAPublicKeyFromPEM(data)
+	require.NoError(t, err)
+	jwk, err := jwt.NewJSONWebKey("1", pub)
+	require.NoError(t, err)
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		require.NoError(t, json.NewEncoder(w).Encode(jwt.JSONWebKeySet{Keys: []jwt.JSONWebKey{jwk}}))
+	}))
+	defer server.Close()
+
+	keys := jwt.NewRemoteKeySet(server.URL)
+	key, err := keys.PublicKey("1")
+	require.NoError(t, err)
+	assert.Equal(t, pub, key)
+
+	_, err = keys.PublicKey("1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, fetches, "key set should be cached")
+
+	_, err = keys.PublicKey("2")
+	assert.Equal(t, jwt.ErrKeyNotFound, err)
+	assert.Equal(t, 1, fetches, "unknown keys should not refetch within the refresh interval")
+
+	keys = jwt.NewRemoteKeySet(server.URL, jwt.RemoteKeySetBuilder.WithMinRefreshInterval(-1))
+	_, err = keys.PublicKey("2")
+	assert.Equal(t, jwt.ErrKeyNotFound, err)
+	_, err = keys.PublicKey("2")
+	assert.Equal(t, jwt.ErrKeyNotFound, err)
+	assert.Equal(t, 3, fetches)
+}
+
+func TestRemoteKeySetLookupDuringFetch(t *testing.T) {
+	data, err := fileutil.FileReader{}.Read("testdata/app.rsa.pub")
+	require.NoError(t, err)
+	pub, err := jwtgo.ParseRSAPublicKeyFromPEM(data)
+	require.NoError(t, err)
+	jwk, err := jwt.NewJSONWebKey("1", pub)
+	require.NoError(t, err)
+
+	var fetches int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(jwt.JSONWebKeySet{Keys: []jwt.JSONWebKey{jwk}})
+	}))
+	defer server.Close()
+	defer close(release)
+
+	keys := jwt.NewRemoteKeySet(server.URL, jwt.RemoteKeySetBuilder.WithCacheDuration(-1))
+	_, err = keys.PublicKey("1")
+	require.NoError(t, err)
+
+	go keys.PublicKey("1")
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := keys.PublicKey("1")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("a cached key should be returned while the key set is being fetched")
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches), "only one fetch should run at a time")
+}
+
+func TestRemoteKeySetFetchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	keys := jwt.NewRemoteKeySet(server.URL,
+		jwt.RemoteKeySetBuilder.WithHTTPClient(&http.Client{}),
+		jwt.RemoteKeySetBuilder.WithFetchTimeout(50*time.Millisecond),
+	)
+	_, err := keys.PublicKey("1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to fetch key set")
+}
+
+func TestRemoteKeySetFetchFailure(t *testing.T) {
+	data, err := fileutil.FileReader{}.Read("testdata/app.rsa.pub")
+	require.NoError(t, err)
+	pub, err := jwtgo.ParseRSAPublicKeyFromPEM(data)
+	require.NoError(t, err)
+	jwk, err := jwt.NewJSONWebKey("1", pub)
+	require.NoError(t, err)
+
+	var fetches, down int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(jwt.JSONWebKeySet{Keys: []jwt.JSONWebKey{jwk}})
+	}))
+	defer server.Close()
+
+	keys := jwt.NewRemoteKeySet(server.URL, jwt.RemoteKeySetBuilder.WithCacheDuration(-1))
+	_, err = keys.PublicKey("1")
+	require.NoError(t, err)
+
+	atomic.StoreInt32(&down, 1)
+	key, err := keys.PublicKey("1")
+	require.NoError(t, err, "the cached key should be used when the key set can't be fetched")
+	assert.Equal(t, pub, key)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	key, err = keys.PublicKey("1")
+	require.NoError(t, err)
+	assert.Equal(t, pub, key)
+	_, err = keys.PublicKey("2")
+	assert.EqualError(t, err, "failed to fetch key set: status 503")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches), "the key set should not be fetched again within the refresh interval after a failure")
+}
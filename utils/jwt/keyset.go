@@ -0,0 +1,169 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// SigningKey is a private key used to sign tokens, identified by its key id. A key is no longer used to verify
+// tokens or published once it expires, a zero ExpiresAt never expires.
+type SigningKey struct {
+	KeyID      string
+	PrivateKey *rsa.PrivateKey
+	ExpiresAt  time.Time
+}
+
+func (k SigningKey) expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// NewKeySet returns a key set that signs with the active key. The other keys are only used to verify tokens,
+// which lets a new key be published before it is made active and an old key keep verifying the tokens it
+// signed after it is retired.
+func NewKeySet(activeKeyID string, keys ...SigningKey) (*KeySet, error) {
+	s := &KeySet{now: time.Now}
+	if err := s.Set(activeKeyID, keys...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// KeySet is a set of signing keys. It is safe for concurrent use and can be replaced while in use with Set.
+type KeySet struct {
+	now func() time.Time
+
+	mu     sync.RWMutex
+	active SigningKey
+	keys   []SigningKey
+}
+
+// Set replaces the keys in the set.
+func (s *KeySet) Set(activeKeyID string, keys ...SigningKey) error {
+	ids := make(map[string]bool)
+	var active *SigningKey
+	for i, k := range keys {
+		if k.KeyID == "" {
+			return errors.New("invalid key set: key id missing")
+		}
+		if k.PrivateKey == nil {
+			return fmt.Errorf("invalid key set: key %s private key missing", k.KeyID)
+		}
+		if ids[k.KeyID] {
+			return fmt.Errorf("invalid key set: key %s is defined more than once", k.KeyID)
+		}
+		ids[k.KeyID] = true
+		if k.KeyID == activeKeyID {
+			active = &keys[i]
+		}
+	}
+	if active == nil {
+		return fmt.Errorf("invalid key set: active key %s not found", activeKeyID)
+	}
+	if active.expired(s.now()) {
+		return fmt.Errorf("invalid key set: active key %s has expired", activeKeyID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active = *active
+	s.keys = append([]SigningKey(nil), keys...)
+	return nil
+}
+
+// ActiveKey returns the key new tokens are signed with.
+func (s *KeySet) ActiveKey() SigningKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active
+}
+
+// PublicKey returns the public key of the non expired key with the key id given.
+func (s *KeySet) PublicKey(kid string) (crypto.PublicKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.now()
+	for _, k := range s.keys {
+		if k.KeyID == kid && !k.expired(now) {
+			return &k.PrivateKey.PublicKey, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// JSONWebKeySet returns the public keys of the non expired keys in the set, for publishing on a jwks endpoint.
+func (s *KeySet) JSONWebKeySet() (set JSONWebKeySet, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.now()
+	set.Keys = []JSONWebKey{}
+	for _, k := range s.keys {
+		if k.expired(now) {
+			continue
+		}
+		jwk, err := NewJSONWebKey(k.KeyID, &k.PrivateKey.PublicKey)
+		if err != nil {
+			return set, err
+		}
+		jwk.Algorithm = signingMethod.Alg()
+		set.Keys = append(set.Keys, jwk)
+	}
+	return
+}
+
+// KeySetFile is the format of the file describing a key set. Relative private key paths are relative to the
+// directory of the file.
+//
+//	{
+//		"ActiveKeyID": "2",
+//		"Keys": [
+//			{"KeyID": "1", "PrivateKeyPath": "1.rsa", "ExpiresAt": "2018-12-01T00:00:00Z"},
+//			{"KeyID": "2", "PrivateKeyPath": "2.rsa"}
+//		]
+//	}
+type KeySetFile struct {
+	ActiveKeyID string
+	Keys        []struct {
+		KeyID          string
+		PrivateKeyPath string
+		ExpiresAt      time.Time
+	}
+}
+
+// ReadKeySet reads the key set file at the path given and returns the active key id and the keys in it.
+func ReadKeySet(reader FileReader, path string) (activeKeyID string, keys []SigningKey, err error) {
+	data, err := reader.Read(path)
+	if err != nil {
+		err = fmt.Errorf("failed to read key set: %s", err)
+		return
+	}
+	file := KeySetFile{}
+	if err = json.Unmarshal(data, &file); err != nil {
+		err = fmt.Errorf("failed to read key set: %s", err)
+		return
+	}
+	for _, k := range file.Keys {
+		keyPath := k.PrivateKeyPath
+		if !filepath.IsAbs(keyPath) {
+			keyPath = filepath.Join(filepath.Dir(path), keyPath)
+		}
+		if data, err = reader.Read(keyPath); err != nil {
+			err = fmt.Errorf("failed to read key %s: %s", k.KeyID, err)
+			return
+		}
+		key, parseErr := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if parseErr != nil {
+			err = fmt.Errorf("failed to read key %s: %s", k.KeyID, parseErr)
+			return
+		}
+		keys = append(keys, SigningKey{KeyID: k.KeyID, PrivateKey: key, ExpiresAt: k.ExpiresAt})
+	}
+	return file.ActiveKeyID, keys, nil
+}
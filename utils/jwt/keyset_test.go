@@ -0,0 +1,112 @@
+package jwt_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/darren-west/app/utils/fileutil"
+	"github.com/darren-west/app/utils/jwt"
+)
+
+func readKeys(t *testing.T) (string, []jwt.SigningKey) {
+	active, keys, err := jwt.ReadKeySet(fileutil.FileReader{}, "testdata/keys.json")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	return active, keys
+}
+
+func TestReadKeySet(t *testing.T) {
+	active, keys := readKeys(t)
+	assert.Equal(t, "app", active)
+	assert.Equal(t, "app", keys[0].KeyID)
+	assert.Equal(t, "next", keys[1].KeyID)
+	assert.NotNil(t, keys[1].PrivateKey)
+
+	_, _, err := jwt.ReadKeySet(fileutil.FileReader{}, "testdata/missing.json")
+	assert.Error(t, err)
+}
+
+func TestNewKeySetInvalid(t *testing.T) {
+	_, keys := readKeys(t)
+
+	_, err := jwt.NewKeySet("foo", keys...)
+	assert.EqualError(t, err, "invalid key set: active key foo not found")
+
+	_, err = jwt.NewKeySet("app", keys[0], keys[0])
+	assert.EqualError(t, err, "invalid key set: key app is defined more than once")
+
+	expired := keys[0]
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	_, err = jwt.NewKeySet("app", expired)
+	assert.EqualError(t, err, "invalid key set: active key app has expired")
+}
+
+func TestKeySetSignAndVerify(t *testing.T) {
+	_, keys := readKeys(t)
+	set, err := jwt.NewKeySet("app", keys...)
+	require.NoError(t, err)
+
+	w := jwt.NewWriter(jwt.WriterBuilder.WithKeySet(set))
+	r := jwt.NewReader(jwt.ReaderBuilder.WithKeySource(set))
+
+	token, err := w.Write(&jwt.Claims{User: jwt.User{ID: "1234"}, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	assert.Equal(t, "app", kid(t, token))
+
+	claims, err := r.Read(token)
+	require.NoError(t, err)
+	assert.Equal(t, "1234", claims.User.ID)
+
+	// rotate to the next key, tokens signed with the old key still verify until it expires.
+	keys[0].ExpiresAt = time.Now().Add(time.Hour)
+	require.NoError(t, set.Set("next", keys...))
+
+	rotated, err := w.Write(&jwt.Claims{User: jwt.User{ID: "1234"}, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	assert.Equal(t, "next", kid(t, rotated))
+	_, err = r.Read(rotated)
+	assert.NoError(t, err)
+	_, err = r.Read(token)
+	assert.NoError(t, err)
+
+	keys[0].ExpiresAt = time.Now().Add(-time.Minute)
+	require.NoError(t, set.Set("next", keys...))
+	_, err = r.Read(token)
+	assert.Error(t, err)
+}
+
+func TestKeySetJSONWebKeySet(t *testing.T) {
+	_, keys := readKeys(t)
+	keys[1].ExpiresAt = time.Now().Add(-time.Minute)
+	set, err := jwt.NewKeySet("app", keys...)
+	require.NoError(t, err)
+
+	jwks, err := set.JSONWebKeySet()
+	require.NoError(t, err)
+	require.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "app", jwks.Keys[0].KeyID)
+	assert.Equal(t, "RS512", jwks.Keys[0].Algorithm)
+
+	// a published key set can verify the tokens signed by the key set.
+	token, err := jwt.NewWriter(jwt.WriterBuilder.WithKeySet(set)).Write(&jwt.Claims{User: jwt.User{ID: "1234"}, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	_, err = jwt.NewReader(jwt.ReaderBuilder.WithKeySource(jwks)).Read(token)
+	assert.NoError(t, err)
+}
+
+func kid(t *testing.T, token jwt.Token) string {
+	parts := strings.Split(token.String(), ".")
+	require.Len(t, parts, 3)
+	data, err := jwtgo.DecodeSegment(parts[0])
+	require.NoError(t, err)
+	header := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &header))
+	kid, _ := header["kid"].(string)
+	return kid
+}
@@ -1,8 +1,11 @@
 package jwt
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/darren-west/app/utils/fileutil"
 	jwt "github.com/dgrijalva/jwt-go"
@@ -20,6 +23,14 @@ func (writerBuilder) WithPrivateKeyPath(path string) WriterOption {
 	}
 }
 
+// WithKeySet sets the key set to sign the token with. The token is signed with the active key in the set and
+// its key id is set in the kid header. The private key path is not used when a key set is set.
+func (writerBuilder) WithKeySet(keys *KeySet) WriterOption {
+	return func(w *Writer) {
+		w.keySet = keys
+	}
+}
+
 // WithFileReader sets the file reader to implementation to read the private key with.
 func (writerBuilder) WithFileReader(reader FileReader) WriterOption {
 	return func(w *Writer) {
@@ -65,15 +76,25 @@ func (t Token) String() string {
 	return string(t)
 }
 
+var signingMethod = jwt.SigningMethodRS512
+
 // Writer is a type used to write
 type Writer struct {
 	privateKeyPath string
 	fileReader     FileReader
+	keySet         *KeySet
 }
 
 // Write is a function for writing a signed JWT token with the claims passed in.
 func (w Writer) Write(c *Claims) (Token, error) {
-	token := jwt.New(jwt.SigningMethodRS512)
+	token := jwt.New(signingMethod)
+	token.Claims = c
+	if w.keySet != nil {
+		active := w.keySet.ActiveKey()
+		token.Header["kid"] = active.KeyID
+		tokenString, err := token.SignedString(active.PrivateKey)
+		return Token(tokenString), err
+	}
 	b, err := w.fileReader.Read(w.privateKeyPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to write token: %s", err)
@@ -82,7 +103,6 @@ func (w Writer) Write(c *Claims) (Token, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to write token: %s", err)
 	}
-	token.Claims = c
 	tokenString, err := token.SignedString(key)
 	return Token(tokenString), err
 }
@@ -119,11 +139,58 @@ func (readerBuilder) WithFileReader(reader FileReader) ReaderOption {
 	}
 }
 
+// WithIssuer sets the issuer tokens must have been issued by. The issuer is not checked when it is empty.
+func (readerBuilder) WithIssuer(issuer string) ReaderOption {
+	return func(r *Reader) {
+		r.issuer = issuer
+	}
+}
+
+// WithAudience sets the audience tokens must have been issued for. The audience is not checked when it is empty.
+func (readerBuilder) WithAudience(audience string) ReaderOption {
+	return func(r *Reader) {
+		r.audience = audience
+	}
+}
+
+// WithClockSkew sets how far the clocks of the issuer and reader can differ when checking the expiry and not
+// before times.
+func (readerBuilder) WithClockSkew(skew time.Duration) ReaderOption {
+	return func(r *Reader) {
+		r.clockSkew = skew
+	}
+}
+
+// WithRevocationChecker sets the checker used to reject tokens that have been revoked before they expire.
+func (readerBuilder) WithRevocationChecker(checker RevocationChecker) ReaderOption {
+	return func(r *Reader) {
+		r.revocationChecker = checker
+	}
+}
+
+// WithKeySource sets the source of public keys, looked up by the kid header of the token. The public key path
+// is not used when a key source is set.
+func (readerBuilder) WithKeySource(keys KeySource) ReaderOption {
+	return func(r *Reader) {
+		r.keySource = keys
+	}
+}
+
+// WithJWKSURL verifies tokens with the keys published at the jwks url given. The key set is cached and fetched
+// again when a token has an unknown key id.
+func (readerBuilder) WithJWKSURL(url string, opts ...RemoteKeySetOption) ReaderOption {
+	return func(r *Reader) {
+		r.keySource = NewRemoteKeySet(url, opts...)
+	}
+}
+
 // NewReader creates a reader. The default options are overriden by any passed in.
 func NewReader(opts ...ReaderOption) Reader {
 	r := Reader{
 		fileReader:    fileutil.FileReader{},
 		publicKeyPath: "app.rsa.pub",
+		clockSkew:     time.Minute,
+		now:           time.Now,
 	}
 	for _, opt := range opts {
 		opt(&r)
@@ -135,26 +202,89 @@ func NewReader(opts ...ReaderOption) Reader {
 type Reader struct {
 	publicKeyPath string
 	fileReader    FileReader
+	keySource     KeySource
+	issuer        string
+	audience      string
+	clockSkew     time.Duration
+	now           func() time.Time
+
+	revocationChecker RevocationChecker
+}
+
+// RevocationChecker checks whether a token has been revoked.
+type RevocationChecker interface {
+	IsRevoked(claims *Claims) (bool, error)
 }
 
-// Read reads a signed Token and returns the Claims.
+// Read reads a signed Token and returns the Claims. The signature, expiry, not before time, issuer and audience
+// are checked, and the token is checked against the revocation checker if there is one. Tokens must have an
+// expiry. The errors returned for an invalid token are ErrMalformedToken, ErrInvalidSignature, ErrMissingExpiry,
+// ErrTokenExpired, ErrTokenNotYetValid, ErrInvalidIssuer, ErrInvalidAudience and ErrTokenRevoked.
 func (r Reader) Read(t Token) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(t.String(), new(Claims), func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		data, err := r.fileReader.Read(r.publicKeyPath)
+	claims := new(Claims)
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(t.String(), claims, r.key)
+	if err != nil {
+		return nil, validationError(err)
+	}
+	if claims.ExpiresAt == 0 {
+		return nil, ErrMissingExpiry
+	}
+	if err = claims.Validate(r.now(), r.clockSkew); err != nil {
+		return nil, err
+	}
+	if r.issuer != "" && claims.Issuer != r.issuer {
+		return nil, ErrInvalidIssuer
+	}
+	if r.audience != "" && !claims.Audience.Contains(r.audience) {
+		return nil, ErrInvalidAudience
+	}
+	if r.revocationChecker != nil {
+		revoked, err := r.revocationChecker.IsRevoked(claims)
 		if err != nil {
 			return nil, err
 		}
-		pubKey, err := jwt.ParseRSAPublicKeyFromPEM(data)
-		if err != nil {
-			return nil, err
+		if revoked {
+			return nil, ErrTokenRevoked
 		}
+	}
+	return claims, nil
+}
+
+func (r Reader) key(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	if r.keySource != nil {
+		kid, _ := token.Header["kid"].(string)
+		return r.keySource.PublicKey(kid)
+	}
+	data, err := r.fileReader.Read(r.publicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := jwt.ParseRSAPublicKeyFromPEM(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return pubKey, nil
+}
 
-		return pubKey, nil
-	})
-	return token.Claims.(*Claims), err
+func validationError(err error) error {
+	ve, ok := err.(*jwt.ValidationError)
+	if !ok {
+		return err
+	}
+	switch {
+	case ve.Errors&jwt.ValidationErrorMalformed != 0:
+		return ErrMalformedToken
+	case ve.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+		return ErrInvalidSignature
+	case ve.Inner != nil:
+		return ve.Inner
+	}
+	return err
 }
 
 // PublicKeyPath returns the path to the public key.
@@ -167,20 +297,104 @@ func (r Reader) FileReader() FileReader {
 	return r.fileReader
 }
 
+var (
+	// ErrMalformedToken is returned when the token can't be decoded.
+	ErrMalformedToken = errors.New("token is malformed")
+	// ErrInvalidSignature is returned when the token signature doesn't match the key it was signed with.
+	ErrInvalidSignature = errors.New("token signature is invalid")
+	// ErrMissingExpiry is returned when the token has no expiry time, such a token would never expire.
+	ErrMissingExpiry = errors.New("token has no expiry")
+	// ErrTokenExpired is returned when the token has expired.
+	ErrTokenExpired = errors.New("token is expired")
+	// ErrTokenNotYetValid is returned when the tokens not before time has not been reached.
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	// ErrInvalidIssuer is returned when the token was issued by another issuer.
+	ErrInvalidIssuer = errors.New("token issuer is invalid")
+	// ErrInvalidAudience is returned when the token was not issued for the audience.
+	ErrInvalidAudience = errors.New("token audience is invalid")
+	// ErrTokenRevoked is returned when the token has been revoked.
+	ErrTokenRevoked = errors.New("token has been revoked")
+)
+
 // Claims are the claims assigned to the JWT token.
 type Claims struct {
-	User      User  `json:"user,omitempty"`
-	ExpiresAt int64 `json:"exp,omitempty"`
-	IssuedAt  int64 `json:"iat,omitempty"`
+	User            User     `json:"user,omitempty"`
+	Issuer          string   `json:"iss,omitempty"`
+	Subject         string   `json:"sub,omitempty"`
+	Audience        Audience `json:"aud,omitempty"`
+	ExpiresAt       int64    `json:"exp,omitempty"`
+	NotBefore       int64    `json:"nbf,omitempty"`
+	IssuedAt        int64    `json:"iat,omitempty"`
+	ID              string   `json:"jti,omitempty"`
+	Scope           string   `json:"scope,omitempty"`
+	ClientID        string   `json:"client_id,omitempty"`
+	AuthorizedParty string   `json:"azp,omitempty"`
 }
 
-func (*Claims) Valid() (err error) {
-	return
+// Scopes returns the space separated scopes in the scope claim.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope returns true if the scope is one of the tokens scopes.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid checks the expiry and not before times against the current time.
+func (c *Claims) Valid() error {
+	return c.Validate(time.Now(), 0)
+}
+
+// Validate checks the expiry and not before times against the time given, allowing for the clock skew.
+// Tokens without an expiry or not before time are not checked for them.
+func (c *Claims) Validate(now time.Time, skew time.Duration) error {
+	if c.ExpiresAt != 0 && now.Add(-skew).Unix() >= c.ExpiresAt {
+		return ErrTokenExpired
+	}
+	if c.NotBefore != 0 && now.Add(skew).Unix() < c.NotBefore {
+		return ErrTokenNotYetValid
+	}
+	return nil
+}
+
+// Audience is the audience of a token. It is decoded from either a single string or an array of strings.
+type Audience []string
+
+// Contains returns true if the audience given is one of the audiences.
+func (a Audience) Contains(audience string) bool {
+	for _, aud := range a {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON decodes the audience from a string or an array of strings.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = Audience(multiple)
+	return nil
 }
 
 type User struct {
-	ID        string `json:"id,omitempty"`
-	FirstName string `json:"first_name,omitempty"`
-	LastName  string `json:"last_name,omitempty"`
-	Email     string `json:"email,omitempty"`
+	ID        string   `json:"id,omitempty"`
+	FirstName string   `json:"first_name,omitempty"`
+	LastName  string   `json:"last_name,omitempty"`
+	Email     string   `json:"email,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
 }
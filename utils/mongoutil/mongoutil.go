@@ -0,0 +1,110 @@
+// Package mongoutil connects to mongo with the official driver, with the pool and read and write concern options
+// shared by the services.
+package mongoutil
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// The read concern levels.
+const (
+	ReadConcernLocal        = "local"
+	ReadConcernAvailable    = "available"
+	ReadConcernMajority     = "majority"
+	ReadConcernLinearizable = "linearizable"
+	ReadConcernSnapshot     = "snapshot"
+)
+
+// WriteConcernMajority acknowledges writes once they are written to the majority of the replica set.
+const WriteConcernMajority = "majority"
+
+// Options configure the client. The options that are not set are taken from the connection string, or are the
+// driver defaults.
+type Options struct {
+	ConnectionString string
+	// MinPoolSize and MaxPoolSize are the least and most connections kept to each server.
+	MinPoolSize uint64
+	MaxPoolSize uint64
+	// ReadConcern is the read concern level, one of the ReadConcern constants.
+	ReadConcern string
+	// WriteConcern is the number of servers that must acknowledge writes, or majority.
+	WriteConcern string
+}
+
+func (o Options) IsValid() error {
+	if o.ConnectionString == "" {
+		return fmt.Errorf("connection string cannot be empty")
+	}
+	if o.MaxPoolSize != 0 && o.MinPoolSize > o.MaxPoolSize {
+		return fmt.Errorf("min pool size %d is larger than the max pool size %d", o.MinPoolSize, o.MaxPoolSize)
+	}
+	switch o.ReadConcern {
+	case "", ReadConcernLocal, ReadConcernAvailable, ReadConcernMajority, ReadConcernLinearizable, ReadConcernSnapshot:
+	default:
+		return fmt.Errorf("invalid read concern %s", o.ReadConcern)
+	}
+	if _, err := o.writeConcern(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (o Options) writeConcern() (*writeconcern.WriteConcern, error) {
+	if o.WriteConcern == "" {
+		return nil, nil
+	}
+	if o.WriteConcern == WriteConcernMajority {
+		return writeconcern.Majority(), nil
+	}
+	w, err := strconv.Atoi(o.WriteConcern)
+	if err != nil || w < 0 {
+		return nil, fmt.Errorf("invalid write concern %s, expecting %s or a number of servers", o.WriteConcern, WriteConcernMajority)
+	}
+	return &writeconcern.WriteConcern{W: w}, nil
+}
+
+// ClientOptions returns the options of the driver.
+func (o Options) ClientOptions() (*options.ClientOptions, error) {
+	if err := o.IsValid(); err != nil {
+		return nil, err
+	}
+	opts := options.Client().ApplyURI(o.ConnectionString)
+	if o.MinPoolSize != 0 {
+		opts.SetMinPoolSize(o.MinPoolSize)
+	}
+	if o.MaxPoolSize != 0 {
+		opts.SetMaxPoolSize(o.MaxPoolSize)
+	}
+	if o.ReadConcern != "" {
+		opts.SetReadConcern(&readconcern.ReadConcern{Level: o.ReadConcern})
+	}
+	if wc, _ := o.writeConcern(); wc != nil {
+		opts.SetWriteConcern(wc)
+	}
+	return opts, nil
+}
+
+// Connect connects to mongo, and pings it to check it can be reached before the context is done.
+func Connect(ctx context.Context, o Options) (*mongo.Client, error) {
+	opts, err := o.ClientOptions()
+	if err != nil {
+		return nil, err
+	}
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,59 @@
+package mongoutil_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/darren-west/app/utils/mongoutil"
+)
+
+func TestOptionsValid(t *testing.T) {
+	tests := map[string]mongoutil.Options{
+		"defaults":     {ConnectionString: "mongodb://localhost"},
+		"pool":         {ConnectionString: "mongodb://localhost", MinPoolSize: 5, MaxPoolSize: 10},
+		"min pool":     {ConnectionString: "mongodb://localhost", MinPoolSize: 5},
+		"concerns":     {ConnectionString: "mongodb://localhost", ReadConcern: mongoutil.ReadConcernMajority, WriteConcern: mongoutil.WriteConcernMajority},
+		"write number": {ConnectionString: "mongodb://localhost", WriteConcern: "2"},
+	}
+	for name, options := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, options.IsValid())
+		})
+	}
+}
+
+func TestOptionsInvalid(t *testing.T) {
+	tests := map[string]struct {
+		options     mongoutil.Options
+		expectedErr string
+	}{
+		"connection string": {options: mongoutil.Options{}, expectedErr: "connection string cannot be empty"},
+		"pool":              {options: mongoutil.Options{ConnectionString: "mongodb://localhost", MinPoolSize: 10, MaxPoolSize: 5}, expectedErr: "min pool size 10 is larger than the max pool size 5"},
+		"read concern":      {options: mongoutil.Options{ConnectionString: "mongodb://localhost", ReadConcern: "eventual"}, expectedErr: "invalid read concern eventual"},
+		"write concern":     {options: mongoutil.Options{ConnectionString: "mongodb://localhost", WriteConcern: "-1"}, expectedErr: "invalid write concern -1, expecting majority or a number of servers"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.EqualError(t, test.options.IsValid(), test.expectedErr)
+			_, err := test.options.ClientOptions()
+			assert.EqualError(t, err, test.expectedErr)
+		})
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	options, err := mongoutil.Options{
+		ConnectionString: "mongodb://localhost/?maxPoolSize=20&w=1",
+		MinPoolSize:      5,
+		ReadConcern:      mongoutil.ReadConcernMajority,
+		WriteConcern:     mongoutil.WriteConcernMajority,
+	}.ClientOptions()
+	require.NoError(t, err)
+
+	assert.Equal(t, uint64(5), *options.MinPoolSize)
+	assert.Equal(t, uint64(20), *options.MaxPoolSize, "options that are not set should be taken from the connection string")
+	assert.Equal(t, mongoutil.ReadConcernMajority, options.ReadConcern.Level)
+	assert.Equal(t, "majority", options.WriteConcern.W)
+}
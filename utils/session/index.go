@@ -0,0 +1,82 @@
+package session
+
+import (
+	"context"
+
+	"github.com/darren-west/app/utils/mongoutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	sessionDataCollection  = "SessionData"
+	userSessionsCollection = "UserSessions"
+)
+
+// NewMongoIndex returns an index of the sessions that belong to each user, stored alongside the
+// session data created by NewMongoStore.
+func NewMongoIndex(options Options) (index *MongoIndex, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	client, err := mongoutil.Connect(ctx, options.client())
+	if err != nil {
+		return
+	}
+	db := client.Database(options.DatabaseName)
+	index = &MongoIndex{sessions: db.Collection(userSessionsCollection), data: db.Collection(sessionDataCollection)}
+	_, err = index.sessions.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}}})
+	return
+}
+
+// MongoIndex records which sessions belong to which user so that all of a users sessions can be revoked.
+type MongoIndex struct {
+	sessions *mongo.Collection
+	data     *mongo.Collection
+}
+
+type userSession struct {
+	SessionID string `bson:"_id"`
+	UserID    string `bson:"user_id"`
+}
+
+// Add records that the session belongs to the user.
+func (i *MongoIndex) Add(ctx context.Context, userID, sessionID string) error {
+	_, err := i.sessions.UpdateOne(ctx,
+		bson.M{"_id": sessionID},
+		bson.M{"$set": userSession{SessionID: sessionID, UserID: userID}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Remove removes the session from the index.
+func (i *MongoIndex) Remove(ctx context.Context, sessionID string) error {
+	_, err := i.sessions.DeleteOne(ctx, bson.M{"_id": sessionID})
+	return err
+}
+
+// RevokeAll deletes the session data of every session belonging to the user. It returns the number of sessions revoked.
+func (i *MongoIndex) RevokeAll(ctx context.Context, userID string) (revoked int, err error) {
+	cursor, err := i.sessions.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return
+	}
+	var sessions []userSession
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return
+	}
+	for _, s := range sessions {
+		if id, err := primitive.ObjectIDFromHex(s.SessionID); err == nil {
+			if _, err = i.data.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+				return revoked, err
+			}
+		}
+		if _, err = i.sessions.DeleteOne(ctx, bson.M{"_id": s.SessionID}); err != nil {
+			return
+		}
+		revoked++
+	}
+	return
+}
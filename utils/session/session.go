@@ -1,52 +1,218 @@
 package session
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"time"
 
-	"github.com/kidstuff/mongostore"
-	mgo "gopkg.in/mgo.v2"
+	"github.com/darren-west/app/utils/mongoutil"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
 	UserSessionName = "user-data"
 )
 
-func NewMongoStore(options Options) (store *mongostore.MongoStore, err error) {
-	session, err := mgo.Dial(options.ConnectionString)
+// connectTimeout is how long the store and index wait for mongo when they are created.
+const connectTimeout = 30 * time.Second
+
+// NewMongoStore returns a store of sessions in mongo. The cookie only holds the id of the session, the values are
+// encrypted with the encryption key and stored in the database. Sessions are stored the same way as they were by
+// github.com/kidstuff/mongostore, so sessions saved by it are still valid.
+func NewMongoStore(options Options) (store *MongoStore, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	client, err := mongoutil.Connect(ctx, options.client())
 	if err != nil {
 		return
 	}
-	store = mongostore.NewMongoStore(
-		session.DB(options.DatabaseName).C("SessionData"),
-		int(options.MaxAge),
-		options.EnsureTTL,
-		[]byte(options.EncryptKey),
-	)
+	store = &MongoStore{
+		Codecs:     securecookie.CodecsFromPairs([]byte(options.EncryptKey)),
+		Options:    &sessions.Options{Path: "/", MaxAge: options.MaxAge},
+		collection: client.Database(options.DatabaseName).Collection(sessionDataCollection),
+	}
+	store.MaxAge(options.MaxAge)
+	if options.EnsureTTL && options.MaxAge > 0 {
+		if err = store.ensureTTL(ctx, options.MaxAge); err != nil {
+			return nil, fmt.Errorf("failed to create the session expiry index: %s", err)
+		}
+	}
 	return
 }
 
+// Options configure the mongo database sessions are stored in. MaxAge is how many seconds sessions last, they last
+// until the browser is closed when it is 0. Expired sessions are deleted from the database when EnsureTTL is set.
 type Options struct {
 	ConnectionString string
-	DatabaseName     string
-	MaxAge           time.Duration
+	DatabaseName     string `validate:"required"`
+	MaxAge           int    `validate:"min=0"`
 	EnsureTTL        bool
-	EncryptKey       string
+	EncryptKey       string `validate:"required"`
+	MinPoolSize      uint64
+	MaxPoolSize      uint64
+	ReadConcern      string
+	WriteConcern     string
 }
 
 func (o Options) IsValid() (err error) {
-	merr := "mongo session invalid: %s"
-	if o.EncryptKey == "" {
-		err = fmt.Errorf(merr, "encryption key cannot be empty")
-		return
+	if err = o.client().IsValid(); err != nil {
+		err = fmt.Errorf("mongo session invalid: %s", err)
 	}
-	if o.ConnectionString == "" {
-		err = fmt.Errorf(merr, "connection string cannot be empty")
-		return
+	return
+}
+
+func (o Options) client() mongoutil.Options {
+	return mongoutil.Options{
+		ConnectionString: o.ConnectionString,
+		MinPoolSize:      o.MinPoolSize,
+		MaxPoolSize:      o.MaxPoolSize,
+		ReadConcern:      o.ReadConcern,
+		WriteConcern:     o.WriteConcern,
 	}
-	if o.DatabaseName == "" {
-		err = fmt.Errorf(merr, "database name cannot be empty")
-		return
+}
+
+// MongoStore is a gorilla sessions.Store that stores sessions in mongo. Instantiate using NewMongoStore.
+type MongoStore struct {
+	Codecs     []securecookie.Codec
+	Options    *sessions.Options
+	collection *mongo.Collection
+}
+
+// sessionData is a stored session, the values are encoded with the codecs of the store.
+type sessionData struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	Data     string             `bson:"data"`
+	Modified time.Time          `bson:"modified"`
+}
+
+// Get returns the session with the name for the request, it is only read from the database once for each request.
+func (s *MongoStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session with the name for the request without adding it to the registry. A new session is
+// returned when the request does not have a valid session cookie, or the session has expired.
+func (s *MongoStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+	session.IsNew = true
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
 	}
-	return
+	if err = securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.Codecs...); err != nil {
+		session.ID = ""
+		return session, nil
+	}
+	found, err := s.load(r.Context(), session)
+	if err != nil {
+		return session, err
+	}
+	if !found {
+		session.ID = ""
+	}
+	session.IsNew = !found
+	return session, nil
+}
+
+// Save stores the session and sets the session cookie, or deletes the session and its cookie when the max age of
+// the session is negative.
+func (s *MongoStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if err := s.delete(r.Context(), session); err != nil {
+			return err
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if session.ID == "" {
+		session.ID = primitive.NewObjectID().Hex()
+	}
+	if err := s.save(r.Context(), session); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Renew deletes the stored session and clears its id, so the session is stored under a new id when it is next
+// saved. Renew sessions when users log in, so a session id known before login can't be used after it.
+func (s *MongoStore) Renew(r *http.Request, session *sessions.Session) error {
+	if err := s.delete(r.Context(), session); err != nil {
+		return err
+	}
+	session.ID = ""
+	return nil
+}
+
+// MaxAge sets the max age of the sessions in seconds. Sessions can be deleted by setting their max age to -1.
+func (s *MongoStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+func (s *MongoStore) ensureTTL(ctx context.Context, maxAge int) error {
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "modified", Value: 1}},
+		Options: options.Index().SetSparse(true).SetExpireAfterSeconds(int32(maxAge)),
+	})
+	return err
+}
+
+// load reads the values of the session, it returns false if the session is not stored.
+func (s *MongoStore) load(ctx context.Context, session *sessions.Session) (bool, error) {
+	id, err := primitive.ObjectIDFromHex(session.ID)
+	if err != nil {
+		return false, nil
+	}
+	var data sessionData
+	err = s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&data)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err = securecookie.DecodeMulti(session.Name(), data.Data, &session.Values, s.Codecs...); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *MongoStore) save(ctx context.Context, session *sessions.Session) error {
+	id, err := primitive.ObjectIDFromHex(session.ID)
+	if err != nil {
+		return fmt.Errorf("invalid session id %s", session.ID)
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	data := sessionData{ID: id, Data: encoded, Modified: time.Now()}
+	_, err = s.collection.ReplaceOne(ctx, bson.M{"_id": id}, &data, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *MongoStore) delete(ctx context.Context, session *sessions.Session) error {
+	id, err := primitive.ObjectIDFromHex(session.ID)
+	if err != nil {
+		return nil
+	}
+	_, err = s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
 }
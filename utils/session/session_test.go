@@ -0,0 +1,79 @@
+package session_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/darren-west/app/utils/session"
+)
+
+func newStore() *session.MongoStore {
+	return &session.MongoStore{
+		Codecs:  securecookie.CodecsFromPairs([]byte("0123456789abcdef0123456789abcdef")),
+		Options: &sessions.Options{Path: "/", MaxAge: 3600},
+	}
+}
+
+func TestMongoStoreNewWithoutCookie(t *testing.T) {
+	s, err := newStore().New(httptest.NewRequest(http.MethodGet, "/", nil), session.UserSessionName)
+	require.NoError(t, err)
+	assert.True(t, s.IsNew)
+	assert.Empty(t, s.ID)
+	assert.Equal(t, 3600, s.Options.MaxAge)
+}
+
+func TestMongoStoreNewInvalidCookie(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: session.UserSessionName, Value: "forged"})
+
+	s, err := newStore().New(r, session.UserSessionName)
+	require.NoError(t, err, "an invalid cookie should give a new session")
+	assert.True(t, s.IsNew)
+	assert.Empty(t, s.ID)
+}
+
+func TestMongoStoreSessionOptionsCopied(t *testing.T) {
+	store := newStore()
+	s, err := store.New(httptest.NewRequest(http.MethodGet, "/", nil), session.UserSessionName)
+	require.NoError(t, err)
+
+	s.Options.MaxAge = -1
+	assert.Equal(t, 3600, store.Options.MaxAge, "changing the options of a session should not change the store")
+}
+
+func TestMongoStoreMaxAge(t *testing.T) {
+	store := newStore()
+	store.MaxAge(60)
+	assert.Equal(t, 60, store.Options.MaxAge)
+}
+
+func TestMongoStoreRenew(t *testing.T) {
+	store := newStore()
+	s := sessions.NewSession(store, session.UserSessionName)
+	s.ID = "planted"
+
+	require.NoError(t, store.Renew(httptest.NewRequest(http.MethodGet, "/", nil), s))
+	assert.Empty(t, s.ID, "the session should be saved under a new id")
+}
+
+func TestMongoStoreNewExpiredCookie(t *testing.T) {
+	store := newStore()
+	store.MaxAge(1)
+	encoded, err := securecookie.EncodeMulti(session.UserSessionName, "5f1e7c2a9d3b4a6c8e0f1a2b", store.Codecs...)
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: session.UserSessionName, Value: encoded})
+
+	time.Sleep(2100 * time.Millisecond)
+	s, err := store.New(r, session.UserSessionName)
+	require.NoError(t, err, "an expired cookie should give a new session")
+	assert.True(t, s.IsNew)
+	assert.Empty(t, s.ID)
+}
@@ -0,0 +1,89 @@
+// +build integration
+
+package session_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/darren-west/app/utils/session"
+)
+
+// newMongoStore returns a store in a new database of the mongo listening on the default port.
+func newMongoStore(t *testing.T, maxAge int) *session.MongoStore {
+	store, err := session.NewMongoStore(session.Options{
+		ConnectionString: "mongodb://127.0.0.1:27017",
+		DatabaseName:     fmt.Sprintf("sessions%d", time.Now().UnixNano()),
+		MaxAge:           maxAge,
+		EncryptKey:       "0123456789abcdef0123456789abcdef",
+	})
+	require.NoError(t, err)
+	return store
+}
+
+// save saves a session with the values and returns the session cookie.
+func save(t *testing.T, store *session.MongoStore, values map[interface{}]interface{}) *http.Cookie {
+	s, err := store.New(httptest.NewRequest(http.MethodGet, "/", nil), session.UserSessionName)
+	require.NoError(t, err)
+	for key, value := range values {
+		s.Values[key] = value
+	}
+	w := httptest.NewRecorder()
+	require.NoError(t, store.Save(httptest.NewRequest(http.MethodGet, "/", nil), w, s))
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	return cookies[0]
+}
+
+// load returns the session for a request with the cookie.
+func load(t *testing.T, store *session.MongoStore, cookie *http.Cookie) *sessions.Session {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	s, err := store.New(r, session.UserSessionName)
+	require.NoError(t, err)
+	return s
+}
+
+func TestMongoStoreSaveLoad(t *testing.T) {
+	store := newMongoStore(t, 86400)
+	assert.Equal(t, 86400, store.Options.MaxAge, "the max age is in seconds")
+
+	cookie := save(t, store, map[interface{}]interface{}{"api-token": "token"})
+	assert.Equal(t, 86400, cookie.MaxAge)
+
+	s := load(t, store, cookie)
+	assert.False(t, s.IsNew)
+	assert.Equal(t, "token", s.Values["api-token"])
+}
+
+func TestMongoStoreExpiry(t *testing.T) {
+	store := newMongoStore(t, 1)
+	cookie := save(t, store, map[interface{}]interface{}{"api-token": "token"})
+
+	time.Sleep(2 * time.Second)
+	s := load(t, store, cookie)
+	assert.True(t, s.IsNew, "the session should have expired")
+	assert.Empty(t, s.Values)
+}
+
+func TestMongoStoreDelete(t *testing.T) {
+	store := newMongoStore(t, 86400)
+	cookie := save(t, store, map[interface{}]interface{}{"api-token": "token"})
+
+	s := load(t, store, cookie)
+	s.Options.MaxAge = -1
+	w := httptest.NewRecorder()
+	require.NoError(t, store.Save(httptest.NewRequest(http.MethodGet, "/", nil), w, s))
+	require.Len(t, w.Result().Cookies(), 1)
+	assert.True(t, w.Result().Cookies()[0].MaxAge < 0, "the session cookie should be deleted")
+
+	s = load(t, store, cookie)
+	assert.True(t, s.IsNew, "the deleted session should not be loaded")
+}
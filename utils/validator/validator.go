@@ -2,7 +2,11 @@ package validator
 
 import (
 	"fmt"
+	"net/url"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 var isValidType = reflect.TypeOf((*IsValid)(nil)).Elem()
@@ -13,26 +17,55 @@ type IsValid interface {
 
 var Default Validator = Validator{}
 
+// Validator validates a value and everything it contains. The IsValid method is called on the value and on
+// every struct field, pointer, slice, array and map element that implements IsValid, and struct fields are
+// checked against the rules in their validate tag. Every failure is collected, with the path of the field it
+// was found in.
+//
+// The rules in a validate tag are separated by commas:
+//
+//	required  the value must not be the zero value, or empty for slices and maps
+//	url       the value must be an absolute url, empty values are not checked
+//	min=N     strings, slices and maps must have a length of at least N, numbers must be at least N
+//	max=N     strings, slices and maps must have a length of at most N, numbers must be at most N
+//	oneof=A B the value must be one of the space separated values
 type Validator struct{}
 
+// IsValid validates the value pointed to by iv. The error returned is Errors when any validation fails.
 func (Validator) IsValid(iv interface{}) (err error) {
 	if err = isInterfaceValid(iv); err != nil {
 		return
 	}
-	traverseFields(iv, func(tmp interface{}) {
-		if isValidImplementor(tmp) {
-			returned := reflect.ValueOf(tmp).MethodByName("IsValid").Call([]reflect.Value{})
-			if returned[0].Interface() != nil {
-				err = returned[0].Interface().(error)
-			}
-			return
-		}
-	})
+	w := walker{visited: make(map[uintptr]bool), reported: make(map[string][]string)}
+	w.walk(reflect.ValueOf(iv), "")
+	if len(w.errs) > 0 {
+		err = w.errs
+	}
 	return
 }
 
-func isValidImplementor(iv interface{}) bool {
-	return reflect.ValueOf(iv).Type().Implements(isValidType)
+// FieldError is a validation failure of the field at the path. The path is empty for the value being validated.
+type FieldError struct {
+	Path string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+// Errors are all the validation failures, in the order they were found.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
 }
 
 func isInterfaceValid(iv interface{}) (err error) {
@@ -48,14 +81,190 @@ func isInterfaceValid(iv interface{}) (err error) {
 	return
 }
 
-func traverseFields(iv interface{}, f func(i interface{})) {
-	f(iv)
-	intype := reflect.ValueOf(iv).Type().Elem()
-	for i := 0; i < intype.NumField(); i++ {
-		switch intype.Field(i).Type.Kind() {
-		case reflect.Struct:
-			traverseFields(reflect.ValueOf(iv).Elem().Field(i).Addr().Interface(), f)
+type walker struct {
+	errs Errors
+	// visited are the pointers already walked, so cycles are only walked once.
+	visited map[uintptr]bool
+	// reported are the paths each error message has been reported at. Types often validate their fields in their
+	// own IsValid, the same failure is not reported again for the field.
+	reported map[string][]string
+}
+
+func (w *walker) report(path string, err error) {
+	message := err.Error()
+	for _, reported := range w.reported[message] {
+		if isAncestor(reported, path) {
+			return
+		}
+	}
+	w.reported[message] = append(w.reported[message], path)
+	w.errs = append(w.errs, &FieldError{Path: path, Err: err})
+}
+
+func isAncestor(ancestor, path string) bool {
+	if ancestor == "" || ancestor == path {
+		return true
+	}
+	return strings.HasPrefix(path, ancestor) && strings.ContainsAny(path[len(ancestor):len(ancestor)+1], ".[")
+}
+
+func (w *walker) walk(v reflect.Value, path string) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || w.visited[v.Pointer()] {
+			return
+		}
+		w.visited[v.Pointer()] = true
+		w.walk(v.Elem(), path)
+		return
+	case reflect.Interface:
+		if !v.IsNil() {
+			w.walk(v.Elem(), path)
+		}
+		return
+	}
+	w.isValid(v, path)
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			fieldPath := join(path, field.Name)
+			if tag := field.Tag.Get("validate"); tag != "" {
+				if err := checkRules(v.Field(i), tag); err != nil {
+					w.report(fieldPath, err)
+				}
+			}
+			w.walk(v.Field(i), fieldPath)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			w.walk(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			w.walk(v.MapIndex(key), fmt.Sprintf("%s[%v]", path, key.Interface()))
+		}
+	}
+}
+
+// isValid calls IsValid on the value if it, or a pointer to it, implements it.
+func (w *walker) isValid(v reflect.Value, path string) {
+	if v.CanAddr() && v.Addr().Type().Implements(isValidType) {
+		v = v.Addr()
+	} else if !v.Type().Implements(isValidType) {
+		return
+	}
+	if err := v.Interface().(IsValid).IsValid(); err != nil {
+		w.report(path, err)
+	}
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// checkRules checks the value against the comma separated rules in the tag.
+func checkRules(v reflect.Value, tag string) error {
+	for _, rule := range strings.Split(tag, ",") {
+		name, param := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+		var err error
+		switch name {
+		case "required":
+			err = checkRequired(v)
+		case "url":
+			err = checkURL(v)
+		case "min":
+			err = checkBound(v, param, false)
+		case "max":
+			err = checkBound(v, param, true)
+		case "oneof":
+			err = checkOneOf(v, strings.Fields(param))
 		default:
+			err = fmt.Errorf("unknown validation rule %s", rule)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkRequired(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		if v.Len() > 0 {
+			return nil
+		}
+	default:
+		if !reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("required field missing")
+}
+
+func checkURL(v reflect.Value) error {
+	if v.Kind() != reflect.String {
+		return fmt.Errorf("url rule is not supported for type %s", v.Type())
+	}
+	if v.String() == "" {
+		return nil
+	}
+	u, err := url.Parse(v.String())
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid url %q", v.String())
+	}
+	return nil
+}
+
+func checkBound(v reflect.Value, param string, max bool) error {
+	bound, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return fmt.Errorf("invalid validation rule bound %q", param)
+	}
+	var value float64
+	var what string
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		value, what = float64(v.Len()), "length"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, what = float64(v.Int()), "value"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value, what = float64(v.Uint()), "value"
+	case reflect.Float32, reflect.Float64:
+		value, what = v.Float(), "value"
+	default:
+		return fmt.Errorf("min and max rules are not supported for type %s", v.Type())
+	}
+	if max && value > bound {
+		return fmt.Errorf("%s must be at most %s", what, param)
+	}
+	if !max && value < bound {
+		return fmt.Errorf("%s must be at least %s", what, param)
+	}
+	return nil
+}
+
+func checkOneOf(v reflect.Value, values []string) error {
+	value := fmt.Sprint(v.Interface())
+	for _, allowed := range values {
+		if value == allowed {
+			return nil
 		}
 	}
+	return fmt.Errorf("%s is not one of %s", value, strings.Join(values, ", "))
 }
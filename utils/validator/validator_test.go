@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/darren-west/app/utils/validator"
 )
@@ -47,3 +48,134 @@ func TestValidatorIncorrectTypeNil(t *testing.T) {
 	err := validator.Validator{}.IsValid(nil)
 	assert.EqualError(t, err, "invalid input: input is nil")
 }
+
+type invalid string
+
+func (i invalid) IsValid() error {
+	if i == "" {
+		return nil
+	}
+	return fmt.Errorf("%s is invalid", string(i))
+}
+
+type pointerReceiver struct {
+	Value string
+}
+
+func (p *pointerReceiver) IsValid() error {
+	if p.Value == "" {
+		return fmt.Errorf("pointer receiver value missing")
+	}
+	return nil
+}
+
+type nested struct {
+	First    invalid
+	Second   invalid
+	Pointer  *pointerReceiver
+	Value    pointerReceiver
+	Slice    []invalid
+	Map      map[string]invalid
+	Iface    validator.IsValid
+	Nil      *pointerReceiver
+	internal invalid
+}
+
+func TestValidatorCollectsAllErrors(t *testing.T) {
+	err := validator.Default.IsValid(&nested{
+		First:    "first",
+		Second:   "second",
+		Pointer:  &pointerReceiver{},
+		Value:    pointerReceiver{Value: "set"},
+		Slice:    []invalid{"", "slice"},
+		Map:      map[string]invalid{"b": "b", "a": "a"},
+		Iface:    invalid("iface"),
+		internal: "internal",
+	})
+
+	require.IsType(t, validator.Errors{}, err)
+	assert.EqualError(t, err, "First: first is invalid; Second: second is invalid; Pointer: pointer receiver value missing; "+
+		"Slice[1]: slice is invalid; Map[a]: a is invalid; Map[b]: b is invalid; Iface: iface is invalid")
+	assert.Equal(t, "Pointer", err.(validator.Errors)[2].Path)
+}
+
+type parent struct {
+	Child child
+}
+
+func (p parent) IsValid() error {
+	return p.Child.IsValid()
+}
+
+type child struct{}
+
+func (child) IsValid() error {
+	return fmt.Errorf("child is invalid")
+}
+
+func TestValidatorParentErrorNotRepeated(t *testing.T) {
+	err := validator.Default.IsValid(&struct {
+		Parent parent
+		Other  child
+	}{})
+	assert.EqualError(t, err, "Parent: child is invalid; Other: child is invalid")
+}
+
+type tagged struct {
+	Name     string            `validate:"required"`
+	Hosts    []string          `validate:"required,min=2"`
+	URL      string            `validate:"url"`
+	Retries  int               `validate:"min=1,max=5"`
+	Format   string            `validate:"oneof=text json"`
+	Labels   map[string]string `validate:"max=1"`
+	Options  *taggedOptions    `validate:"required"`
+	Untagged string
+}
+
+type taggedOptions struct {
+	Name string `validate:"required"`
+}
+
+func TestValidatorTags(t *testing.T) {
+	valid := func() tagged {
+		return tagged{
+			Name:    "name",
+			Hosts:   []string{"a", "b"},
+			URL:     "https://example.com/path",
+			Retries: 3,
+			Format:  "json",
+			Options: &taggedOptions{Name: "nested"},
+		}
+	}
+	tests := map[string]struct {
+		modify      func(*tagged)
+		expectedErr string
+	}{
+		"required string": {modify: func(o *tagged) { o.Name = "" }, expectedErr: "Name: required field missing"},
+		"required slice":  {modify: func(o *tagged) { o.Hosts = []string{} }, expectedErr: "Hosts: required field missing"},
+		"min length":      {modify: func(o *tagged) { o.Hosts = []string{"a"} }, expectedErr: "Hosts: length must be at least 2"},
+		"relative url":    {modify: func(o *tagged) { o.URL = "/path" }, expectedErr: `URL: invalid url "/path"`},
+		"min value":       {modify: func(o *tagged) { o.Retries = 0 }, expectedErr: "Retries: value must be at least 1"},
+		"max value":       {modify: func(o *tagged) { o.Retries = 6 }, expectedErr: "Retries: value must be at most 5"},
+		"one of":          {modify: func(o *tagged) { o.Format = "xml" }, expectedErr: "Format: xml is not one of text, json"},
+		"max length":      {modify: func(o *tagged) { o.Labels = map[string]string{"a": "", "b": ""} }, expectedErr: "Labels: length must be at most 1"},
+		"required ptr":    {modify: func(o *tagged) { o.Options = nil }, expectedErr: "Options: required field missing"},
+		"nested":          {modify: func(o *tagged) { o.Options.Name = "" }, expectedErr: "Options.Name: required field missing"},
+		"several": {modify: func(o *tagged) {
+			o.Name, o.Format = "", ""
+			o.Options.Name = ""
+		}, expectedErr: "Name: required field missing; Format:  is not one of text, json; Options.Name: required field missing"},
+	}
+	for name, test := range tests {
+		o := valid()
+		test.modify(&o)
+		assert.EqualError(t, validator.Default.IsValid(&o), test.expectedErr, name)
+	}
+}
+
+func TestValidatorUnknownTag(t *testing.T) {
+	err := validator.Default.IsValid(&struct {
+		Email string `validate:"email"`
+	}{})
+	assert.EqualError(t, err, "Email: unknown validation rule email")
+}